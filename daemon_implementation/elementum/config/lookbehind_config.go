@@ -18,6 +18,9 @@ package config
 //     LookbehindTime       int   `json:"lookbehind_time"`       // seconds to retain
 //     LookbehindMaxSize    int64 `json:"lookbehind_max_size"`   // max bytes
 //     AutoAdjustLookbehind bool  `json:"auto_adjust_lookbehind"`
+//
+//     // The user's lookbehind settings before the memory constraints
+//     lookbehind lookbehindSetting
 // }
 
 // ============================================================================
@@ -28,11 +31,13 @@ package config
 
 /*
 	// Lookbehind Buffer Settings
-	c.LookbehindEnabled = xbmcHost.GetSettingBool("lookbehind_enabled", true)
 	c.LookbehindTime = xbmcHost.GetSettingInt("lookbehind_time", 30)
 
 	lookbehindMaxSizeMB := xbmcHost.GetSettingInt("lookbehind_max_size", 50)
-	c.LookbehindMaxSize = int64(lookbehindMaxSizeMB) * 1024 * 1024
+	c.lookbehind = lookbehindSetting{
+		enabled: xbmcHost.GetSettingBool("lookbehind_enabled", true),
+		maxSize: int64(lookbehindMaxSizeMB) * 1024 * 1024,
+	}
 
 	c.AutoAdjustLookbehind = xbmcHost.GetSettingBool("auto_adjust_lookbehind", true)
 
@@ -44,37 +49,75 @@ package config
 // ADD NEW FUNCTION
 // ============================================================================
 
+// Smallest lookbehind buffer worth keeping
+const minLookbehindSize = 10 * 1024 * 1024
+
+// lookbehindSetting holds the lookbehind settings as the user configured
+// them. enforceLookbehindConstraints derives LookbehindEnabled and
+// LookbehindMaxSize from it, so a larger memory budget restores what a
+// smaller one took away. Add this type to config/config.go.
+type lookbehindSetting struct {
+	enabled bool
+	maxSize int64
+}
+
 // enforceLookbehindConstraints validates and adjusts lookbehind settings
-// to fit within available memory. Add this function to config/config.go.
+// to fit within available memory, starting from the user's settings every
+// time. Add this function to config/config.go.
 func (c *Configuration) enforceLookbehindConstraints() {
+	c.LookbehindEnabled = c.lookbehind.enabled
+	c.LookbehindMaxSize = c.lookbehind.maxSize
 	if !c.LookbehindEnabled {
 		return
 	}
 
-	// Calculate memory available for lookbehind
 	// Total - Forward Buffer - End Buffer - Overhead
 	reservedMemory := int64(c.BufferSize) + c.EndBufferSize + 8*1024*1024
-	availableForLookbehind := int64(c.MemorySize) - reservedMemory
+	size := lookbehindCap(int64(c.MemorySize), reservedMemory, c.LookbehindMaxSize)
 
-	// Cap at 50% of total memory to leave room for libtorrent internals
-	maxAllowed := int64(c.MemorySize) / 2
-	if availableForLookbehind > maxAllowed {
-		availableForLookbehind = maxAllowed
+	if size == 0 {
+		log.Warning("Insufficient memory for lookbehind (<10MB), disabling")
+		c.LookbehindEnabled = false
+		return
 	}
-
-	// Enforce cap
-	if c.LookbehindMaxSize > availableForLookbehind {
+	if size < c.LookbehindMaxSize {
 		log.Warningf("Lookbehind size %d MB exceeds available %d MB, capping",
-			c.LookbehindMaxSize/1024/1024,
-			availableForLookbehind/1024/1024)
-		c.LookbehindMaxSize = availableForLookbehind
+			c.LookbehindMaxSize/1024/1024, size/1024/1024)
+		c.LookbehindMaxSize = size
 	}
+}
 
-	// Disable if too small to be useful (< 10 MB)
-	if c.LookbehindMaxSize < 10*1024*1024 {
-		log.Warning("Insufficient memory for lookbehind (<10MB), disabling")
-		c.LookbehindEnabled = false
+// lookbehindCap returns the lookbehind size that fits a memory budget: the
+// requested size, capped at what the reserved memory leaves and at half the
+// budget, or 0 when less than minLookbehindSize is left. The 2.0.x service
+// applies the same rule per storage in pieces (lookbehindSize).
+func lookbehindCap(memorySize, reserved, requested int64) int64 {
+	available := memorySize - reserved
+	// Cap at 50% of total memory to leave room for libtorrent internals
+	if half := memorySize / 2; available > half {
+		available = half
+	}
+
+	size := requested
+	if size > available {
+		size = available
 	}
+	if size < minLookbehindSize {
+		return 0
+	}
+	return size
+}
+
+// SetMemorySize updates the memory budget at runtime and re-validates the
+// lookbehind cap against it. Set it as the service's MemorySizeChanged
+// hook, so it runs after every live resize:
+//
+//	serviceConfig.MemorySizeChanged = func(size int64) { c.SetMemorySize(int(size)) }
+//
+// Add this function to config/config.go.
+func (c *Configuration) SetMemorySize(memorySize int) {
+	c.MemorySize = memorySize
+	c.enforceLookbehindConstraints()
 }
 
 // CalculateLookbehindSize determines actual lookbehind size based on video bitrate.
@@ -0,0 +1,81 @@
+// alerts_2.0.x.go - Alert loop for libtorrent 2.0.x
+//
+// One goroutine waits for session alerts, pops them and hands each one to
+// ProcessAlert. Alerts are only valid until the next pop, so ProcessAlert
+// must copy whatever it keeps.
+
+package bittorrent
+
+import (
+	"time"
+
+	lt "github.com/ElementumOrg/libtorrent-go"
+)
+
+// How long one wait for alerts blocks, bounding how late the loop notices
+// a stop request
+const alertWaitTimeout = 500 * time.Millisecond
+
+// Alert categories the service consumes, from libtorrent's alert_category
+const (
+	alertCategoryError         = 1 << 0
+	alertCategoryStorage       = 1 << 3
+	alertCategoryStatus        = 1 << 6
+	alertCategoryPieceProgress = 1 << 22
+
+	serviceAlertMask = alertCategoryError | alertCategoryStorage |
+		alertCategoryStatus | alertCategoryPieceProgress
+)
+
+// alertLoop runs processAlerts until stopped
+type alertLoop struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+func (s *BTService) startAlertLoop() {
+	if s.alerts.stop != nil {
+		return
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	s.alerts.stop, s.alerts.done = stop, done
+
+	go func() {
+		defer close(done)
+		timeout := int(alertWaitTimeout / time.Millisecond)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if s.Session.WaitForAlerts(timeout) {
+				s.processAlerts()
+			}
+		}
+	}()
+}
+
+// stopAlertLoop waits for the loop to exit. Call it before the session is
+// deleted.
+func (s *BTService) stopAlertLoop() {
+	stop, done := s.alerts.stop, s.alerts.done
+	s.alerts.stop, s.alerts.done = nil, nil
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// processAlerts pops every pending alert and dispatches it
+func (s *BTService) processAlerts() {
+	alerts := s.Session.PopAlerts()
+	defer lt.DeleteStdVectorAlerts(alerts)
+
+	for i := 0; i < int(alerts.Size()); i++ {
+		s.ProcessAlert(alerts.Get(i))
+	}
+}
@@ -0,0 +1,178 @@
+// archive_2.0.x.go - Streaming files stored inside RAR sets
+//
+// Connects the RAR virtual file layer to a torrent: the volume headers are
+// fetched first, then an ArchiveStream follows the playback position in
+// inner-file coordinates and translates it into file priorities, piece
+// deadlines, the reader's read-ahead range and the lookbehind window.
+
+package bittorrent
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Default read-ahead of an archive stream in bytes
+const defaultArchiveReadAhead = 32 * 1024 * 1024
+
+// Milliseconds between the deadlines of consecutive read-ahead pieces
+const archiveDeadlineStep = 100
+
+// RarVolumes returns the volumes of the torrent's largest RAR set, in
+// archive order, or nil if it has none
+func (t *Torrent) RarVolumes() ([]TorrentFile, error) {
+	files, err := t.Files()
+	if err != nil {
+		return nil, err
+	}
+	return FindRarVolumes(files), nil
+}
+
+// OpenRarArchive parses the torrent's RAR set. The pieces holding the
+// volume headers are requested first; src reads torrent data and blocks
+// until they arrive.
+func (t *Torrent) OpenRarArchive(src io.ReaderAt) (*RarArchive, error) {
+	volumes, err := t.RarVolumes()
+	if err != nil {
+		return nil, err
+	}
+	if len(volumes) == 0 {
+		return nil, ErrNotRar
+	}
+
+	pieceLength := t.PieceLength()
+	for i, piece := range RarHeaderPieces(volumes, pieceLength) {
+		t.SetPiecePriority(piece, PriorityTop)
+		t.SetPieceDeadline(piece, i*archiveDeadlineStep)
+	}
+
+	return ParseRarArchive(volumes, src)
+}
+
+// ArchiveStream plays one file of a RAR set
+type ArchiveStream struct {
+	torrent     *Torrent
+	archive     *RarArchive
+	file        *ArchiveFile
+	pieceLength int64
+	readAhead   int64
+	readerID    int
+
+	mu       sync.Mutex
+	position int64
+	deadline map[int]bool // read-ahead pieces with a deadline set
+}
+
+// OpenArchiveFile starts streaming a file of the archive. Only the volumes
+// holding it are downloaded. readAhead is in bytes; 0 uses the default.
+func (t *Torrent) OpenArchiveFile(archive *RarArchive, file *ArchiveFile, readAhead int64) (*ArchiveStream, error) {
+	pieceLength := t.PieceLength()
+	if pieceLength == 0 {
+		return nil, ErrNoMetadata
+	}
+	if file == nil || len(file.Segments) == 0 {
+		return nil, fmt.Errorf("archive file has no data")
+	}
+	if readAhead <= 0 {
+		readAhead = defaultArchiveReadAhead
+	}
+
+	indices := make([]int, len(file.Segments))
+	for i, s := range file.Segments {
+		indices[i] = archive.Volumes[s.Volume].Index
+	}
+	if err := t.downloadOnly(indices...); err != nil {
+		return nil, err
+	}
+
+	s := &ArchiveStream{
+		torrent:     t,
+		archive:     archive,
+		file:        file,
+		pieceLength: pieceLength,
+		readAhead:   readAhead,
+		position:    -1,
+		deadline:    make(map[int]bool),
+	}
+	first, last := s.pieceRange(s.file.Pieces(0, readAhead, pieceLength))
+	s.readerID = t.AddReader(first, last)
+	s.UpdatePosition(0)
+	return s, nil
+}
+
+// File returns the streamed archive file
+func (s *ArchiveStream) File() *ArchiveFile {
+	return s.file
+}
+
+// ReaderAt returns a reader of the inner file on top of a reader of the
+// torrent data
+func (s *ArchiveStream) ReaderAt(src io.ReaderAt) io.ReaderAt {
+	return s.file.ReaderAt(src)
+}
+
+// UpdatePosition moves the stream to an offset of the inner file, e.g.
+// after a read or seek. The read-ahead pieces get deadlines and reader
+// protection, and the lookbehind window covers the bytes before offset.
+func (s *ArchiveStream) UpdatePosition(offset int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if offset == s.position {
+		return
+	}
+	s.position = offset
+
+	ahead := s.file.Pieces(offset, s.readAhead, s.pieceLength)
+	wanted := make(map[int]bool, len(ahead))
+	for i, piece := range ahead {
+		wanted[piece] = true
+		if !s.deadline[piece] {
+			s.torrent.SetPieceDeadline(piece, i*archiveDeadlineStep)
+		}
+	}
+	// Pieces left behind by a seek no longer need to be rushed
+	for piece := range s.deadline {
+		if !wanted[piece] {
+			s.torrent.ResetPieceDeadline(piece)
+		}
+	}
+	s.deadline = wanted
+
+	first, last := s.pieceRange(ahead)
+	s.torrent.UpdateReader(s.readerID, first, last)
+
+	if lm := s.torrent.Lookbehind(); lm != nil {
+		file, pieceLength := s.file, s.pieceLength
+		lm.UpdateWindow(s, func(bufferSize int) []int {
+			bytes := int64(bufferSize) * pieceLength
+			return file.Pieces(offset-bytes, bytes, pieceLength)
+		})
+	}
+}
+
+// Close releases the reader and, unless another stream replaced it, the
+// lookbehind window
+func (s *ArchiveStream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for piece := range s.deadline {
+		s.torrent.ResetPieceDeadline(piece)
+	}
+	s.deadline = nil
+	s.torrent.RemoveReader(s.readerID)
+	if lm := s.torrent.Lookbehind(); lm != nil {
+		lm.ReleaseWindow(s)
+	}
+}
+
+// pieceRange returns the first and last of sorted pieces. Read-ahead may
+// span a volume boundary, so the range can include header pieces.
+func (s *ArchiveStream) pieceRange(pieces []int) (int, int) {
+	if len(pieces) == 0 {
+		return 0, -1
+	}
+	return pieces[0], pieces[len(pieces)-1]
+}
@@ -0,0 +1,390 @@
+// bandwidth_2.0.x.go - Bandwidth scheduler for libtorrent 2.0.x
+//
+// Applies session upload/download limits by time of day and weekday, and
+// relaxes them while any torrent is playing so streams are not starved.
+// Playing torrents also get priority over the others: while one plays,
+// the rest are capped to PlaybackBackgroundLimits through the per-torrent
+// throttle. Limits reach the running session through settings_pack
+// updates, so schedule changes never need a session restart.
+
+package bittorrent
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	lt "github.com/ElementumOrg/libtorrent-go"
+)
+
+// Default interval between schedule checks
+const bandwidthCheckInterval = 30 * time.Second
+
+// BandwidthRule limits bandwidth during a daily time window.
+// Limits are in bytes per second; 0 means unlimited.
+type BandwidthRule struct {
+	// Days the rule applies on; empty means every day
+	Days []time.Weekday `json:"days,omitempty"`
+	// Start and End as "HH:MM". End before Start wraps past midnight.
+	Start         string `json:"start"`
+	End           string `json:"end"`
+	DownloadLimit int    `json:"download_limit"`
+	UploadLimit   int    `json:"upload_limit"`
+}
+
+// BandwidthSchedule is the scheduler configuration stored in ServiceConfig
+type BandwidthSchedule struct {
+	Enabled bool `json:"enabled"`
+	// Limits used outside every rule
+	DownloadLimit int `json:"download_limit"`
+	UploadLimit   int `json:"upload_limit"`
+	// Rules are checked in order; the first match wins
+	Rules []BandwidthRule `json:"rules,omitempty"`
+	// Limits used while any torrent is playing, overriding the rules.
+	// The download limit is relaxed to PlaybackDownloadLimit (0 removes it);
+	// the upload limit is capped at PlaybackUploadLimit when that is lower,
+	// so uploads do not compete with the stream.
+	PlaybackDownloadLimit int `json:"playback_download_limit"`
+	PlaybackUploadLimit   int `json:"playback_upload_limit"`
+	// Caps every torrent that is not playing while any torrent plays, so
+	// the stream gets the session bandwidth first. Zero fields leave the
+	// torrents' own limits alone.
+	PlaybackBackgroundLimits TorrentLimits `json:"playback_background_limits"`
+}
+
+// BandwidthLimits is the result of evaluating a schedule at a point in time
+type BandwidthLimits struct {
+	DownloadLimit int
+	UploadLimit   int
+	// Rule is the index of the matching rule, or -1 for the defaults
+	Rule    int
+	Playing bool
+}
+
+// Validate checks rule times and limits. A nil schedule is valid and
+// disables scheduling.
+func (bs *BandwidthSchedule) Validate() error {
+	if bs == nil {
+		return nil
+	}
+	if bs.DownloadLimit < 0 || bs.UploadLimit < 0 ||
+		bs.PlaybackDownloadLimit < 0 || bs.PlaybackUploadLimit < 0 {
+		return fmt.Errorf("bandwidth limits must not be negative")
+	}
+	background := bs.PlaybackBackgroundLimits
+	if background.DownloadLimit < 0 || background.UploadLimit < 0 ||
+		background.MaxConnections < 0 || background.MaxUploads < 0 {
+		return fmt.Errorf("playback background limits must not be negative")
+	}
+	for i, rule := range bs.Rules {
+		if _, err := parseClock(rule.Start); err != nil {
+			return fmt.Errorf("bandwidth rule %d: invalid start: %w", i, err)
+		}
+		if _, err := parseClock(rule.End); err != nil {
+			return fmt.Errorf("bandwidth rule %d: invalid end: %w", i, err)
+		}
+		if rule.DownloadLimit < 0 || rule.UploadLimit < 0 {
+			return fmt.Errorf("bandwidth rule %d: limits must not be negative", i)
+		}
+		for _, day := range rule.Days {
+			if day < time.Sunday || day > time.Saturday {
+				return fmt.Errorf("bandwidth rule %d: invalid weekday %d", i, day)
+			}
+		}
+	}
+	return nil
+}
+
+// LimitsAt returns the limits in effect at t
+func (bs *BandwidthSchedule) LimitsAt(t time.Time, playing bool) BandwidthLimits {
+	limits := BandwidthLimits{
+		DownloadLimit: bs.DownloadLimit,
+		UploadLimit:   bs.UploadLimit,
+		Rule:          -1,
+		Playing:       playing,
+	}
+
+	for i, rule := range bs.Rules {
+		if rule.matches(t) {
+			limits.DownloadLimit = rule.DownloadLimit
+			limits.UploadLimit = rule.UploadLimit
+			limits.Rule = i
+			break
+		}
+	}
+
+	if playing {
+		limits.DownloadLimit = bs.PlaybackDownloadLimit
+		if bs.PlaybackUploadLimit > 0 &&
+			(limits.UploadLimit == 0 || bs.PlaybackUploadLimit < limits.UploadLimit) {
+			limits.UploadLimit = bs.PlaybackUploadLimit
+		}
+	}
+	return limits
+}
+
+// matches reports whether t falls in the rule's window. For windows that
+// wrap past midnight, the early morning part belongs to the previous day.
+func (r BandwidthRule) matches(t time.Time) bool {
+	start, err := parseClock(r.Start)
+	if err != nil {
+		return false
+	}
+	end, err := parseClock(r.End)
+	if err != nil {
+		return false
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+
+	switch {
+	case start == end:
+		// Whole day
+	case start < end:
+		if minute < start || minute >= end {
+			return false
+		}
+	default:
+		if minute < start && minute >= end {
+			return false
+		}
+		if minute < end {
+			day = (day + 6) % 7
+		}
+	}
+
+	if len(r.Days) == 0 {
+		return true
+	}
+	for _, d := range r.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// parseClock parses "HH:MM" into minutes since midnight
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// BandwidthScheduler keeps the session rate limits in line with the
+// schedule and the playback state of the service's torrents
+type BandwidthScheduler struct {
+	service *BTService
+
+	mu       sync.Mutex
+	schedule *BandwidthSchedule
+	applied  *BandwidthLimits
+	now      func() time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewBandwidthScheduler creates a scheduler for the service's schedule
+func NewBandwidthScheduler(service *BTService, schedule *BandwidthSchedule) *BandwidthScheduler {
+	return &BandwidthScheduler{
+		service:  service,
+		schedule: schedule,
+		now:      time.Now,
+	}
+}
+
+// Start checks the schedule every interval until Stop is called
+func (bs *BandwidthScheduler) Start(interval time.Duration) {
+	bs.mu.Lock()
+	if bs.stop != nil {
+		bs.mu.Unlock()
+		return
+	}
+	bs.stop = make(chan struct{})
+	bs.done = make(chan struct{})
+	stop, done := bs.stop, bs.done
+	bs.mu.Unlock()
+
+	bs.Update()
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				bs.Update()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the schedule checks and waits for the loop to exit
+func (bs *BandwidthScheduler) Stop() {
+	bs.mu.Lock()
+	stop, done := bs.stop, bs.done
+	bs.stop, bs.done = nil, nil
+	bs.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// SetSchedule replaces the schedule and applies it immediately. A nil
+// schedule lifts every limit the scheduler set.
+func (bs *BandwidthScheduler) SetSchedule(schedule *BandwidthSchedule) error {
+	if err := schedule.Validate(); err != nil {
+		return err
+	}
+
+	bs.setSchedule(schedule)
+	err := bs.Update()
+	bs.service.updateThrottling()
+	return err
+}
+
+func (bs *BandwidthScheduler) setSchedule(schedule *BandwidthSchedule) {
+	bs.mu.Lock()
+	bs.schedule = schedule
+	bs.mu.Unlock()
+}
+
+// reapply pushes the scheduled limits to the session even if they did not
+// change, after something else overwrote the session's rate limits
+func (bs *BandwidthScheduler) reapply() error {
+	bs.mu.Lock()
+	bs.applied = nil
+	bs.mu.Unlock()
+	return bs.Update()
+}
+
+// backgroundLimits returns the cap for non-playing torrents while a
+// torrent plays, or nil when the schedule sets none
+func (bs *BandwidthScheduler) backgroundLimits() *TorrentLimits {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	if bs.schedule == nil || !bs.schedule.Enabled ||
+		bs.schedule.PlaybackBackgroundLimits == (TorrentLimits{}) {
+		return nil
+	}
+	limits := bs.schedule.PlaybackBackgroundLimits
+	return &limits
+}
+
+// Limits returns the limits last applied to the session, if any
+func (bs *BandwidthScheduler) Limits() (BandwidthLimits, bool) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if bs.applied == nil {
+		return BandwidthLimits{}, false
+	}
+	return *bs.applied, true
+}
+
+// Update evaluates the schedule and pushes new limits to the session when
+// they changed. Called periodically and whenever playback starts or stops.
+func (bs *BandwidthScheduler) Update() error {
+	playing := bs.service.anyPlaying()
+
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	var limits BandwidthLimits
+	if bs.schedule == nil || !bs.schedule.Enabled {
+		// Lift the limits a disabled schedule left behind
+		if bs.applied == nil {
+			return nil
+		}
+		limits = BandwidthLimits{Rule: -1, Playing: playing}
+	} else {
+		limits = bs.schedule.LimitsAt(bs.now(), playing)
+		if bs.applied != nil && *bs.applied == limits {
+			return nil
+		}
+	}
+
+	settings := lt.NewSettingsPack()
+	defer settings.Delete()
+	if err := settings.SetIntValue("download_rate_limit", limits.DownloadLimit); err != nil {
+		return err
+	}
+	if err := settings.SetIntValue("upload_rate_limit", limits.UploadLimit); err != nil {
+		return err
+	}
+	if err := bs.service.Session.ApplySettings(settings); err != nil {
+		return err
+	}
+
+	if bs.schedule == nil || !bs.schedule.Enabled {
+		bs.applied = nil
+	} else {
+		bs.applied = &limits
+	}
+	return nil
+}
+
+// anyPlaying reports whether any torrent is in the playing state
+func (s *BTService) anyPlaying() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, torrent := range s.torrents {
+		if torrent.IsPlaying() {
+			return true
+		}
+	}
+	return false
+}
+
+// SetBandwidthSchedule stores a new schedule in the config and applies it
+// to the running session. A nil schedule removes the schedule and lifts
+// its limits.
+func (s *BTService) SetBandwidthSchedule(schedule *BandwidthSchedule) error {
+	if err := schedule.Validate(); err != nil {
+		return err
+	}
+
+	// The config and the scheduler's schedule change together, so
+	// concurrent calls cannot leave them disagreeing
+	s.bandwidthMu.Lock()
+	s.config.BandwidthSchedule = schedule
+	bs := s.bandwidth
+	created := false
+	if bs == nil {
+		if schedule == nil {
+			s.bandwidthMu.Unlock()
+			return nil
+		}
+		bs = NewBandwidthScheduler(s, schedule)
+		s.bandwidth = bs
+		created = true
+	} else {
+		bs.setSchedule(schedule)
+	}
+	s.bandwidthMu.Unlock()
+
+	var err error
+	if created {
+		bs.Start(bandwidthCheckInterval)
+	} else {
+		err = bs.Update()
+	}
+	s.updateThrottling()
+	return err
+}
+
+// BandwidthScheduler returns the scheduler, or nil if no schedule is set
+func (s *BTService) BandwidthScheduler() *BandwidthScheduler {
+	s.bandwidthMu.Lock()
+	defer s.bandwidthMu.Unlock()
+	return s.bandwidth
+}
@@ -0,0 +1,356 @@
+// categories_2.0.x.go - Torrent categories and labels for libtorrent 2.0.x
+//
+// Every torrent belongs to a category (movies, shows, anime, other), which
+// provides its default save path and seeding policy, and can carry
+// free-form labels. Category and labels are stored in the torrent's resume
+// data under the "elementum" key, so they come back when the torrent is
+// re-added with AddTorrentFromResumeData.
+//
+// Categories cannot pick a storage backend: in 2.0.x disk I/O is set up
+// once per session, so every torrent uses the session's backend. A category
+// asking for another backend is rejected with ErrStorageBackend.
+
+package bittorrent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	lt "github.com/ElementumOrg/libtorrent-go"
+)
+
+// Built-in category names
+const (
+	CategoryMovies = "movies"
+	CategoryShows  = "shows"
+	CategoryAnime  = "anime"
+	CategoryOther  = "other"
+)
+
+// StorageBackend is where the session keeps torrent data
+type StorageBackend string
+
+const (
+	StorageMemory StorageBackend = "memory"
+	StorageDisk   StorageBackend = "disk"
+)
+
+// ErrStorageBackend is returned for a category whose storage backend is
+// not the session's
+var ErrStorageBackend = errors.New("storage backend not available")
+
+// Category groups torrents that share a save path and seeding policy
+type Category struct {
+	Name string `json:"name"`
+	// SavePath is the default save path; empty uses DownloadPath/<name>
+	SavePath string `json:"save_path,omitempty"`
+	// Storage is empty or the session's backend, see BTService.Storage
+	Storage StorageBackend `json:"storage,omitempty"`
+	// SeedingPolicy overrides ServiceConfig.SeedingPolicy when set
+	SeedingPolicy *SeedingPolicy `json:"seeding_policy,omitempty"`
+}
+
+// DefaultCategories returns the built-in categories
+func DefaultCategories() map[string]*Category {
+	return map[string]*Category{
+		CategoryMovies: {Name: CategoryMovies},
+		CategoryShows:  {Name: CategoryShows},
+		CategoryAnime:  {Name: CategoryAnime},
+		CategoryOther:  {Name: CategoryOther},
+	}
+}
+
+// AddTorrentOptions holds optional AddTorrentWithOptions parameters
+type AddTorrentOptions struct {
+	// SavePath overrides the category save path
+	SavePath string
+	// Category defaults to CategoryOther
+	Category string
+	Labels   []string
+}
+
+func (o AddTorrentOptions) metadata() TorrentMetadata {
+	return TorrentMetadata{Category: o.Category, Labels: o.Labels}
+}
+
+// TorrentMetadata is the Elementum data persisted in resume data
+type TorrentMetadata struct {
+	Category string   `json:"category,omitempty"`
+	Labels   []string `json:"labels,omitempty"`
+}
+
+// torrentMeta holds a torrent's category and labels
+type torrentMeta struct {
+	mu       sync.RWMutex
+	category string
+	labels   []string
+}
+
+// Category returns the category, or nil if unknown
+func (s *BTService) Category(name string) *Category {
+	s.categoriesMu.RLock()
+	defer s.categoriesMu.RUnlock()
+
+	if s.config.Categories != nil {
+		if c, ok := s.config.Categories[name]; ok {
+			return c
+		}
+	}
+	return DefaultCategories()[name]
+}
+
+// SetCategory adds or replaces a category in the config. The category is
+// copied, so later changes to it have no effect.
+func (s *BTService) SetCategory(category *Category) error {
+	if err := s.validateCategory(category); err != nil {
+		return err
+	}
+
+	c := *category
+	s.categoriesMu.Lock()
+	if s.config.Categories == nil {
+		s.config.Categories = DefaultCategories()
+	}
+	s.config.Categories[c.Name] = &c
+	s.categoriesMu.Unlock()
+
+	if category.SeedingPolicy != nil && category.SeedingPolicy.Enabled {
+		s.startSeedingChecks(seedingCheckInterval)
+	}
+	return nil
+}
+
+// validateCategory checks a category before it is used
+func (s *BTService) validateCategory(c *Category) error {
+	if c == nil || c.Name == "" {
+		return fmt.Errorf("category has no name")
+	}
+	if c.SeedingPolicy != nil {
+		if err := c.SeedingPolicy.Validate(); err != nil {
+			return fmt.Errorf("category %s: %w", c.Name, err)
+		}
+	}
+	if c.Storage != "" && c.Storage != s.Storage() {
+		return fmt.Errorf("category %s: %w: %s, the session uses %s", c.Name, ErrStorageBackend, c.Storage, s.Storage())
+	}
+	return nil
+}
+
+// categorySavePath returns the save path for a category
+func (s *BTService) categorySavePath(c *Category) string {
+	if c.SavePath != "" {
+		return c.SavePath
+	}
+	return filepath.Join(s.config.DownloadPath, c.Name)
+}
+
+// resolveSavePath picks the save path for a new torrent
+func (s *BTService) resolveSavePath(opts AddTorrentOptions) (string, error) {
+	if opts.SavePath != "" {
+		return opts.SavePath, nil
+	}
+	name := opts.Category
+	if name == "" {
+		name = CategoryOther
+	}
+	c := s.Category(name)
+	if c == nil {
+		return "", fmt.Errorf("unknown category: %s", name)
+	}
+	return s.categorySavePath(c), nil
+}
+
+// Storage returns the backend the session was created with.
+// In 2.0.x disk I/O is session-wide, so every torrent shares it.
+func (s *BTService) Storage() StorageBackend {
+	if s.memorySize() > 0 {
+		return StorageMemory
+	}
+	return StorageDisk
+}
+
+// seedingPolicyFor returns the policy that applies to a torrent: its
+// category's policy if it has one, else the service-wide policy
+func (s *BTService) seedingPolicyFor(t *Torrent) *SeedingPolicy {
+	if c := s.Category(t.Category()); c != nil && c.SeedingPolicy != nil {
+		return c.SeedingPolicy
+	}
+	return s.config.SeedingPolicy
+}
+
+// Torrent category and labels
+
+func (t *Torrent) setMetadata(meta TorrentMetadata) {
+	t.meta.mu.Lock()
+	defer t.meta.mu.Unlock()
+
+	t.meta.category = meta.Category
+	if t.meta.category == "" {
+		t.meta.category = CategoryOther
+	}
+	t.meta.labels = normalizeLabels(meta.Labels)
+}
+
+// Metadata returns the torrent's category and labels
+func (t *Torrent) Metadata() TorrentMetadata {
+	t.meta.mu.RLock()
+	defer t.meta.mu.RUnlock()
+	return TorrentMetadata{
+		Category: t.meta.category,
+		Labels:   append([]string(nil), t.meta.labels...),
+	}
+}
+
+// Category returns the torrent's category name
+func (t *Torrent) Category() string {
+	t.meta.mu.RLock()
+	defer t.meta.mu.RUnlock()
+	return t.meta.category
+}
+
+// SetCategory moves the torrent to another category. Data is not moved;
+// the new save path applies to torrents added afterwards.
+func (t *Torrent) SetCategory(name string) error {
+	if t.service != nil && t.service.Category(name) == nil {
+		return fmt.Errorf("unknown category: %s", name)
+	}
+	t.meta.mu.Lock()
+	t.meta.category = name
+	t.meta.mu.Unlock()
+	return nil
+}
+
+// Labels returns the torrent's labels, sorted
+func (t *Torrent) Labels() []string {
+	return t.Metadata().Labels
+}
+
+// HasLabel reports whether the torrent carries a label
+func (t *Torrent) HasLabel(label string) bool {
+	label = strings.TrimSpace(label)
+	t.meta.mu.RLock()
+	defer t.meta.mu.RUnlock()
+	for _, l := range t.meta.labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+// SetLabels replaces the torrent's labels
+func (t *Torrent) SetLabels(labels []string) {
+	t.meta.mu.Lock()
+	t.meta.labels = normalizeLabels(labels)
+	t.meta.mu.Unlock()
+}
+
+// AddLabel adds a label to the torrent
+func (t *Torrent) AddLabel(label string) {
+	t.meta.mu.Lock()
+	t.meta.labels = normalizeLabels(append(t.meta.labels, label))
+	t.meta.mu.Unlock()
+}
+
+// RemoveLabel removes a label from the torrent
+func (t *Torrent) RemoveLabel(label string) {
+	label = strings.TrimSpace(label)
+	t.meta.mu.Lock()
+	defer t.meta.mu.Unlock()
+	for i, l := range t.meta.labels {
+		if l == label {
+			t.meta.labels = append(t.meta.labels[:i], t.meta.labels[i+1:]...)
+			return
+		}
+	}
+}
+
+// normalizeLabels trims, de-duplicates and sorts labels
+func normalizeLabels(labels []string) []string {
+	seen := make(map[string]bool, len(labels))
+	out := make([]string, 0, len(labels))
+	for _, l := range labels {
+		l = strings.TrimSpace(l)
+		if l == "" || seen[l] {
+			continue
+		}
+		seen[l] = true
+		out = append(out, l)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Resume data persistence
+
+// ResumeDataBuf encodes the resume data from a save_resume_data_alert
+// together with the torrent's category and labels
+func (t *Torrent) ResumeDataBuf(alert lt.SaveResumeDataAlert) ([]byte, error) {
+	meta, err := json.Marshal(t.Metadata())
+	if err != nil {
+		return nil, err
+	}
+
+	vec := alert.GetResumeDataBufWithMetadata(string(meta))
+	defer lt.DeleteStdVectorChar(vec)
+
+	return stdVectorCharBytes(vec), nil
+}
+
+// AddTorrentFromResumeData re-adds a torrent from resume data written by
+// Torrent.ResumeDataBuf, restoring its category and labels. Resume data
+// without Elementum metadata is added to CategoryOther.
+func (s *BTService) AddTorrentFromResumeData(buf []byte) (*Torrent, error) {
+	vec := lt.NewStdVectorCharFromBytes(string(buf))
+	defer lt.DeleteStdVectorChar(vec)
+
+	params, err := lt.ReadResumeDataBuf(vec)
+	if err != nil {
+		return nil, err
+	}
+
+	var meta TorrentMetadata
+	if raw := lt.ReadResumeMetadata(vec); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &meta); err != nil {
+			log.Warningf("Ignoring invalid torrent metadata in resume data: %s", err)
+		}
+	}
+	return s.addTorrentParams(params, meta)
+}
+
+// Listing
+
+// TorrentFilter selects torrents by category and label.
+// Empty fields match every torrent.
+type TorrentFilter struct {
+	Category string
+	Label    string
+}
+
+// Matches reports whether a torrent passes the filter
+func (f TorrentFilter) Matches(t *Torrent) bool {
+	if f.Category != "" && t.Category() != f.Category {
+		return false
+	}
+	if f.Label != "" && !t.HasLabel(f.Label) {
+		return false
+	}
+	return true
+}
+
+// ListTorrents returns the torrents matching filter in queue order
+func (s *BTService) ListTorrents(filter TorrentFilter) []*Torrent {
+	var torrents []*Torrent
+	for _, infoHash := range s.QueueOrder() {
+		t := s.GetTorrent(infoHash)
+		if t != nil && filter.Matches(t) {
+			torrents = append(torrents, t)
+		}
+	}
+	return torrents
+}
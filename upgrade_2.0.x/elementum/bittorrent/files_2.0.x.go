@@ -0,0 +1,225 @@
+// files_2.0.x.go - File model and selective download for libtorrent 2.0.x
+//
+// Files lists a torrent's files with their layout, progress and priority.
+// SetFilePriorities changes every priority in one call, and
+// DownloadOnlyFile selects a single file plus the subtitles that go with
+// it, which is what a player needs from a season pack.
+
+package bittorrent
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	lt "github.com/ElementumOrg/libtorrent-go"
+)
+
+// File priorities (libtorrent download_priority_t)
+const (
+	PriorityDontDownload = 0
+	PriorityLow          = 1
+	PriorityDefault      = 4
+	PriorityTop          = 7
+)
+
+// ErrNoMetadata is returned by file operations before metadata is received
+var ErrNoMetadata = errors.New("torrent has no metadata yet")
+
+// Subtitle extensions DownloadOnlyFile keeps alongside the selected file
+var subtitleExtensions = map[string]bool{
+	".srt": true,
+	".ass": true,
+	".sub": true,
+}
+
+// TorrentFile describes one file of a torrent
+type TorrentFile struct {
+	Index int
+	// Path inside the torrent, with the torrent name as first element
+	Path   string
+	Size   int64
+	Offset int64 // byte offset in the torrent's concatenated data
+	// Downloaded counts bytes of completed pieces only
+	Downloaded int64
+	Progress   float64 // 0 to 1
+	Priority   int
+}
+
+// Name returns the file name without directories
+func (f TorrentFile) Name() string {
+	return path.Base(f.Path)
+}
+
+// IsSubtitle reports whether the file has a subtitle extension
+func (f TorrentFile) IsSubtitle() bool {
+	return subtitleExtensions[strings.ToLower(path.Ext(f.Path))]
+}
+
+// Files returns the torrent's files in index order, leaving out the pad
+// files of v2 and hybrid torrents. Index is the libtorrent file index, so
+// it skips the pad files' indices.
+func (t *Torrent) Files() ([]TorrentFile, error) {
+	ti := t.Handle.TorrentFile()
+	if ti == nil {
+		return nil, ErrNoMetadata
+	}
+
+	progress := t.Handle.FileProgressBytes()
+	defer lt.DeleteStdVectorInt64(progress)
+	priorities := t.Handle.FilePrioritiesInt()
+	defer lt.DeleteStdVectorInt(priorities)
+
+	files := make([]TorrentFile, 0, ti.NumFilesInt())
+	for i := 0; i < ti.NumFilesInt(); i++ {
+		if ti.FileIsPadAt(i) {
+			continue
+		}
+		f := TorrentFile{
+			Index:  i,
+			Path:   filepathToSlash(ti.FilePathAt(i)),
+			Size:   ti.FileSizeAt(i),
+			Offset: ti.FileOffsetAt(i),
+		}
+		if i < int(progress.Size()) {
+			f.Downloaded = progress.Get(i)
+		}
+		if i < int(priorities.Size()) {
+			f.Priority = priorities.Get(i)
+		}
+		if f.Size > 0 {
+			f.Progress = float64(f.Downloaded) / float64(f.Size)
+		} else {
+			f.Progress = 1
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// SetFilePriorities sets the priority of every file in one call.
+// priorities is indexed by file index; files past its end keep theirs.
+func (t *Torrent) SetFilePriorities(priorities []int) error {
+	ti := t.Handle.TorrentFile()
+	if ti == nil {
+		return ErrNoMetadata
+	}
+	numFiles := ti.NumFilesInt()
+	if len(priorities) > numFiles {
+		return fmt.Errorf("%d priorities for %d files", len(priorities), numFiles)
+	}
+	for i, p := range priorities {
+		if p < PriorityDontDownload || p > PriorityTop {
+			return fmt.Errorf("file %d: priority %d out of range", i, p)
+		}
+	}
+
+	// libtorrent resets files past the end of the list to the default
+	// priority, so pass the current priority for those
+	current := t.Handle.FilePrioritiesInt()
+	defer lt.DeleteStdVectorInt(current)
+
+	vec := lt.NewStdVectorInt()
+	defer lt.DeleteStdVectorInt(vec)
+	for i := 0; i < numFiles; i++ {
+		switch {
+		case i < len(priorities):
+			vec.Add(priorities[i])
+		case i < int(current.Size()):
+			vec.Add(current.Get(i))
+		default:
+			vec.Add(PriorityDefault)
+		}
+	}
+	t.Handle.PrioritizeFilesInt(vec)
+	return nil
+}
+
+// downloadOnly downloads the files with the given indices and skips every
+// other file
+func (t *Torrent) downloadOnly(indices ...int) error {
+	ti := t.Handle.TorrentFile()
+	if ti == nil {
+		return ErrNoMetadata
+	}
+	priorities := make([]int, ti.NumFilesInt())
+	for _, i := range indices {
+		if i < 0 || i >= len(priorities) {
+			return fmt.Errorf("file index %d out of range", i)
+		}
+		priorities[i] = PriorityDefault
+	}
+	return t.SetFilePriorities(priorities)
+}
+
+// DownloadOnlyFile downloads the file with the given file index and its
+// subtitles and skips every other file. It returns the selected files, the
+// chosen one first.
+func (t *Torrent) DownloadOnlyFile(index int) ([]TorrentFile, error) {
+	files, err := t.Files()
+	if err != nil {
+		return nil, err
+	}
+	pos := -1
+	for i, f := range files {
+		if f.Index == index {
+			pos = i
+			break
+		}
+	}
+	if pos < 0 {
+		return nil, fmt.Errorf("file index %d out of range", index)
+	}
+
+	selected := append([]TorrentFile{files[pos]}, SubtitlesFor(files, pos)...)
+
+	indices := make([]int, len(selected))
+	for i, f := range selected {
+		indices[i] = f.Index
+	}
+	if err := t.downloadOnly(indices...); err != nil {
+		return nil, err
+	}
+
+	for i := range selected {
+		selected[i].Priority = PriorityDefault
+	}
+	return selected, nil
+}
+
+// SubtitlesFor returns the subtitle files that belong to files[index]:
+// those in the same directory, or a subdirectory of it, whose name starts
+// with the file's base name, e.g. "Movie.en.srt" or "Subs/Movie.srt" for
+// "Movie.mkv"
+func SubtitlesFor(files []TorrentFile, index int) []TorrentFile {
+	if index < 0 || index >= len(files) {
+		return nil
+	}
+	video := files[index]
+	dir := ""
+	if d := path.Dir(video.Path); d != "." {
+		dir = d + "/"
+	}
+	base := strings.ToLower(strings.TrimSuffix(video.Name(), path.Ext(video.Path)))
+
+	var subs []TorrentFile
+	for _, f := range files {
+		if f.Index == video.Index || !f.IsSubtitle() || !strings.HasPrefix(f.Path, dir) {
+			continue
+		}
+		name := strings.ToLower(strings.TrimSuffix(f.Name(), path.Ext(f.Path)))
+		// Either the exact name or the name followed by a separator, so
+		// "Show.S01E01" does not pick up "Show.S01E010.srt"
+		if name == base || (strings.HasPrefix(name, base) && strings.ContainsRune(".-_ [(", rune(name[len(base)]))) {
+			subs = append(subs, f)
+		}
+	}
+	return subs
+}
+
+// filepathToSlash normalizes libtorrent paths, which use the platform
+// separator, to forward slashes
+func filepathToSlash(p string) string {
+	return strings.ReplaceAll(p, "\\", "/")
+}
@@ -21,6 +21,17 @@ type LookbehindManager struct {
 	currentPiece    int
 	protectedPieces []int
 	isEnabled       bool
+
+	// Buffer limit of the torrent's memory storage in pieces, which caps
+	// BufferSize through lookbehindSize (0 = unknown, no cap)
+	storageLimit int
+
+	// Maps the buffer size to the pieces to protect when the played file
+	// is not contiguous in the torrent, e.g. inside a RAR set (nil = the
+	// pieces right before currentPiece)
+	window func(bufferSize int) []int
+	// Who set window, for ReleaseWindow
+	windowOwner interface{}
 }
 
 // LookbehindConfig holds lookbehind buffer configuration
@@ -65,16 +76,27 @@ func (lm *LookbehindManager) updatePositionLocked(currentPiece int) {
 	}
 
 	lm.currentPiece = currentPiece
+	lm.rebuildLocked()
+}
+
+// rebuildLocked recalculates protected pieces for the current position.
+// Called with lock already held.
+func (lm *LookbehindManager) rebuildLocked() {
+	if lm.window != nil {
+		lm.protectedPieces = append(lm.protectedPieces[:0], lm.window(lm.effectiveBufferSizeLocked())...)
+		lm.torrent.SetLookbehindPieces(lm.protectedPieces)
+		return
+	}
 
 	// Calculate pieces to protect (behind current position)
-	startPiece := currentPiece - lm.config.BufferSize
+	startPiece := lm.currentPiece - lm.effectiveBufferSizeLocked()
 	if startPiece < 0 {
 		startPiece = 0
 	}
 
 	// Build list of pieces to protect
 	lm.protectedPieces = lm.protectedPieces[:0]
-	for piece := startPiece; piece < currentPiece; piece++ {
+	for piece := startPiece; piece < lm.currentPiece; piece++ {
 		lm.protectedPieces = append(lm.protectedPieces, piece)
 	}
 
@@ -82,6 +104,29 @@ func (lm *LookbehindManager) updatePositionLocked(currentPiece int) {
 	lm.torrent.SetLookbehindPieces(lm.protectedPieces)
 }
 
+// effectiveBufferSizeLocked returns the configured buffer size limited by
+// the storage's memory budget
+func (lm *LookbehindManager) effectiveBufferSizeLocked() int {
+	return lookbehindSize(lm.config.BufferSize, lm.config.MinBuffer, lm.storageLimit)
+}
+
+// lookbehindSize returns the lookbehind window in pieces for a storage
+// holding bufferLimit pieces (0 = unknown): the configured bufferSize,
+// capped at half the storage so the forward buffer keeps the other half.
+// A cap below minBuffer disables lookbehind, as so short a window is not
+// worth the memory. The daemon's Configuration.enforceLookbehindConstraints
+// applies the same rule to its byte budget.
+func lookbehindSize(bufferSize, minBuffer, bufferLimit int) int {
+	limit := bufferLimit / 2
+	if bufferLimit <= 0 || bufferSize <= limit {
+		return bufferSize
+	}
+	if limit < minBuffer || limit < 1 {
+		return 0
+	}
+	return limit
+}
+
 // UpdatePosition updates the lookbehind buffer based on current playback position
 func (lm *LookbehindManager) UpdatePosition(currentPiece int) {
 	if !lm.isEnabled {
@@ -91,16 +136,49 @@ func (lm *LookbehindManager) UpdatePosition(currentPiece int) {
 	lm.mu.Lock()
 	defer lm.mu.Unlock()
 
+	lm.window, lm.windowOwner = nil, nil
 	lm.updatePositionLocked(currentPiece)
 }
 
+// UpdateWindow protects the pieces returned by window instead of the ones
+// before a piece position. window receives the buffer size in pieces and
+// is called again when the size changes. owner identifies the caller for
+// ReleaseWindow and must be comparable.
+func (lm *LookbehindManager) UpdateWindow(owner interface{}, window func(bufferSize int) []int) {
+	if !lm.isEnabled {
+		return
+	}
+
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	lm.window, lm.windowOwner = window, owner
+	lm.rebuildLocked()
+}
+
+// ReleaseWindow clears the lookbehind buffer if it still holds owner's
+// window. A window or position set since by someone else is kept.
+func (lm *LookbehindManager) ReleaseWindow(owner interface{}) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	if lm.window == nil || lm.windowOwner != owner {
+		return
+	}
+	lm.clearLocked()
+}
+
 // Clear clears the lookbehind buffer
 func (lm *LookbehindManager) Clear() {
 	lm.mu.Lock()
 	defer lm.mu.Unlock()
+	lm.clearLocked()
+}
 
+func (lm *LookbehindManager) clearLocked() {
 	lm.protectedPieces = lm.protectedPieces[:0]
 	lm.currentPiece = 0
+	lm.window, lm.windowOwner = nil, nil
 	lm.torrent.ClearLookbehind()
 }
 
@@ -154,7 +232,25 @@ func (lm *LookbehindManager) SetBufferSize(size int) {
 	lm.config.BufferSize = size
 	// Re-calculate protected pieces with new size
 	if len(lm.protectedPieces) > 0 {
-		lm.updatePositionLocked(lm.currentPiece)
+		lm.rebuildLocked()
+	}
+}
+
+// SetStorageLimit caps the buffer size for a memory storage holding
+// bufferLimit pieces, e.g. after the memory budget changed. The cap is
+// recomputed from the configured size every time, so a larger budget
+// restores what a smaller one took away. Pass 0 to remove the cap.
+func (lm *LookbehindManager) SetStorageLimit(bufferLimit int) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	if lm.storageLimit == bufferLimit {
+		return
+	}
+
+	lm.storageLimit = bufferLimit
+	if len(lm.protectedPieces) > 0 || lm.window != nil {
+		lm.rebuildLocked()
 	}
 }
 
@@ -162,5 +258,5 @@ func (lm *LookbehindManager) SetBufferSize(size int) {
 func (lm *LookbehindManager) GetBufferSize() int {
 	lm.mu.RLock()
 	defer lm.mu.RUnlock()
-	return lm.config.BufferSize
+	return lm.effectiveBufferSizeLocked()
 }
@@ -0,0 +1,48 @@
+package bittorrent
+
+import "testing"
+
+func TestLookbehindSize(t *testing.T) {
+	tests := []struct {
+		name                               string
+		bufferSize, minBuffer, bufferLimit int
+		want                               int
+	}{
+		{"no storage limit", 10, 5, 0, 10},
+		{"fits in half the storage", 10, 5, 100, 10},
+		{"exactly half", 10, 5, 20, 10},
+		{"capped at half", 10, 5, 16, 8},
+		{"cap below minimum disables", 10, 5, 8, 0},
+		{"cap at minimum", 10, 5, 10, 5},
+		{"small size below minimum kept", 3, 5, 100, 3},
+		{"no minimum, tiny storage", 10, 0, 1, 0},
+		{"no minimum, two pieces", 10, 0, 2, 1},
+	}
+
+	for _, tt := range tests {
+		if got := lookbehindSize(tt.bufferSize, tt.minBuffer, tt.bufferLimit); got != tt.want {
+			t.Errorf("%s: lookbehindSize(%d, %d, %d) = %d, want %d",
+				tt.name, tt.bufferSize, tt.minBuffer, tt.bufferLimit, got, tt.want)
+		}
+	}
+}
+
+// TestLookbehindSizeRecovers restores the configured size once the storage
+// grows again, whatever an earlier shrink capped it to
+func TestLookbehindSizeRecovers(t *testing.T) {
+	config := DefaultLookbehindConfig() // 10 pieces, at least 5
+	steps := []struct {
+		limit int
+		want  int
+	}{
+		{100, 10},
+		{12, 6},
+		{4, 0},
+		{100, 10},
+	}
+	for _, step := range steps {
+		if got := lookbehindSize(config.BufferSize, config.MinBuffer, step.limit); got != step.want {
+			t.Errorf("Storage limit %d: got %d pieces, want %d", step.limit, got, step.want)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+// metadata_2.0.x.go - Magnet metadata fetching for libtorrent 2.0.x
+//
+// FetchMetadata adds a magnet in upload mode, so it joins the swarm and
+// downloads the info dictionary through ut_metadata without requesting any
+// pieces, then removes it again once the metadata arrived. The torrent is
+// never registered with the service, so it takes no queue slot.
+//
+// Completion is signalled by metadata_received_alert, which the service's
+// alert loop forwards through ProcessAlert. The handle is also polled, so a
+// fetch still completes if the alert is late. Metadata found in
+// the metadata cache is returned without contacting the swarm, and fetched
+// metadata is added to it. Adding the torrent while a fetch runs takes the
+// fetch's handle over and starts the download on it.
+
+package bittorrent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	lt "github.com/ElementumOrg/libtorrent-go"
+)
+
+// Interval between metadata checks on the handle while waiting
+const metadataPollInterval = 500 * time.Millisecond
+
+// ErrMetadataFailed is returned when the metadata cannot be read back
+var ErrMetadataFailed = errors.New("metadata fetch failed")
+
+// metadataFetches tracks in-flight fetches by v1 info hash, so concurrent
+// fetches of the same magnet share one temporary torrent
+type metadataFetches struct {
+	mu      sync.Mutex
+	fetches map[string]*metadataFetch
+}
+
+type metadataFetch struct {
+	handle   *lt.TorrentHandle
+	owned    bool   // added by the fetch, removed when the last caller is done
+	savePath string // where an owned fetch put the torrent
+	refs     int
+
+	done chan struct{} // closed on metadata_received_alert
+	once sync.Once
+}
+
+func (f *metadataFetch) finish() {
+	f.once.Do(func() { close(f.done) })
+}
+
+// FetchMetadata returns the torrent_info of a magnet link without starting
+// the download. It blocks until the metadata arrives or ctx is done. The
+// caller owns the returned torrent_info and frees it with
+// lt.DeleteTorrentInfo.
+func (s *BTService) FetchMetadata(ctx context.Context, magnet string) (*lt.TorrentInfo, error) {
+	if !isMagnet(magnet) {
+		return nil, fmt.Errorf("not a magnet link: %s", magnet)
+	}
+
+	params, err := lt.ParseMagnetUri(magnet)
+	if err != nil {
+		return nil, err
+	}
+	if ti := s.cachedTorrentInfo(params); ti != nil {
+		return ti, nil
+	}
+
+	fetch, infoHash, err := s.acquireMetadataFetch(params)
+	if err != nil {
+		return nil, err
+	}
+	defer s.releaseMetadataFetch(infoHash, fetch)
+
+	ticker := time.NewTicker(metadataPollInterval)
+	defer ticker.Stop()
+	for !fetch.handle.MetadataReceived() {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-fetch.done:
+		case <-ticker.C:
+		}
+	}
+
+	ti := fetch.handle.TorrentFileCopy()
+	if ti == nil {
+		return nil, fmt.Errorf("%w: %s has no metadata", ErrMetadataFailed, infoHash)
+	}
+	s.cacheTorrentInfo(ti)
+	return ti, nil
+}
+
+// acquireMetadataFetch joins an in-flight fetch or starts a new one. A
+// torrent already in the service is used as is and never removed.
+func (s *BTService) acquireMetadataFetch(params *lt.AddTorrentParams) (*metadataFetch, string, error) {
+	infoHash := params.GetInfoHashV1Hex()
+
+	s.metadata.mu.Lock()
+	defer s.metadata.mu.Unlock()
+
+	if s.metadata.fetches == nil {
+		s.metadata.fetches = make(map[string]*metadataFetch)
+	}
+	if fetch, ok := s.metadata.fetches[infoHash]; ok {
+		fetch.refs++
+		return fetch, infoHash, nil
+	}
+
+	fetch := &metadataFetch{refs: 1, done: make(chan struct{})}
+	if t := s.GetTorrent(infoHash); t != nil {
+		fetch.handle = t.Handle
+	} else {
+		savePath, err := s.resolveSavePath(AddTorrentOptions{})
+		if err != nil {
+			return nil, "", err
+		}
+		params.SavePath = savePath
+		params.SetMetadataOnly()
+		fetch.savePath = savePath
+
+		// Takes a storage once metadata arrives, so it must not race
+		// the storage index prediction in addTorrentParams
+		s.addMu.Lock()
+		handle, err := s.Session.AddTorrent(params)
+		s.addMu.Unlock()
+		if err != nil {
+			return nil, "", err
+		}
+		fetch.handle = handle
+		fetch.owned = true
+		log.Infof("Fetching metadata for %s", infoHash)
+	}
+
+	s.metadata.fetches[infoHash] = fetch
+	return fetch, infoHash, nil
+}
+
+// takeOverMetadataFetch hands the temporary torrent of an in-flight fetch
+// to addTorrentParams: the torrent leaves upload mode, moves to savePath
+// and is no longer removed when the fetch is done. Returns nil if no fetch
+// owns a torrent for infoHash.
+func (s *BTService) takeOverMetadataFetch(infoHash, savePath string) *lt.TorrentHandle {
+	s.metadata.mu.Lock()
+	defer s.metadata.mu.Unlock()
+
+	fetch, ok := s.metadata.fetches[infoHash]
+	if !ok || !fetch.owned {
+		return nil
+	}
+	fetch.owned = false
+	fetch.handle.ClearUploadMode()
+	if savePath != fetch.savePath {
+		fetch.handle.MoveStorageTo(savePath)
+	}
+	log.Infof("Adding %s from its metadata fetch", infoHash)
+	return fetch.handle
+}
+
+// releaseMetadataFetch drops one caller and removes the temporary torrent
+// once nobody waits for it anymore
+func (s *BTService) releaseMetadataFetch(infoHash string, fetch *metadataFetch) {
+	s.metadata.mu.Lock()
+	defer s.metadata.mu.Unlock()
+
+	fetch.refs--
+	if fetch.refs > 0 {
+		return
+	}
+	delete(s.metadata.fetches, infoHash)
+	if fetch.owned {
+		s.Session.RemoveTorrent(fetch.handle, 0)
+	}
+}
+
+// ProcessAlert hands an alert to the service. The alert loop calls it for
+// every popped alert; alerts the service does not use are ignored.
+func (s *BTService) ProcessAlert(alert lt.Alert) {
+	switch alert.Type() {
+	case lt.ALERT_METADATA_RECEIVED:
+		ta := lt.SwigcptrTorrentAlert(alert.Swigcptr())
+		infoHash := ta.GetInfoHashV1String()
+		// Cache on receipt, so a fetch whose caller gave up still fills
+		// the cache
+		s.cacheHandleMetadata(s.metadataHandle(infoHash))
+		s.resolveStorageIndex(infoHash)
+		s.finishMetadataFetch(infoHash)
+	case lt.ALERT_METADATA_FAILED:
+		// A peer sent bad metadata; libtorrent asks other peers, so keep waiting
+		ma := lt.SwigcptrMetadataFailedAlert(alert.Swigcptr())
+		log.Warningf("Invalid metadata for %s: %s", ma.GetInfoHashV1String(), ma.GetErrorMessage())
+	case lt.ALERT_PIECE_FINISHED:
+		pa := lt.SwigcptrPieceFinishedAlert(alert.Swigcptr())
+		if t := s.GetTorrent(pa.GetInfoHashV1String()); t != nil {
+			t.pieceFinished(pa.GetPieceIndex())
+		}
+	case lt.ALERT_STORAGE_MOVED:
+		ta := lt.SwigcptrTorrentAlert(alert.Swigcptr())
+		if t := s.GetTorrent(ta.GetInfoHashV1String()); t != nil {
+			t.storageMoved()
+		}
+	}
+}
+
+// metadataHandle returns the handle of a registered torrent or in-flight
+// fetch, or nil
+func (s *BTService) metadataHandle(infoHash string) *lt.TorrentHandle {
+	if t := s.GetTorrent(infoHash); t != nil {
+		return t.Handle
+	}
+
+	s.metadata.mu.Lock()
+	defer s.metadata.mu.Unlock()
+	if fetch, ok := s.metadata.fetches[infoHash]; ok {
+		return fetch.handle
+	}
+	return nil
+}
+
+// resolveStorageIndex looks up the storage of a torrent taken over from a
+// metadata fetch before its metadata arrived; the storage is only created
+// with the metadata
+func (s *BTService) resolveStorageIndex(infoHash string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.torrents[infoHash]
+	if !ok || t.StorageIndex != lt.InvalidStorageIndex {
+		return
+	}
+	idx := lt.FindStorageIndex(infoHash)
+	if idx == lt.InvalidStorageIndex {
+		return
+	}
+	t.StorageIndex = idx
+	s.storageIndices[infoHash] = idx
+	s.memoryDiskIO.RegisterTorrent(infoHash, idx)
+}
+
+func (s *BTService) finishMetadataFetch(infoHash string) {
+	s.metadata.mu.Lock()
+	fetch, ok := s.metadata.fetches[infoHash]
+	s.metadata.mu.Unlock()
+
+	if ok {
+		fetch.finish()
+	}
+}
@@ -0,0 +1,111 @@
+// metadata_cache_2.0.x.go - Service integration of the metadata cache
+//
+// Info dictionaries are stored in a metadatacache.Cache when
+// metadata_received_alert reaches the alert loop, for torrents in the
+// service and metadata fetches alike, and when a torrent is added with its
+// metadata. Magnets found in the cache start without fetching metadata.
+
+package bittorrent
+
+import (
+	"path/filepath"
+
+	lt "github.com/ElementumOrg/libtorrent-go"
+	"github.com/elgatito/elementum/metadatacache"
+)
+
+// DefaultMetadataCacheSize limits the cache when
+// ServiceConfig.MetadataCacheSize is not set
+const DefaultMetadataCacheSize = metadatacache.DefaultSize
+
+// MetadataCache is a size-limited on-disk cache of info dictionaries
+type MetadataCache = metadatacache.Cache
+
+// NewMetadataCache opens the cache in dir, creating it if needed.
+// maxSize <= 0 uses the default.
+func NewMetadataCache(dir string, maxSize int64) (*MetadataCache, error) {
+	return metadatacache.New(dir, maxSize)
+}
+
+// MetadataCache returns the service's metadata cache, or nil if disabled
+func (s *BTService) MetadataCache() *MetadataCache {
+	return s.metadataCache
+}
+
+// initMetadataCache opens the cache configured in ServiceConfig. An empty
+// MetadataCachePath uses TorrentsPath/metadata; without either the cache
+// is disabled.
+func (s *BTService) initMetadataCache() error {
+	dir := s.config.MetadataCachePath
+	if dir == "" && s.config.TorrentsPath != "" {
+		dir = filepath.Join(s.config.TorrentsPath, "metadata")
+	}
+	if dir == "" {
+		return nil
+	}
+
+	cache, err := NewMetadataCache(dir, s.config.MetadataCacheSize)
+	if err != nil {
+		return err
+	}
+	s.metadataCache = cache
+	return nil
+}
+
+// cachedTorrentInfo returns torrent_info for magnet params from the cache,
+// or nil on a miss
+func (s *BTService) cachedTorrentInfo(params *lt.AddTorrentParams) *lt.TorrentInfo {
+	if s.metadataCache == nil {
+		return nil
+	}
+
+	info, ok := s.metadataCache.Get(params.GetInfoHashV1Hex())
+	if !ok {
+		if v2 := params.GetInfoHashV2Hex(); v2 != "" {
+			info, ok = s.metadataCache.Get(v2)
+		}
+	}
+	if !ok {
+		return nil
+	}
+
+	ti, err := lt.NewTorrentInfoFromBuffer(metadatacache.TorrentFile(info))
+	if err != nil {
+		log.Warningf("Metadata cache: dropping unreadable entry for %s: %s", params.GetInfoHashV1Hex(), err)
+		s.metadataCache.Remove(params.GetInfoHashV1Hex())
+		return nil
+	}
+	return ti
+}
+
+// cacheTorrentInfo stores a torrent's info dictionary in the cache
+func (s *BTService) cacheTorrentInfo(ti *lt.TorrentInfo) {
+	if s.metadataCache == nil || ti == nil {
+		return
+	}
+
+	vec := ti.InfoSectionBuf()
+	defer lt.DeleteStdVectorChar(vec)
+
+	if err := s.metadataCache.Put(stdVectorCharBytes(vec)); err != nil {
+		log.Warningf("Metadata cache: cannot store %s: %s", ti.InfoHashHex(), err)
+	}
+}
+
+// cacheHandleMetadata stores the metadata of a torrent in the session
+func (s *BTService) cacheHandleMetadata(handle *lt.TorrentHandle) {
+	if s.metadataCache == nil || handle == nil {
+		return
+	}
+	ti := handle.TorrentFileCopy()
+	if ti == nil {
+		return
+	}
+	defer lt.DeleteTorrentInfo(ti)
+	s.cacheTorrentInfo(ti)
+}
+
+// stdVectorCharBytes copies a std::vector<char> into a byte slice
+func stdVectorCharBytes(vec lt.StdVectorChar) []byte {
+	return []byte(vec.Bytes())
+}
@@ -0,0 +1,316 @@
+// queue_2.0.x.go - Torrent queueing for libtorrent 2.0.x
+//
+// Limits how many torrents download and seed at once. Torrents are ordered
+// by queue position; the first ones that fit in the active slots run and
+// the rest are paused as queued. When a slot frees up (a torrent finishes,
+// is paused or removed) the next queued torrent is promoted. Playing
+// torrents jump to the top and always run.
+
+package bittorrent
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Interval between queue checks, which promote torrents after a download
+// finishes and frees its slot
+const queueCheckInterval = 5 * time.Second
+
+// QueueConfig holds the active slot limits. 0 means unlimited.
+type QueueConfig struct {
+	MaxActiveDownloads int `json:"max_active_downloads"`
+	MaxActiveSeeds     int `json:"max_active_seeds"`
+}
+
+// torrentQueue holds the queue order and each torrent's queue state
+type torrentQueue struct {
+	// Serializes updateQueue, which queries torrent status without mu
+	update sync.Mutex
+
+	mu     sync.Mutex
+	order  []*Torrent
+	queued map[*Torrent]bool // paused by the queue
+	paused map[*Torrent]bool // paused by the user, skipped by the queue
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func (q *torrentQueue) init() {
+	q.queued = make(map[*Torrent]bool)
+	q.paused = make(map[*Torrent]bool)
+}
+
+func (q *torrentQueue) indexLocked(t *Torrent) int {
+	for i, other := range q.order {
+		if other == t {
+			return i
+		}
+	}
+	return -1
+}
+
+func (q *torrentQueue) moveLocked(t *Torrent, to int) error {
+	from := q.indexLocked(t)
+	if from < 0 {
+		return fmt.Errorf("torrent %s is not queued", t.InfoHashV1)
+	}
+	if to < 0 {
+		to = 0
+	}
+	if to >= len(q.order) {
+		to = len(q.order) - 1
+	}
+	if from == to {
+		return nil
+	}
+
+	q.order = append(q.order[:from], q.order[from+1:]...)
+	q.order = append(q.order[:to], append([]*Torrent{t}, q.order[to:]...)...)
+	return nil
+}
+
+// enqueue appends a new torrent at the bottom of the queue
+func (s *BTService) enqueue(t *Torrent) {
+	s.queue.mu.Lock()
+	s.queue.order = append(s.queue.order, t)
+	s.queue.mu.Unlock()
+
+	s.updateQueue()
+}
+
+// dequeue drops a removed torrent and promotes the next one
+func (s *BTService) dequeue(t *Torrent) {
+	s.queue.mu.Lock()
+	if i := s.queue.indexLocked(t); i >= 0 {
+		s.queue.order = append(s.queue.order[:i], s.queue.order[i+1:]...)
+	}
+	delete(s.queue.queued, t)
+	delete(s.queue.paused, t)
+	s.queue.mu.Unlock()
+
+	s.updateQueue()
+}
+
+// updateQueue pauses and resumes torrents so the active ones are the first
+// that fit in the slots, with playing torrents always active. Torrent status
+// is queried outside mu, so queue operations don't wait on libtorrent.
+func (s *BTService) updateQueue() {
+	s.queue.update.Lock()
+	defer s.queue.update.Unlock()
+
+	s.queue.mu.Lock()
+	order := make([]*Torrent, 0, len(s.queue.order))
+	for _, t := range s.queue.order {
+		if !s.queue.paused[t] {
+			order = append(order, t)
+		}
+	}
+	s.queue.mu.Unlock()
+
+	limits := s.config.Queue
+	downloads, seeds := 0, 0
+
+	active := make(map[*Torrent]bool, len(order))
+	seeding := make(map[*Torrent]bool, len(order))
+	playing := make(map[*Torrent]bool, len(order))
+	for _, t := range order {
+		seeding[t] = t.IsSeeding()
+		playing[t] = t.IsPlaying()
+		if playing[t] {
+			active[t] = true
+			if seeding[t] {
+				seeds++
+			} else {
+				downloads++
+			}
+		}
+	}
+
+	for _, t := range order {
+		if playing[t] {
+			continue
+		}
+		switch {
+		case seeding[t] && (limits == nil || limits.MaxActiveSeeds <= 0 || seeds < limits.MaxActiveSeeds):
+			active[t] = true
+			seeds++
+		case !seeding[t] && (limits == nil || limits.MaxActiveDownloads <= 0 || downloads < limits.MaxActiveDownloads):
+			active[t] = true
+			downloads++
+		}
+	}
+
+	s.queue.mu.Lock()
+	defer s.queue.mu.Unlock()
+	for _, t := range order {
+		// Removed or paused by the user while we were computing
+		if s.queue.paused[t] || s.queue.indexLocked(t) < 0 {
+			continue
+		}
+		switch {
+		case active[t] && s.queue.queued[t]:
+			delete(s.queue.queued, t)
+			t.Handle.Resume()
+		case !active[t] && !s.queue.queued[t]:
+			s.queue.queued[t] = true
+			t.Handle.Pause()
+		}
+	}
+}
+
+// SetQueueConfig stores new slot limits in the config and applies them
+func (s *BTService) SetQueueConfig(config *QueueConfig) error {
+	if config != nil && (config.MaxActiveDownloads < 0 || config.MaxActiveSeeds < 0) {
+		return fmt.Errorf("queue limits must not be negative")
+	}
+	s.config.Queue = config
+	s.updateQueue()
+	return nil
+}
+
+// QueuePosition returns the torrent's 0-based queue position, or -1
+func (s *BTService) QueuePosition(infoHashV1 string) int {
+	t := s.GetTorrent(infoHashV1)
+	if t == nil {
+		return -1
+	}
+	s.queue.mu.Lock()
+	defer s.queue.mu.Unlock()
+	return s.queue.indexLocked(t)
+}
+
+// QueueOrder returns the info hashes in queue order
+func (s *BTService) QueueOrder() []string {
+	s.queue.mu.Lock()
+	defer s.queue.mu.Unlock()
+
+	order := make([]string, len(s.queue.order))
+	for i, t := range s.queue.order {
+		order[i] = t.InfoHashV1
+	}
+	return order
+}
+
+// QueueMoveUp moves a torrent one position towards the top
+func (s *BTService) QueueMoveUp(infoHashV1 string) error {
+	return s.queueMove(infoHashV1, func(pos, _ int) int { return pos - 1 })
+}
+
+// QueueMoveDown moves a torrent one position towards the bottom
+func (s *BTService) QueueMoveDown(infoHashV1 string) error {
+	return s.queueMove(infoHashV1, func(pos, _ int) int { return pos + 1 })
+}
+
+// QueueMoveTop moves a torrent to the top of the queue
+func (s *BTService) QueueMoveTop(infoHashV1 string) error {
+	return s.queueMove(infoHashV1, func(_, _ int) int { return 0 })
+}
+
+// QueueMoveBottom moves a torrent to the bottom of the queue
+func (s *BTService) QueueMoveBottom(infoHashV1 string) error {
+	return s.queueMove(infoHashV1, func(_, n int) int { return n - 1 })
+}
+
+func (s *BTService) queueMove(infoHashV1 string, target func(pos, n int) int) error {
+	t := s.GetTorrent(infoHashV1)
+	if t == nil {
+		return fmt.Errorf("torrent %s not found", infoHashV1)
+	}
+
+	s.queue.mu.Lock()
+	err := s.queue.moveLocked(t, target(s.queue.indexLocked(t), len(s.queue.order)))
+	s.queue.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	s.updateQueue()
+	return nil
+}
+
+// isQueued reports whether the queue is holding the torrent back
+func (s *BTService) isQueued(t *Torrent) bool {
+	s.queue.mu.Lock()
+	defer s.queue.mu.Unlock()
+	return s.queue.queued[t]
+}
+
+// isUserPaused reports whether the user paused the torrent
+func (s *BTService) isUserPaused(t *Torrent) bool {
+	s.queue.mu.Lock()
+	defer s.queue.mu.Unlock()
+	return s.queue.paused[t]
+}
+
+// setUserPaused records a user pause or resume and rebalances the queue
+func (s *BTService) setUserPaused(t *Torrent, paused bool) {
+	s.queue.mu.Lock()
+	if paused {
+		s.queue.paused[t] = true
+		delete(s.queue.queued, t)
+	} else {
+		delete(s.queue.paused, t)
+		// Resume through the queue: updateQueue resumes it if it fits
+		s.queue.queued[t] = true
+	}
+	s.queue.mu.Unlock()
+
+	if paused {
+		t.Handle.Pause()
+	}
+	s.updateQueue()
+}
+
+// jumpQueue moves a torrent that started playing to the top
+func (s *BTService) jumpQueue(t *Torrent) error {
+	s.queue.mu.Lock()
+	err := s.queue.moveLocked(t, 0)
+	s.queue.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	s.updateQueue()
+	return nil
+}
+
+func (s *BTService) startQueueChecks(interval time.Duration) {
+	s.queue.mu.Lock()
+	defer s.queue.mu.Unlock()
+	if s.queue.stop != nil {
+		return
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	s.queue.stop, s.queue.done = stop, done
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.updateQueue()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func (s *BTService) stopQueueChecks() {
+	s.queue.mu.Lock()
+	stop, done := s.queue.stop, s.queue.done
+	s.queue.stop, s.queue.done = nil, nil
+	s.queue.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
@@ -0,0 +1,683 @@
+// rar_2.0.x.go - Store-mode RAR archives as virtual files
+//
+// Releases often pack the video into an uncompressed (store-mode) RAR set
+// split over many volumes. The file data is then stored verbatim between
+// the volume headers, so an inner file is a list of byte ranges in the
+// torrent and can be streamed without extracting anything.
+//
+// ParseRarArchive reads the RAR4 or RAR5 headers of every volume through an
+// io.ReaderAt in torrent coordinates (byte offsets into the concatenated
+// torrent data) and returns the inner files with their segments.
+// Compressed and encrypted archives are rejected.
+
+package bittorrent
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Archive error kinds
+var (
+	ErrNotRar            = errors.New("not a RAR archive")
+	ErrRarCompressed     = errors.New("RAR archive is compressed")
+	ErrRarEncrypted      = errors.New("RAR archive is encrypted")
+	ErrRarVolumeMissing  = errors.New("RAR volume missing")
+	ErrRarInvalidHeaders = errors.New("invalid RAR headers")
+)
+
+var (
+	rar4Signature = []byte("Rar!\x1a\x07\x00")
+	rar5Signature = []byte("Rar!\x1a\x07\x01\x00")
+)
+
+// Largest header accepted; RAR5 limits headers to 2 MB
+const rarMaxHeaderSize = 2 * 1024 * 1024
+
+// RarHeaderBytes is how much of each volume must be available to parse
+// it: the volume and file headers come first
+const RarHeaderBytes = 64 * 1024
+
+var (
+	// name.part01.rar (RAR5 and RAR4 new numbering)
+	reRarPart = regexp.MustCompile(`(?i)^(.*)\.part(\d+)\.rar$`)
+	// name.rar followed by name.r00, name.r01, ...
+	reRarFirst = regexp.MustCompile(`(?i)^(.*)\.rar$`)
+	reRarOld   = regexp.MustCompile(`(?i)^(.*)\.r(\d{2,3})$`)
+)
+
+// ArchiveSegment is a part of an inner file stored contiguously in a volume
+type ArchiveSegment struct {
+	Offset        int64 // in the inner file
+	TorrentOffset int64 // in the torrent data
+	Length        int64
+	Volume        int // index into RarArchive.Volumes
+}
+
+// ArchiveFile is a file stored inside an archive
+type ArchiveFile struct {
+	Name     string
+	Size     int64
+	Segments []ArchiveSegment
+}
+
+// RarArchive is a parsed store-mode RAR set
+type RarArchive struct {
+	// Volumes in archive order
+	Volumes []TorrentFile
+	Files   []*ArchiveFile
+}
+
+// rarEntry is one file header of one volume
+type rarEntry struct {
+	name        string
+	size        int64 // unpacked size of the whole file, -1 if unknown
+	dataOffset  int64 // in the volume
+	dataLength  int64
+	splitBefore bool
+	splitAfter  bool
+	dir         bool
+	stored      bool
+	encrypted   bool
+}
+
+// IsRarVolume reports whether a path looks like a RAR volume
+func IsRarVolume(p string) bool {
+	_, _, ok := rarVolumeNumber(p)
+	return ok
+}
+
+// rarVolumeNumber returns the set a volume belongs to and its position
+func rarVolumeNumber(p string) (set string, number int, ok bool) {
+	name := path.Base(p)
+	dir := path.Dir(p)
+	if m := reRarPart.FindStringSubmatch(name); m != nil {
+		n, _ := strconv.Atoi(m[2])
+		return path.Join(dir, strings.ToLower(m[1])) + "#part", n, true
+	}
+	if m := reRarFirst.FindStringSubmatch(name); m != nil {
+		return path.Join(dir, strings.ToLower(m[1])) + "#old", 0, true
+	}
+	if m := reRarOld.FindStringSubmatch(name); m != nil {
+		n, _ := strconv.Atoi(m[2])
+		return path.Join(dir, strings.ToLower(m[1])) + "#old", n + 1, true
+	}
+	return "", 0, false
+}
+
+// FindRarVolumes returns the volumes of the largest RAR set among files,
+// in archive order, or nil if there is none
+func FindRarVolumes(files []TorrentFile) []TorrentFile {
+	type volume struct {
+		file   TorrentFile
+		number int
+	}
+	sets := make(map[string][]volume)
+	sizes := make(map[string]int64)
+	for _, f := range files {
+		if set, n, ok := rarVolumeNumber(f.Path); ok && !isExcludedVideo(f.Path) {
+			sets[set] = append(sets[set], volume{f, n})
+			sizes[set] += f.Size
+		}
+	}
+
+	best := ""
+	for set := range sets {
+		if best == "" || sizes[set] > sizes[best] || (sizes[set] == sizes[best] && set < best) {
+			best = set
+		}
+	}
+	if best == "" {
+		return nil
+	}
+
+	vols := sets[best]
+	sort.Slice(vols, func(i, j int) bool { return vols[i].number < vols[j].number })
+	ordered := make([]TorrentFile, len(vols))
+	for i, v := range vols {
+		ordered[i] = v.file
+	}
+	return ordered
+}
+
+// RarHeaderPieces returns the pieces holding the volume headers, which
+// must be downloaded before ParseRarArchive can run. Those are the start
+// of every volume, and the end of the last one, where files stored after
+// the split file and the end of archive block are.
+func RarHeaderPieces(volumes []TorrentFile, pieceLength int64) []int {
+	var pieces []int
+	for i, v := range volumes {
+		length := v.Size
+		if length > RarHeaderBytes {
+			length = RarHeaderBytes
+		}
+		pieces = appendPieces(pieces, v.Offset, length, pieceLength)
+		if i == len(volumes)-1 {
+			pieces = appendPieces(pieces, v.Offset+v.Size-length, length, pieceLength)
+		}
+	}
+	return uniquePieces(pieces)
+}
+
+// ParseRarArchive parses the headers of the RAR set formed by volumes, as
+// returned by FindRarVolumes. src reads torrent data; reads may block
+// until the data is downloaded.
+func ParseRarArchive(volumes []TorrentFile, src io.ReaderAt) (*RarArchive, error) {
+	if len(volumes) == 0 {
+		return nil, ErrNotRar
+	}
+
+	// Gaps in the numbering would otherwise surface as a short file.
+	// part numbering starts at 1, old-style .rar is 0.
+	previous := -1
+	for i, volume := range volumes {
+		_, n, ok := rarVolumeNumber(volume.Path)
+		first := i == 0 && n <= 1
+		if ok && !first && n != previous+1 {
+			return nil, fmt.Errorf("%w: before %s", ErrRarVolumeMissing, volume.Path)
+		}
+		previous = n
+	}
+
+	archive := &RarArchive{Volumes: volumes}
+	var current *ArchiveFile
+	var skipped error
+
+	for vi, volume := range volumes {
+		r := io.NewSectionReader(src, volume.Offset, volume.Size)
+		entries, err := parseRarVolume(r, volume.Size)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", volume.Path, err)
+		}
+
+		for _, e := range entries {
+			if e.dir {
+				continue
+			}
+			if e.encrypted || !e.stored {
+				// Only stored files can be mapped; keep looking for one
+				if e.encrypted {
+					skipped = ErrRarEncrypted
+				} else {
+					skipped = ErrRarCompressed
+				}
+				current = nil
+				continue
+			}
+
+			if e.splitBefore {
+				if current == nil || current.Name != e.name {
+					return nil, fmt.Errorf("%w: %s continues %s from a missing volume",
+						ErrRarVolumeMissing, volume.Path, e.name)
+				}
+			} else {
+				current = &ArchiveFile{Name: e.name, Size: e.size}
+			}
+
+			current.Segments = append(current.Segments, ArchiveSegment{
+				Offset:        current.mapped(),
+				TorrentOffset: volume.Offset + e.dataOffset,
+				Length:        e.dataLength,
+				Volume:        vi,
+			})
+
+			if !e.splitAfter {
+				if current.Size < 0 {
+					current.Size = current.mapped()
+				}
+				if current.mapped() != current.Size {
+					return nil, fmt.Errorf("%w: %s has %d of %d bytes",
+						ErrRarInvalidHeaders, current.Name, current.mapped(), current.Size)
+				}
+				archive.Files = append(archive.Files, current)
+				current = nil
+			}
+		}
+	}
+
+	if current != nil {
+		return nil, fmt.Errorf("%w: %s continues after the last volume", ErrRarVolumeMissing, current.Name)
+	}
+	if len(archive.Files) == 0 {
+		if skipped != nil {
+			return nil, skipped
+		}
+		return nil, fmt.Errorf("%w: no files", ErrRarInvalidHeaders)
+	}
+	return archive, nil
+}
+
+// mapped returns the number of bytes covered by the segments so far
+func (f *ArchiveFile) mapped() int64 {
+	if len(f.Segments) == 0 {
+		return 0
+	}
+	last := f.Segments[len(f.Segments)-1]
+	return last.Offset + last.Length
+}
+
+// parseRarVolume reads the file headers of one volume
+func parseRarVolume(r io.ReaderAt, size int64) ([]rarEntry, error) {
+	sig := make([]byte, len(rar5Signature))
+	n, err := r.ReadAt(sig, 0)
+	if n < len(rar4Signature) {
+		if err == nil || err == io.EOF {
+			err = ErrNotRar
+		}
+		return nil, err
+	}
+	switch {
+	case n == len(rar5Signature) && string(sig) == string(rar5Signature):
+		return parseRar5Volume(r, size)
+	case string(sig[:len(rar4Signature)]) == string(rar4Signature):
+		return parseRar4Volume(r, size)
+	}
+	return nil, ErrNotRar
+}
+
+// RAR4 block types and flags
+const (
+	rar4BlockMain = 0x73
+	rar4BlockFile = 0x74
+	rar4BlockEnd  = 0x7b
+
+	rar4MainEncryptedHeaders = 0x0080
+
+	rar4FileSplitBefore = 0x0001
+	rar4FileSplitAfter  = 0x0002
+	rar4FileEncrypted   = 0x0004
+	rar4FileDirectory   = 0x00e0
+	rar4FileLarge       = 0x0100
+	rar4FileUnicode     = 0x0200
+	rar4LongBlock       = 0x8000
+
+	rar4MethodStore = 0x30
+)
+
+func parseRar4Volume(r io.ReaderAt, size int64) ([]rarEntry, error) {
+	var entries []rarEntry
+	pos := int64(len(rar4Signature))
+
+	for pos+7 <= size {
+		base := make([]byte, 7)
+		if _, err := r.ReadAt(base, pos); err != nil {
+			return nil, err
+		}
+		blockType := base[2]
+		flags := binary.LittleEndian.Uint16(base[3:5])
+		headSize := int64(binary.LittleEndian.Uint16(base[5:7]))
+		if headSize < 7 || pos+headSize > size {
+			return nil, fmt.Errorf("%w: bad block at %d", ErrRarInvalidHeaders, pos)
+		}
+
+		hdr := make([]byte, headSize)
+		if _, err := r.ReadAt(hdr, pos); err != nil {
+			return nil, err
+		}
+
+		next := pos + headSize
+		switch blockType {
+		case rar4BlockMain:
+			if flags&rar4MainEncryptedHeaders != 0 {
+				return nil, ErrRarEncrypted
+			}
+		case rar4BlockFile:
+			if headSize < 32 {
+				return nil, fmt.Errorf("%w: short file header at %d", ErrRarInvalidHeaders, pos)
+			}
+			packSize := int64(binary.LittleEndian.Uint32(hdr[7:11]))
+			unpSize := int64(binary.LittleEndian.Uint32(hdr[11:15]))
+			method := hdr[25]
+			nameSize := int64(binary.LittleEndian.Uint16(hdr[26:28]))
+			nameAt := int64(32)
+			if flags&rar4FileLarge != 0 {
+				if headSize < 40 {
+					return nil, fmt.Errorf("%w: short file header at %d", ErrRarInvalidHeaders, pos)
+				}
+				packSize |= int64(binary.LittleEndian.Uint32(hdr[32:36])) << 32
+				unpSize |= int64(binary.LittleEndian.Uint32(hdr[36:40])) << 32
+				nameAt = 40
+			}
+			if nameAt+nameSize > headSize {
+				return nil, fmt.Errorf("%w: file name past header at %d", ErrRarInvalidHeaders, pos)
+			}
+			name := hdr[nameAt : nameAt+nameSize]
+			if flags&rar4FileUnicode != 0 {
+				// "ascii\0encoded unicode": the ASCII part is enough to
+				// match volumes
+				if i := strings.IndexByte(string(name), 0); i >= 0 {
+					name = name[:i]
+				}
+			}
+
+			entries = append(entries, rarEntry{
+				name:        rarPath(string(name)),
+				size:        unpSize,
+				dataOffset:  next,
+				dataLength:  packSize,
+				splitBefore: flags&rar4FileSplitBefore != 0,
+				splitAfter:  flags&rar4FileSplitAfter != 0,
+				dir:         flags&rar4FileDirectory == rar4FileDirectory,
+				stored:      method == rar4MethodStore,
+				encrypted:   flags&rar4FileEncrypted != 0,
+			})
+			// A file continued in the next volume fills the rest of this
+			// one; only the end block follows, and it may not be
+			// downloaded yet
+			if flags&rar4FileSplitAfter != 0 {
+				return entries, nil
+			}
+			next += packSize
+		case rar4BlockEnd:
+			return entries, nil
+		default:
+			if flags&rar4LongBlock != 0 {
+				if headSize < 11 {
+					return nil, fmt.Errorf("%w: short block at %d", ErrRarInvalidHeaders, pos)
+				}
+				next += int64(binary.LittleEndian.Uint32(hdr[7:11]))
+			}
+		}
+		pos = next
+	}
+	return entries, nil
+}
+
+// RAR5 header types and flags
+const (
+	rar5HeaderFile       = 2
+	rar5HeaderService    = 3
+	rar5HeaderEncryption = 4
+	rar5HeaderEnd        = 5
+
+	rar5FlagExtra       = 0x01
+	rar5FlagData        = 0x02
+	rar5FlagSplitBefore = 0x08
+	rar5FlagSplitAfter  = 0x10
+
+	rar5FileDirectory   = 0x01
+	rar5FileTime        = 0x02
+	rar5FileCRC         = 0x04
+	rar5FileUnknownSize = 0x08
+
+	rar5ExtraEncryption = 0x01
+)
+
+func parseRar5Volume(r io.ReaderAt, size int64) ([]rarEntry, error) {
+	var entries []rarEntry
+	pos := int64(len(rar5Signature))
+
+	for pos+5 <= size {
+		// CRC32, then the header size as a vint of up to 3 bytes
+		prefix := make([]byte, 7)
+		n, err := r.ReadAt(prefix, pos)
+		if n < 5 {
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		b := rar5Buffer{data: prefix[4:n]}
+		headerSize := b.vint()
+		if b.err != nil || headerSize == 0 || headerSize > rarMaxHeaderSize {
+			return nil, fmt.Errorf("%w: bad header size at %d", ErrRarInvalidHeaders, pos)
+		}
+		headerStart := pos + 4 + int64(b.pos)
+		if headerStart+int64(headerSize) > size {
+			return nil, fmt.Errorf("%w: header past volume end at %d", ErrRarInvalidHeaders, pos)
+		}
+
+		hdr := make([]byte, headerSize)
+		if _, err := r.ReadAt(hdr, headerStart); err != nil {
+			return nil, err
+		}
+		b = rar5Buffer{data: hdr}
+		headerType := b.vint()
+		flags := b.vint()
+		var extraSize, dataSize uint64
+		if flags&rar5FlagExtra != 0 {
+			extraSize = b.vint()
+		}
+		if flags&rar5FlagData != 0 {
+			dataSize = b.vint()
+		}
+		if b.err != nil || extraSize > uint64(len(hdr)) {
+			return nil, fmt.Errorf("%w: bad header at %d", ErrRarInvalidHeaders, pos)
+		}
+
+		dataStart := headerStart + int64(headerSize)
+		switch headerType {
+		case rar5HeaderEncryption:
+			return nil, ErrRarEncrypted
+		case rar5HeaderFile:
+			fileFlags := b.vint()
+			unpSize := int64(b.vint())
+			b.vint() // attributes
+			if fileFlags&rar5FileTime != 0 {
+				b.skip(4)
+			}
+			if fileFlags&rar5FileCRC != 0 {
+				b.skip(4)
+			}
+			compression := b.vint()
+			b.vint() // host OS
+			nameLen := b.vint()
+			name := b.bytes(nameLen)
+			if b.err != nil {
+				return nil, fmt.Errorf("%w: bad file header at %d", ErrRarInvalidHeaders, pos)
+			}
+			if fileFlags&rar5FileUnknownSize != 0 {
+				unpSize = -1
+			}
+
+			entries = append(entries, rarEntry{
+				name:        rarPath(string(name)),
+				size:        unpSize,
+				dataOffset:  dataStart,
+				dataLength:  int64(dataSize),
+				splitBefore: flags&rar5FlagSplitBefore != 0,
+				splitAfter:  flags&rar5FlagSplitAfter != 0,
+				dir:         fileFlags&rar5FileDirectory != 0,
+				stored:      (compression>>7)&0x7 == 0,
+				encrypted:   rar5HasExtra(hdr[uint64(len(hdr))-extraSize:], rar5ExtraEncryption),
+			})
+			// As in RAR4, nothing but the end block follows a split file
+			if flags&rar5FlagSplitAfter != 0 {
+				return entries, nil
+			}
+		case rar5HeaderEnd:
+			return entries, nil
+		case rar5HeaderService:
+			// Comments, quick-open data, recovery records: not needed
+		}
+		pos = dataStart + int64(dataSize)
+	}
+	return entries, nil
+}
+
+// rar5HasExtra reports whether an extra area contains a record type
+func rar5HasExtra(extra []byte, recordType uint64) bool {
+	b := rar5Buffer{data: extra}
+	for b.pos < len(b.data) {
+		size := b.vint()
+		start := b.pos
+		typ := b.vint()
+		if b.err != nil || size == 0 {
+			return false
+		}
+		if typ == recordType {
+			return true
+		}
+		b.pos = start
+		b.skip(size)
+	}
+	return false
+}
+
+// rar5Buffer decodes RAR5 header fields
+type rar5Buffer struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+// vint reads a variable-length integer: 7 bits per byte, low bits first
+func (b *rar5Buffer) vint() uint64 {
+	var v uint64
+	for shift := uint(0); shift < 70; shift += 7 {
+		if b.pos >= len(b.data) {
+			b.err = io.ErrUnexpectedEOF
+			return 0
+		}
+		c := b.data[b.pos]
+		b.pos++
+		v |= uint64(c&0x7f) << shift
+		if c&0x80 == 0 {
+			return v
+		}
+	}
+	b.err = ErrRarInvalidHeaders
+	return 0
+}
+
+func (b *rar5Buffer) skip(n uint64) {
+	if n > uint64(len(b.data)-b.pos) {
+		b.err = io.ErrUnexpectedEOF
+		b.pos = len(b.data)
+		return
+	}
+	b.pos += int(n)
+}
+
+func (b *rar5Buffer) bytes(n uint64) []byte {
+	start := b.pos
+	b.skip(n)
+	if b.err != nil {
+		return nil
+	}
+	return b.data[start:b.pos]
+}
+
+// rarPath normalizes the separators of a name stored in an archive
+func rarPath(name string) string {
+	return strings.ReplaceAll(name, "\\", "/")
+}
+
+// Inner file access
+
+// VideoFiles returns the archive's files as TorrentFiles for the video
+// selector. Offset is the offset of the first segment.
+func (a *RarArchive) VideoFiles() []TorrentFile {
+	files := make([]TorrentFile, len(a.Files))
+	for i, f := range a.Files {
+		files[i] = TorrentFile{Index: i, Path: f.Name, Size: f.Size, Offset: f.Segments[0].TorrentOffset}
+	}
+	return files
+}
+
+// segmentAt returns the index of the segment holding offset
+func (f *ArchiveFile) segmentAt(offset int64) int {
+	return sort.Search(len(f.Segments), func(i int) bool {
+		s := f.Segments[i]
+		return s.Offset+s.Length > offset
+	})
+}
+
+// TorrentRanges maps a range of the inner file to ranges of torrent data
+func (f *ArchiveFile) TorrentRanges(offset, length int64) []ArchiveSegment {
+	if offset < 0 {
+		length += offset
+		offset = 0
+	}
+	if offset+length > f.Size {
+		length = f.Size - offset
+	}
+
+	var ranges []ArchiveSegment
+	for i := f.segmentAt(offset); i < len(f.Segments) && length > 0; i++ {
+		s := f.Segments[i]
+		skip := offset - s.Offset
+		n := s.Length - skip
+		if n > length {
+			n = length
+		}
+		ranges = append(ranges, ArchiveSegment{
+			Offset:        offset,
+			TorrentOffset: s.TorrentOffset + skip,
+			Length:        n,
+			Volume:        s.Volume,
+		})
+		offset += n
+		length -= n
+	}
+	return ranges
+}
+
+// Pieces returns the sorted torrent pieces holding a range of the inner file
+func (f *ArchiveFile) Pieces(offset, length, pieceLength int64) []int {
+	var pieces []int
+	for _, r := range f.TorrentRanges(offset, length) {
+		pieces = appendPieces(pieces, r.TorrentOffset, r.Length, pieceLength)
+	}
+	return uniquePieces(pieces)
+}
+
+// ReaderAt returns a reader of the inner file on top of a reader of the
+// torrent data
+func (f *ArchiveFile) ReaderAt(src io.ReaderAt) io.ReaderAt {
+	return &archiveReader{file: f, src: src}
+}
+
+type archiveReader struct {
+	file *ArchiveFile
+	src  io.ReaderAt
+}
+
+func (r *archiveReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.file.Size {
+		return 0, io.EOF
+	}
+
+	read := 0
+	for _, s := range r.file.TorrentRanges(off, int64(len(p))) {
+		n, err := r.src.ReadAt(p[read:read+int(s.Length)], s.TorrentOffset)
+		read += n
+		if err != nil && !(err == io.EOF && n == int(s.Length)) {
+			return read, err
+		}
+	}
+	if read < len(p) {
+		return read, io.EOF
+	}
+	return read, nil
+}
+
+// appendPieces appends the pieces covering a byte range of torrent data
+func appendPieces(pieces []int, offset, length, pieceLength int64) []int {
+	if length <= 0 || pieceLength <= 0 {
+		return pieces
+	}
+	for p := offset / pieceLength; p <= (offset+length-1)/pieceLength; p++ {
+		pieces = append(pieces, int(p))
+	}
+	return pieces
+}
+
+// uniquePieces sorts pieces and drops duplicates
+func uniquePieces(pieces []int) []int {
+	sort.Ints(pieces)
+	out := pieces[:0]
+	for _, p := range pieces {
+		if len(out) == 0 || p != out[len(out)-1] {
+			out = append(out, p)
+		}
+	}
+	return out
+}
@@ -0,0 +1,334 @@
+package bittorrent
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// rarPart is one file header and its data in a synthetic volume
+type rarPart struct {
+	name                    string
+	size                    int64 // whole file
+	data                    []byte
+	splitBefore, splitAfter bool
+	compressed              bool
+}
+
+func rar4Volume(parts ...rarPart) []byte {
+	var b bytes.Buffer
+	b.Write(rar4Signature)
+	// Main header: volume flag, 6 reserved bytes
+	b.Write([]byte{0, 0, rar4BlockMain, 0x01, 0x00, 13, 0, 0, 0, 0, 0, 0, 0})
+
+	for _, p := range parts {
+		var flags uint16
+		if p.splitBefore {
+			flags |= rar4FileSplitBefore
+		}
+		if p.splitAfter {
+			flags |= rar4FileSplitAfter
+		}
+		method := byte(rar4MethodStore)
+		if p.compressed {
+			method = 0x33
+		}
+
+		hdr := make([]byte, 32, 32+len(p.name))
+		hdr[2] = rar4BlockFile
+		binary.LittleEndian.PutUint16(hdr[3:], flags)
+		binary.LittleEndian.PutUint16(hdr[5:], uint16(32+len(p.name)))
+		binary.LittleEndian.PutUint32(hdr[7:], uint32(len(p.data)))
+		binary.LittleEndian.PutUint32(hdr[11:], uint32(p.size))
+		hdr[25] = method
+		binary.LittleEndian.PutUint16(hdr[26:], uint16(len(p.name)))
+		hdr = append(hdr, p.name...)
+
+		b.Write(hdr)
+		b.Write(p.data)
+	}
+
+	b.Write([]byte{0, 0, rar4BlockEnd, 0, 0, 7, 0})
+	return b.Bytes()
+}
+
+func rar5Vint(v uint64) []byte {
+	var out []byte
+	for v >= 0x80 {
+		out = append(out, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(out, byte(v))
+}
+
+func rar5Block(headerType, flags uint64, body, data []byte) []byte {
+	hdr := append(rar5Vint(headerType), rar5Vint(flags)...)
+	if flags&rar5FlagData != 0 {
+		hdr = append(hdr, rar5Vint(uint64(len(data)))...)
+	}
+	hdr = append(hdr, body...)
+
+	block := append([]byte{0, 0, 0, 0}, rar5Vint(uint64(len(hdr)))...)
+	block = append(block, hdr...)
+	return append(block, data...)
+}
+
+func rar5Volume(parts ...rarPart) []byte {
+	var b bytes.Buffer
+	b.Write(rar5Signature)
+	b.Write(rar5Block(1, 0, rar5Vint(1), nil))
+
+	for _, p := range parts {
+		flags := uint64(rar5FlagData)
+		if p.splitBefore {
+			flags |= rar5FlagSplitBefore
+		}
+		if p.splitAfter {
+			flags |= rar5FlagSplitAfter
+		}
+		var compression uint64
+		if p.compressed {
+			compression = 3 << 7
+		}
+
+		var body []byte
+		body = append(body, rar5Vint(0)...) // file flags
+		body = append(body, rar5Vint(uint64(p.size))...)
+		body = append(body, rar5Vint(0)...) // attributes
+		body = append(body, rar5Vint(compression)...)
+		body = append(body, rar5Vint(0)...) // host OS
+		body = append(body, rar5Vint(uint64(len(p.name)))...)
+		body = append(body, p.name...)
+
+		b.Write(rar5Block(rar5HeaderFile, flags, body, p.data))
+	}
+
+	b.Write(rar5Block(rar5HeaderEnd, 0, rar5Vint(0), nil))
+	return b.Bytes()
+}
+
+// splitRar returns the parts of a file stored across n volumes
+func splitRar(name string, payload []byte, n int) [][]rarPart {
+	var volumes [][]rarPart
+	chunk := (len(payload) + n - 1) / n
+	for i := 0; i < n; i++ {
+		end := (i + 1) * chunk
+		if end > len(payload) {
+			end = len(payload)
+		}
+		volumes = append(volumes, []rarPart{{
+			name:        name,
+			size:        int64(len(payload)),
+			data:        payload[i*chunk : end],
+			splitBefore: i > 0,
+			splitAfter:  i < n-1,
+		}})
+	}
+	return volumes
+}
+
+// rarTorrent lays out named files as a torrent and returns its file list
+// and data
+func rarTorrent(names []string, contents [][]byte) ([]TorrentFile, []byte) {
+	var files []TorrentFile
+	var data []byte
+	for i, name := range names {
+		files = append(files, TorrentFile{
+			Index:  i,
+			Path:   name,
+			Size:   int64(len(contents[i])),
+			Offset: int64(len(data)),
+		})
+		data = append(data, contents[i]...)
+	}
+	return files, data
+}
+
+func testPayload(n int) []byte {
+	payload := make([]byte, n)
+	for i := range payload {
+		payload[i] = byte(i * 7)
+	}
+	return payload
+}
+
+// TestRarArchiveStoreMode maps inner files of RAR4 and RAR5 sets
+func TestRarArchiveStoreMode(t *testing.T) {
+	payload := testPayload(10000)
+
+	for _, format := range []struct {
+		name   string
+		volume func(...rarPart) []byte
+		names  []string
+	}{
+		{"rar4", rar4Volume, []string{"Movie/movie.r01", "Movie/movie.rar", "Movie/movie.r00"}},
+		{"rar5", rar5Volume, []string{"Movie/movie.part3.rar", "Movie/movie.part1.rar", "Movie/movie.part2.rar"}},
+	} {
+		t.Run(format.name, func(t *testing.T) {
+			parts := splitRar("Movie/movie.mkv", payload, 3)
+			// A small file before the video in the first volume
+			nfo := []byte("release notes")
+			parts[0] = append([]rarPart{{name: "Movie\\movie.nfo", size: int64(len(nfo)), data: nfo}}, parts[0]...)
+
+			// Volumes in torrent order differ from archive order
+			names := append([]string{"Movie/movie.sfv"}, format.names...)
+			contents := [][]byte{[]byte("checksums"), format.volume(parts[2]...), format.volume(parts[0]...), format.volume(parts[1]...)}
+			files, data := rarTorrent(names, contents)
+
+			volumes := FindRarVolumes(files)
+			if len(volumes) != 3 || volumes[0].Index != 2 || volumes[1].Index != 3 || volumes[2].Index != 1 {
+				t.Fatalf("Wrong volume order: %+v", volumes)
+			}
+
+			archive, err := ParseRarArchive(volumes, bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("Failed to parse archive: %v", err)
+			}
+			if len(archive.Files) != 2 {
+				t.Fatalf("Expected 2 files, got %d", len(archive.Files))
+			}
+			if archive.Files[0].Name != "Movie/movie.nfo" {
+				t.Errorf("Separators not normalized: %s", archive.Files[0].Name)
+			}
+
+			movie := archive.Files[1]
+			if movie.Size != int64(len(payload)) || len(movie.Segments) != 3 {
+				t.Fatalf("Wrong layout: size %d, %d segments", movie.Size, len(movie.Segments))
+			}
+
+			choice, ok := SelectVideoFile(archive.VideoFiles(), nil)
+			if !ok || choice.File.Path != movie.Name {
+				t.Errorf("Video selector picked %+v", choice.File)
+			}
+
+			// Whole file and a read across a volume boundary
+			r := movie.ReaderAt(bytes.NewReader(data))
+			got := make([]byte, len(payload))
+			if n, err := r.ReadAt(got, 0); n != len(payload) || (err != nil && err != io.EOF) {
+				t.Fatalf("ReadAt returned %d, %v", n, err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Fatal("Inner file content differs")
+			}
+
+			boundary := movie.Segments[1].Offset
+			got = make([]byte, 100)
+			if _, err := r.ReadAt(got, boundary-50); err != nil {
+				t.Fatalf("ReadAt across volumes: %v", err)
+			}
+			if !bytes.Equal(got, payload[boundary-50:boundary+50]) {
+				t.Error("Read across volumes returned wrong data")
+			}
+
+			if n, err := r.ReadAt(got, movie.Size-10); n != 10 || err != io.EOF {
+				t.Errorf("Read at end returned %d, %v", n, err)
+			}
+
+			// Pieces of the range around the boundary come from both volumes
+			const pieceLength = 1024
+			ranges := movie.TorrentRanges(boundary-50, 100)
+			if len(ranges) != 2 || ranges[0].Volume != 0 || ranges[1].Volume != 1 {
+				t.Fatalf("Wrong torrent ranges: %+v", ranges)
+			}
+			pieces := movie.Pieces(boundary-50, 100, pieceLength)
+			want := uniquePieces(append(
+				appendPieces(nil, ranges[0].TorrentOffset, 50, pieceLength),
+				appendPieces(nil, ranges[1].TorrentOffset, 50, pieceLength)...))
+			if len(pieces) != len(want) {
+				t.Errorf("Pieces %v, want %v", pieces, want)
+			}
+		})
+	}
+}
+
+// TestRarArchiveErrors checks compressed sets and missing volumes
+func TestRarArchiveErrors(t *testing.T) {
+	payload := testPayload(3000)
+
+	compressed := splitRar("movie.mkv", payload, 1)
+	compressed[0][0].compressed = true
+	files, data := rarTorrent([]string{"movie.rar"}, [][]byte{rar5Volume(compressed[0]...)})
+	if _, err := ParseRarArchive(FindRarVolumes(files), bytes.NewReader(data)); !errors.Is(err, ErrRarCompressed) {
+		t.Errorf("Expected ErrRarCompressed, got %v", err)
+	}
+
+	parts := splitRar("movie.mkv", payload, 3)
+	files, data = rarTorrent(
+		[]string{"m.part1.rar", "m.part3.rar"},
+		[][]byte{rar4Volume(parts[0]...), rar4Volume(parts[2]...)})
+	if _, err := ParseRarArchive(FindRarVolumes(files), bytes.NewReader(data)); !errors.Is(err, ErrRarVolumeMissing) {
+		t.Errorf("Expected ErrRarVolumeMissing, got %v", err)
+	}
+
+	files, data = rarTorrent([]string{"fake.rar"}, [][]byte{[]byte("not an archive at all")})
+	if _, err := ParseRarArchive(FindRarVolumes(files), bytes.NewReader(data)); !errors.Is(err, ErrNotRar) {
+		t.Errorf("Expected ErrNotRar, got %v", err)
+	}
+}
+
+// headerOnlyReader fails reads touching pieces that are not downloaded
+type headerOnlyReader struct {
+	data        []byte
+	pieceLength int64
+	have        map[int]bool
+}
+
+func (r *headerOnlyReader) ReadAt(p []byte, off int64) (int, error) {
+	for _, piece := range appendPieces(nil, off, int64(len(p)), r.pieceLength) {
+		if !r.have[piece] {
+			return 0, fmt.Errorf("piece %d is not downloaded", piece)
+		}
+	}
+	return bytes.NewReader(r.data).ReadAt(p, off)
+}
+
+// TestRarHeaderPieces parses sets with only their header pieces available
+func TestRarHeaderPieces(t *testing.T) {
+	const pieceLength = 16 * 1024
+	payload := testPayload(3 * 200 * 1024)
+
+	for _, format := range []struct {
+		name   string
+		volume func(...rarPart) []byte
+		names  []string
+	}{
+		{"rar4", rar4Volume, []string{"movie.rar", "movie.r00", "movie.r01"}},
+		{"rar5", rar5Volume, []string{"movie.part1.rar", "movie.part2.rar", "movie.part3.rar"}},
+	} {
+		t.Run(format.name, func(t *testing.T) {
+			parts := splitRar("movie.mkv", payload, 3)
+			// A small file after the video in the last volume
+			nfo := []byte("release notes")
+			parts[2] = append(parts[2], rarPart{name: "movie.nfo", size: int64(len(nfo)), data: nfo})
+
+			names := append([]string{"movie.sfv"}, format.names...)
+			contents := [][]byte{[]byte("checksums")}
+			for _, volume := range parts {
+				contents = append(contents, format.volume(volume...))
+			}
+			files, data := rarTorrent(names, contents)
+			volumes := FindRarVolumes(files)
+
+			src := &headerOnlyReader{data: data, pieceLength: pieceLength, have: make(map[int]bool)}
+			pieces := RarHeaderPieces(volumes, pieceLength)
+			for _, piece := range pieces {
+				src.have[piece] = true
+			}
+			if total := (int64(len(data)) + pieceLength - 1) / pieceLength; int64(len(pieces)) >= total {
+				t.Fatalf("Header pieces cover the whole torrent: %d of %d", len(pieces), total)
+			}
+
+			archive, err := ParseRarArchive(volumes, src)
+			if err != nil {
+				t.Fatalf("Failed to parse with header pieces only: %v", err)
+			}
+			if len(archive.Files) != 2 || archive.Files[0].Size != int64(len(payload)) ||
+				archive.Files[1].Name != "movie.nfo" {
+				t.Errorf("Wrong files: %+v", archive.Files)
+			}
+		})
+	}
+}
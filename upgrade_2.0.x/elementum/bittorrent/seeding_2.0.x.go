@@ -0,0 +1,236 @@
+// seeding_2.0.x.go - Seeding goals for libtorrent 2.0.x
+//
+// Evaluates seeding torrents against a ratio goal, a seed-time goal and an
+// idle timeout, then pauses or removes them as configured. The seed times
+// come from torrent_status (GetSeedingTime / GetActiveTime), so they
+// survive restarts through resume data.
+
+package bittorrent
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Default interval between seeding goal checks
+const seedingCheckInterval = time.Minute
+
+// SeedingAction is what happens to a torrent that reached a seeding goal
+type SeedingAction string
+
+const (
+	SeedingActionPause       SeedingAction = "pause"
+	SeedingActionRemove      SeedingAction = "remove"
+	SeedingActionRemoveFiles SeedingAction = "remove_files"
+)
+
+// SeedingPolicy holds the seeding goals. A zero goal is disabled.
+type SeedingPolicy struct {
+	Enabled bool `json:"enabled"`
+	// RatioGoal stops seeding once upload/download reaches it
+	RatioGoal float64 `json:"ratio_goal"`
+	// SeedTimeLimit stops seeding after this many seconds of seeding
+	SeedTimeLimit int64 `json:"seed_time_limit"`
+	// IdleTimeout stops seeding after this many seconds without uploads
+	IdleTimeout int64         `json:"idle_timeout"`
+	Action      SeedingAction `json:"action"`
+}
+
+// Validate checks the goals and action
+func (p *SeedingPolicy) Validate() error {
+	if p.RatioGoal < 0 || p.SeedTimeLimit < 0 || p.IdleTimeout < 0 {
+		return fmt.Errorf("seeding goals must not be negative")
+	}
+	switch p.Action {
+	case SeedingActionPause, SeedingActionRemove, SeedingActionRemoveFiles:
+	default:
+		return fmt.Errorf("unknown seeding action: %q", p.Action)
+	}
+	return nil
+}
+
+// SeedingStats are the values a seeding policy is evaluated against
+type SeedingStats struct {
+	Ratio       float64
+	SeedingTime int64 // seconds
+	ActiveTime  int64 // seconds
+	IdleTime    int64 // seconds since last upload, -1 if never
+}
+
+// Reason returns why the stats meet a seeding goal, or "" if none is met
+func (p *SeedingPolicy) Reason(stats SeedingStats) string {
+	if p.RatioGoal > 0 && stats.Ratio >= p.RatioGoal {
+		return fmt.Sprintf("ratio %.2f reached goal %.2f", stats.Ratio, p.RatioGoal)
+	}
+	if p.SeedTimeLimit > 0 && stats.SeedingTime >= p.SeedTimeLimit {
+		return fmt.Sprintf("seeded for %s, limit %s",
+			time.Duration(stats.SeedingTime)*time.Second, time.Duration(p.SeedTimeLimit)*time.Second)
+	}
+	if p.IdleTimeout > 0 {
+		// Never uploaded: idle for as long as it has been seeding
+		idle := stats.IdleTime
+		if idle < 0 || idle > stats.SeedingTime {
+			idle = stats.SeedingTime
+		}
+		if idle >= p.IdleTimeout {
+			return fmt.Sprintf("idle for %s, timeout %s",
+				time.Duration(idle)*time.Second, time.Duration(p.IdleTimeout)*time.Second)
+		}
+	}
+	return ""
+}
+
+// SeedingDecision records one action taken by the seeding engine
+type SeedingDecision struct {
+	InfoHashV1 string
+	Action     SeedingAction
+	Reason     string
+	Err        error
+}
+
+// seedingStats collects the torrent's seeding statistics
+func (t *Torrent) seedingStats() SeedingStats {
+	return SeedingStats{
+		Ratio:       t.GetRatio(),
+		SeedingTime: t.GetSeedingTime(),
+		ActiveTime:  t.GetActiveTime(),
+		IdleTime:    t.GetIdleTime(),
+	}
+}
+
+// CheckSeedingGoals evaluates every seeding torrent and applies the policy
+// action to those that met a goal. Playing and paused torrents are never
+// touched, and a goal is acted on once per torrent: resuming a torrent
+// paused for its goal keeps it seeding.
+func (s *BTService) CheckSeedingGoals() []SeedingDecision {
+	s.mu.RLock()
+	torrents := make([]*Torrent, 0, len(s.torrents))
+	for _, torrent := range s.torrents {
+		torrents = append(torrents, torrent)
+	}
+	s.mu.RUnlock()
+
+	var decisions []SeedingDecision
+	for _, torrent := range torrents {
+		policy := s.seedingPolicyFor(torrent)
+		if policy == nil || !policy.Enabled || torrent.IsPlaying() || torrent.seedingGoalMet.Load() {
+			continue
+		}
+		if s.isUserPaused(torrent) || s.isQueued(torrent) || !torrent.IsSeeding() {
+			continue
+		}
+
+		reason := policy.Reason(torrent.seedingStats())
+		if reason == "" {
+			continue
+		}
+
+		// Concurrent checks act on a goal only once
+		if !torrent.seedingGoalMet.CompareAndSwap(false, true) {
+			continue
+		}
+
+		decision := SeedingDecision{
+			InfoHashV1: torrent.InfoHashV1,
+			Action:     policy.Action,
+			Reason:     reason,
+		}
+		switch policy.Action {
+		case SeedingActionPause:
+			torrent.Pause()
+		case SeedingActionRemove:
+			decision.Err = s.RemoveTorrent(torrent.InfoHashV1, false)
+		case SeedingActionRemoveFiles:
+			decision.Err = s.RemoveTorrent(torrent.InfoHashV1, true)
+		}
+
+		if decision.Err != nil {
+			log.Warningf("Seeding goal: %s %s failed (%s): %s",
+				decision.Action, torrent.InfoHashV1, reason, decision.Err)
+		} else {
+			log.Infof("Seeding goal: %s %s: %s", decision.Action, torrent.InfoHashV1, reason)
+		}
+		decisions = append(decisions, decision)
+	}
+	return decisions
+}
+
+// seedingEngine runs CheckSeedingGoals periodically
+type seedingEngine struct {
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+}
+
+// SetSeedingPolicy stores a new seeding policy in the config and starts or
+// stops the periodic checks to match
+func (s *BTService) SetSeedingPolicy(policy *SeedingPolicy) error {
+	if policy != nil {
+		if err := policy.Validate(); err != nil {
+			return err
+		}
+	}
+	s.config.SeedingPolicy = policy
+
+	if s.hasSeedingPolicy() {
+		s.startSeedingChecks(seedingCheckInterval)
+	} else {
+		s.stopSeedingChecks()
+	}
+	return nil
+}
+
+// hasSeedingPolicy reports whether any enabled policy, service-wide or
+// per category, needs the periodic checks
+func (s *BTService) hasSeedingPolicy() bool {
+	if p := s.config.SeedingPolicy; p != nil && p.Enabled {
+		return true
+	}
+	s.categoriesMu.RLock()
+	defer s.categoriesMu.RUnlock()
+	for _, c := range s.config.Categories {
+		if c.SeedingPolicy != nil && c.SeedingPolicy.Enabled {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *BTService) startSeedingChecks(interval time.Duration) {
+	s.seeding.mu.Lock()
+	defer s.seeding.mu.Unlock()
+	if s.seeding.stop != nil {
+		return
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	s.seeding.stop, s.seeding.done = stop, done
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.CheckSeedingGoals()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func (s *BTService) stopSeedingChecks() {
+	s.seeding.mu.Lock()
+	stop, done := s.seeding.stop, s.seeding.done
+	s.seeding.stop, s.seeding.done = nil, nil
+	s.seeding.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
@@ -9,6 +9,7 @@
 package bittorrent
 
 import (
+	"fmt"
 	"sync"
 
 	lt "github.com/ElementumOrg/libtorrent-go"
@@ -30,6 +31,43 @@ type BTService struct {
 	// Storage index tracking (2.0.x)
 	// Maps torrent info hash to storage_index_t for lookbehind access
 	storageIndices map[string]lt.StorageIndex
+
+	// Bandwidth scheduler (nil until a schedule is configured)
+	bandwidth *BandwidthScheduler
+
+	// Guards bandwidth and config.BandwidthSchedule
+	bandwidthMu sync.Mutex
+
+	// Periodic seeding goal checks
+	seeding seedingEngine
+
+	// Active download/seed slots and queue order
+	queue torrentQueue
+
+	// Watch-folder ingestion (nil when disabled)
+	watchFolder *WatchFolder
+
+	// HTTP client for AddTorrentFromURL (nil uses http.DefaultClient)
+	httpClient HTTPClient
+
+	// In-flight magnet metadata fetches
+	metadata metadataFetches
+
+	// Cached info dictionaries (nil when disabled)
+	metadataCache *MetadataCache
+
+	// Dispatches session alerts to ProcessAlert
+	alerts alertLoop
+
+	// Guards config.Categories
+	categoriesMu sync.RWMutex
+
+	// Serializes add_torrent calls, so the storage index predicted before
+	// an add is the one the torrent gets
+	addMu sync.Mutex
+
+	// Guards config.MemorySize and serializes memory budget changes
+	memoryMu sync.Mutex
 }
 
 // ServiceConfig holds BTService configuration
@@ -38,6 +76,34 @@ type ServiceConfig struct {
 	TorrentsPath     string
 	MemorySize       int64
 	ConnectionsLimit int
+	// SettingsProfile names the settings profile applied at startup;
+	// empty means lt.ProfileStreaming
+	SettingsProfile string
+	// BandwidthSchedule sets rate limits by time of day and playback state
+	BandwidthSchedule *BandwidthSchedule
+	// ThrottlePolicy caps other torrents while a stream buffers; nil disables it
+	ThrottlePolicy *ThrottlePolicy
+	// SeedingPolicy stops or removes torrents that met their seeding goals
+	SeedingPolicy *SeedingPolicy
+	// Queue limits the number of active downloads and seeds; nil is unlimited
+	Queue *QueueConfig
+	// Categories overrides the built-in categories; nil uses DefaultCategories
+	Categories map[string]*Category
+	// WatchFolder adds torrents dropped into a directory; nil disables it
+	WatchFolder *WatchFolderConfig
+	// MaxTorrentFileSize limits .torrent files loaded from memory or URLs;
+	// 0 uses DefaultMaxTorrentFileSize
+	MaxTorrentFileSize int64
+	// MetadataCachePath holds cached torrent metadata; empty uses
+	// TorrentsPath/metadata
+	MetadataCachePath string
+	// MetadataCacheSize limits the metadata cache in bytes; 0 uses
+	// DefaultMetadataCacheSize
+	MetadataCacheSize int64
+	// MemorySizeChanged is called after the memory budget changed at
+	// runtime, e.g. to re-run the daemon's lookbehind constraints through
+	// Configuration.SetMemorySize; nil ignores the change
+	MemorySizeChanged func(memorySize int64)
 	// Add other config fields as needed
 }
 
@@ -48,10 +114,36 @@ func NewBTService(config *ServiceConfig) (*BTService, error) {
 		torrents:       make(map[string]*Torrent),
 		storageIndices: make(map[string]lt.StorageIndex),
 	}
+	service.queue.init()
+
+	for _, c := range config.Categories {
+		if err := service.validateCategory(c); err != nil {
+			return nil, err
+		}
+	}
 
 	if err := service.initSession(); err != nil {
 		return nil, err
 	}
+	if err := service.initMetadataCache(); err != nil {
+		return nil, err
+	}
+	service.startAlertLoop()
+	service.startQueueChecks(queueCheckInterval)
+
+	if config.BandwidthSchedule != nil {
+		if err := service.SetBandwidthSchedule(config.BandwidthSchedule); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := service.SetSeedingPolicy(config.SeedingPolicy); err != nil {
+		return nil, err
+	}
+
+	if err := service.SetWatchFolder(config.WatchFolder); err != nil {
+		return nil, err
+	}
 
 	return service, nil
 }
@@ -86,21 +178,13 @@ func (s *BTService) initSession() error {
 
 // configureSettings applies settings to the settings pack
 func (s *BTService) configureSettings(settings *lt.SettingsPack) {
-	// Basic settings
-	settings.SetInt("connections_limit", s.config.ConnectionsLimit)
-	settings.SetStr("user_agent", "Elementum/2.0")
-
-	// DHT settings (now in settings_pack, not separate dht_settings)
-	settings.SetBool("enable_dht", true)
-	settings.SetInt("dht_max_peers_reply", 100)
-	settings.SetInt("dht_search_branching", 10)
+	s.configureBaseSettings(settings)
 
-	// Performance settings for streaming
-	settings.ConfigureForStreaming()
+	// Performance settings from the configured profile
+	s.activeProfile().ApplyTo(settings)
 
-	// 2.0.x specific settings
-	settings.SetInt("aio_threads", 4)
-	settings.SetInt("hashing_threads", 2)
+	// Alerts read by the alert loop
+	settings.SetInt("alert_mask", serviceAlertMask)
 
 	// Removed settings in 2.0.x (don't set these):
 	// - cache_size (OS handles caching with mmap)
@@ -109,8 +193,32 @@ func (s *BTService) configureSettings(settings *lt.SettingsPack) {
 	// - use_write_cache
 }
 
+// configureBaseSettings applies the service settings that hold under
+// every profile
+func (s *BTService) configureBaseSettings(settings *lt.SettingsPack) {
+	// Basic settings
+	settings.SetInt("connections_limit", s.config.ConnectionsLimit)
+	settings.SetStr("user_agent", "Elementum/2.0")
+
+	// DHT settings (now in settings_pack, not separate dht_settings)
+	settings.SetBool("enable_dht", true)
+	settings.SetInt("dht_max_peers_reply", 100)
+	settings.SetInt("dht_search_branching", 10)
+}
+
 // AddTorrent adds a torrent to the service (2.0.x version)
 func (s *BTService) AddTorrent(uri string, savePath string) (*Torrent, error) {
+	return s.AddTorrentWithOptions(uri, AddTorrentOptions{SavePath: savePath})
+}
+
+// AddTorrentWithOptions adds a torrent with a category and labels.
+// An empty SavePath uses the category's save path.
+func (s *BTService) AddTorrentWithOptions(uri string, opts AddTorrentOptions) (*Torrent, error) {
+	savePath, err := s.resolveSavePath(opts)
+	if err != nil {
+		return nil, err
+	}
+
 	// Create add_torrent_params
 	params := lt.NewAddTorrentParams()
 	params.SavePath = savePath
@@ -124,6 +232,13 @@ func (s *BTService) AddTorrent(uri string, savePath string) (*Torrent, error) {
 		}
 		params = parsedParams
 		params.SavePath = savePath
+
+		// Known metadata: skip the ut_metadata exchange
+		if ti := s.cachedTorrentInfo(params); ti != nil {
+			log.Infof("Using cached metadata for %s", params.GetInfoHashV1Hex())
+			params.SetTorrentInfo(ti)
+			lt.DeleteTorrentInfo(ti)
+		}
 	} else {
 		// Load torrent file
 		ti, err := lt.NewTorrentInfo(uri)
@@ -131,42 +246,71 @@ func (s *BTService) AddTorrent(uri string, savePath string) (*Torrent, error) {
 			return nil, err
 		}
 		params.SetTorrentInfo(ti)
+		s.cacheTorrentInfo(ti)
+	}
+
+	return s.addTorrentParams(params, opts.metadata())
+}
+
+// addTorrentParams adds prepared add_torrent_params to the session and
+// registers the torrent with the service
+func (s *BTService) addTorrentParams(params *lt.AddTorrentParams, meta TorrentMetadata) (*Torrent, error) {
+	// The queue pauses and resumes torrents itself
+	params.UnsetAutoManaged()
+
+	// A metadata fetch already has the torrent in the session: download on
+	// its handle. Its storage, if any, is not the predicted next one.
+	infoHash := params.GetInfoHashV1Hex()
+	if handle := s.takeOverMetadataFetch(infoHash, params.SavePath); handle != nil {
+		return s.registerTorrent(handle, lt.FindStorageIndex(infoHash), meta), nil
 	}
 
 	// Get next storage index before adding torrent
 	// This predicts the storage_index_t that will be assigned
+	s.addMu.Lock()
 	nextIdx := lt.Get_next_storage_index()
 
 	// Add torrent to session
 	// In 2.0.x, we need to track the storage_index_t returned
 	handle, err := s.Session.AddTorrent(params)
+	s.addMu.Unlock()
 	if err != nil {
 		return nil, err
 	}
 
+	// Track storage index for lookbehind access
+	// Use the predicted index from before add_torrent
+	return s.registerTorrent(handle, lt.StorageIndex(nextIdx), meta), nil
+}
+
+// registerTorrent wraps a handle in the session as a service torrent and
+// queues it
+func (s *BTService) registerTorrent(handle *lt.TorrentHandle, storageIdx lt.StorageIndex, meta TorrentMetadata) *Torrent {
 	// Get info hashes (2.0.x)
 	infoHashes := handle.GetInfoHashes()
 	infoHashV1 := infoHashes.V1Hex()
 
-	// Track storage index for lookbehind access
-	// Use the predicted index from before add_torrent
-	storageIdx := lt.StorageIndex(nextIdx)
 	s.memoryDiskIO.RegisterTorrent(infoHashV1, storageIdx)
 
 	// Create Torrent wrapper
 	torrent := &Torrent{
 		Handle:       handle,
 		InfoHashV1:   infoHashV1,
-		StorageIndex: lt.StorageIndex(storageIdx),
+		StorageIndex: storageIdx,
 		service:      s,
 	}
+	torrent.setMetadata(meta)
 
 	s.mu.Lock()
 	s.torrents[infoHashV1] = torrent
-	s.storageIndices[infoHashV1] = lt.StorageIndex(storageIdx)
+	s.storageIndices[infoHashV1] = storageIdx
 	s.mu.Unlock()
 
-	return torrent, nil
+	// Queue the new torrent, then throttle it if a stream is buffering
+	s.enqueue(torrent)
+	s.updateThrottling()
+
+	return torrent
 }
 
 // RemoveTorrent removes a torrent from the service
@@ -181,6 +325,9 @@ func (s *BTService) RemoveTorrent(infoHashV1 string, deleteFiles bool) error {
 	delete(s.storageIndices, infoHashV1)
 	s.mu.Unlock()
 
+	// Free its queue slot for the next torrent
+	s.dequeue(torrent)
+
 	// Unregister from memory disk I/O
 	s.memoryDiskIO.UnregisterTorrent(infoHashV1)
 
@@ -201,6 +348,137 @@ func (s *BTService) GetTorrent(infoHashV1 string) *Torrent {
 	return s.torrents[infoHashV1]
 }
 
+// ApplySettings applies settings to the running session without a restart.
+// The memory storage size is not a setting; change it with SetMemorySize.
+func (s *BTService) ApplySettings(settings *lt.SettingsPack) error {
+	return s.Session.ApplySettings(settings)
+}
+
+// SetProfile switches the running session to a named settings profile.
+// Settings the previous profile set and the new one does not go back to
+// the service's base settings, or libtorrent's defaults; settings neither
+// profile covers keep their current values.
+func (s *BTService) SetProfile(name string) error {
+	profile, err := lt.GetProfile(name)
+	if err != nil {
+		return err
+	}
+
+	// The base holds libtorrent's defaults under the service settings, so
+	// settings only the previous profile set go back to their stock values
+	base := lt.DefaultSettingsPack()
+	defer base.Delete()
+	s.configureBaseSettings(base)
+
+	settings := s.activeProfile().SwitchTo(profile, base)
+	defer settings.Delete()
+
+	if err := s.ApplySettings(settings); err != nil {
+		return err
+	}
+	s.config.SettingsProfile = name
+
+	// The profile may have replaced the scheduled rate limits
+	if bs := s.BandwidthScheduler(); bs != nil {
+		if err := bs.reapply(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Profile returns the name of the active settings profile
+func (s *BTService) Profile() string {
+	return s.profileName()
+}
+
+func (s *BTService) profileName() string {
+	if s.config.SettingsProfile == "" {
+		return lt.ProfileStreaming
+	}
+	return s.config.SettingsProfile
+}
+
+// activeProfile returns the configured profile, falling back to the
+// streaming profile when it is unknown
+func (s *BTService) activeProfile() *lt.SettingsProfile {
+	profile, err := lt.GetProfile(s.profileName())
+	if err == nil {
+		return profile
+	}
+	log.Warningf("%s, using the %s profile", err, lt.ProfileStreaming)
+	// Built-in profiles cannot be unregistered
+	profile, _ = lt.GetProfile(lt.ProfileStreaming)
+	return profile
+}
+
+// SetMemorySize changes the memory budget of the running session.
+// Live storages are resized in place, so no session restart is needed,
+// and lookbehind windows are capped to fit the new limits.
+func (s *BTService) SetMemorySize(memorySize int64) ([]lt.StorageLimits, error) {
+	s.memoryMu.Lock()
+	if s.config.MemorySize <= 0 {
+		s.memoryMu.Unlock()
+		return nil, fmt.Errorf("memory storage is not enabled")
+	}
+	limits, err := s.memoryDiskIO.SetMemorySize(memorySize)
+	if err != nil {
+		s.memoryMu.Unlock()
+		return nil, err
+	}
+	s.memorySizeChangedLocked(memorySize, limits)
+	s.memoryMu.Unlock()
+
+	s.notifyMemorySizeChanged(memorySize)
+	return limits, nil
+}
+
+// memorySize returns the current memory budget, 0 for disk storage
+func (s *BTService) memorySize() int64 {
+	s.memoryMu.Lock()
+	defer s.memoryMu.Unlock()
+	return s.config.MemorySize
+}
+
+// memorySizeChangedLocked records a new memory budget and caps lookbehind
+// windows to the storages' new limits. Call it with memoryMu held.
+func (s *BTService) memorySizeChangedLocked(memorySize int64, limits []lt.StorageLimits) {
+	s.config.MemorySize = memorySize
+	s.enforceLookbehindConstraints(limits)
+}
+
+func (s *BTService) notifyMemorySizeChanged(memorySize int64) {
+	if s.config.MemorySizeChanged != nil {
+		s.config.MemorySizeChanged(memorySize)
+	}
+}
+
+// enforceLookbehindConstraints hands each torrent's lookbehind manager its
+// storage's buffer limit, which caps the window through lookbehindSize
+func (s *BTService) enforceLookbehindConstraints(limits []lt.StorageLimits) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, limit := range limits {
+		for _, torrent := range s.torrents {
+			if torrent.StorageIndex != limit.StorageIndex || torrent.lookbehind == nil {
+				continue
+			}
+			torrent.lookbehind.SetStorageLimit(limit.BufferLimit)
+		}
+	}
+}
+
+// storageLimit returns the buffer limit of one memory storage
+func (s *BTService) storageLimit(index lt.StorageIndex) (lt.StorageLimits, bool) {
+	for _, limit := range s.memoryDiskIO.StorageLimits() {
+		if limit.StorageIndex == index {
+			return limit, true
+		}
+	}
+	return lt.StorageLimits{}, false
+}
+
 // SaveSessionState saves the session state (2.0.x way)
 func (s *BTService) SaveSessionState() ([]byte, error) {
 	// Uses write_session_params instead of save_state
@@ -209,6 +487,16 @@ func (s *BTService) SaveSessionState() ([]byte, error) {
 
 // Close shuts down the service
 func (s *BTService) Close() {
+	if bs := s.BandwidthScheduler(); bs != nil {
+		bs.Stop()
+	}
+	if s.watchFolder != nil {
+		s.watchFolder.Stop()
+	}
+	s.stopSeedingChecks()
+	s.stopQueueChecks()
+	s.stopAlertLoop()
+
 	if s.Session != nil {
 		// Session destructor handles cleanup
 		lt.DeleteSession(s.Session)
@@ -0,0 +1,69 @@
+// settings_debug_2.0.x.go - Settings debug endpoint for libtorrent 2.0.x
+//
+// Exposes the session's effective settings and how they differ from
+// libtorrent's defaults, so slow stream reports can include exactly which
+// settings are not stock.
+
+package bittorrent
+
+import (
+	"encoding/json"
+	"net/http"
+
+	lt "github.com/ElementumOrg/libtorrent-go"
+)
+
+// SettingsDebugInfo is the payload of the settings debug endpoint
+type SettingsDebugInfo struct {
+	Profile  string              `json:"profile"`
+	Changed  []lt.SettingChange  `json:"changed"`
+	Settings lt.SettingsSnapshot `json:"settings,omitempty"`
+}
+
+// SettingsDebug returns the settings that differ from libtorrent's defaults.
+// With full set, every effective setting is included as well.
+func (s *BTService) SettingsDebug(full bool) (*SettingsDebugInfo, error) {
+	snapshot, err := s.Session.SnapshotSettings()
+	if err != nil {
+		return nil, err
+	}
+	changed, err := snapshot.DiffFromDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	info := &SettingsDebugInfo{
+		Profile: s.Profile(),
+		Changed: changed,
+	}
+	if full {
+		info.Settings = snapshot
+	}
+	return info, nil
+}
+
+// SettingsDebugHandler serves SettingsDebug as JSON.
+// Add ?full=1 to include every effective setting.
+func (s *BTService) SettingsDebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		full := r.URL.Query().Get("full") != ""
+
+		info, err := s.SettingsDebug(full)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// Encode before writing, so a failure can still be reported
+		body, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write(append(body, '\n')); err != nil {
+			log.Warningf("Settings debug: cannot write response: %s", err)
+		}
+	})
+}
@@ -9,6 +9,13 @@
 package bittorrent
 
 import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
 	lt "github.com/ElementumOrg/libtorrent-go"
 )
 
@@ -20,9 +27,37 @@ type Torrent struct {
 	service      *BTService
 
 	// Playback state
-	IsPlaying    bool
+	playing      atomic.Bool
 	ReaderOffset int64
 	ReaderPiece  int
+
+	// Lookbehind buffer manager (nil until InitLookbehind)
+	lookbehind *LookbehindManager
+
+	// Last reader id handed out by AddReader
+	lastReaderID int32
+
+	// Per-torrent limits and buffering throttle
+	limits torrentLimitState
+
+	// Category and labels, persisted in resume data
+	meta torrentMeta
+
+	// Set once a seeding goal was acted on, so a manual resume sticks
+	seedingGoalMet atomic.Bool
+
+	// Called from the alert loop for every verified piece
+	pieceListeners pieceListeners
+
+	// Save path for reads from disk storage
+	location diskLocation
+}
+
+// pieceListeners holds the OnPieceFinished callbacks by id
+type pieceListeners struct {
+	mu     sync.Mutex
+	lastID int
+	fns    map[int]func(piece int)
 }
 
 // GetInfoHashes returns the info_hash_t for this torrent (2.0.x)
@@ -116,6 +151,385 @@ func (t *Torrent) GetLookbehindStats() lt.LookbehindStats {
 	return lt.GetLookbehindStats(t.StorageIndex)
 }
 
+// GetCacheStats returns piece cache hit/miss and eviction counters
+func (t *Torrent) GetCacheStats() lt.CacheStats {
+	return lt.GetCacheStats(t.StorageIndex)
+}
+
+// InitLookbehind creates the lookbehind manager for this torrent, capped
+// to the torrent's current storage limit
+func (t *Torrent) InitLookbehind(config *LookbehindConfig) *LookbehindManager {
+	t.lookbehind = NewLookbehindManager(t, config)
+	if t.service != nil {
+		if limit, ok := t.service.storageLimit(t.StorageIndex); ok {
+			t.lookbehind.SetStorageLimit(limit.BufferLimit)
+		}
+	}
+	return t.lookbehind
+}
+
+// Lookbehind returns the lookbehind manager, or nil if not initialized
+func (t *Torrent) Lookbehind() *LookbehindManager {
+	return t.lookbehind
+}
+
+// Reader read-ahead protection (2.0.x - via session-level disk_interface)
+
+// AddReader registers a reader with its read-ahead range and returns its id.
+// Pieces in the range are kept in memory until the reader moves on.
+func (t *Torrent) AddReader(firstPiece, lastPiece int) int {
+	id := int(atomic.AddInt32(&t.lastReaderID, 1))
+	lt.SetReaderRange(t.StorageIndex, id, firstPiece, lastPiece)
+	return id
+}
+
+// UpdateReader moves a reader's read-ahead range, e.g. after a read or seek
+func (t *Torrent) UpdateReader(readerID, firstPiece, lastPiece int) {
+	lt.SetReaderRange(t.StorageIndex, readerID, firstPiece, lastPiece)
+}
+
+// RemoveReader unregisters a reader when it is closed
+func (t *Torrent) RemoveReader(readerID int) {
+	lt.RemoveReader(t.StorageIndex, readerID)
+}
+
+// Piece data (2.0.x - read from the session-level disk_interface)
+
+// Piece read errors
+var (
+	// ErrPieceNotAvailable is returned by ReadAt for data of a piece that
+	// is not downloaded yet
+	ErrPieceNotAvailable = errors.New("piece not available")
+	// ErrPieceEvicted is returned by ReadAt for a verified piece that was
+	// evicted from memory storage. libtorrent does not download a piece it
+	// has again, so waiting for it is pointless.
+	ErrPieceEvicted = errors.New("piece evicted from memory")
+)
+
+// PieceLength returns the torrent's piece length, or 0 without metadata
+func (t *Torrent) PieceLength() int64 {
+	ti := t.Handle.TorrentFile()
+	if ti == nil {
+		return 0
+	}
+	return int64(ti.PieceLengthInt())
+}
+
+// NumPieces returns the number of pieces, or 0 without metadata
+func (t *Torrent) NumPieces() int {
+	ti := t.Handle.TorrentFile()
+	if ti == nil {
+		return 0
+	}
+	return ti.NumPiecesInt()
+}
+
+// HavePiece reports whether a piece is verified. With memory storage its
+// data may have been evicted since, which ReadAt reports as
+// ErrPieceEvicted.
+func (t *Torrent) HavePiece(piece int) bool {
+	return t.Handle.HavePieceInt(piece)
+}
+
+// ReadAt reads torrent data at a byte offset into the concatenated files,
+// from memory storage or from the files on disk depending on the session's
+// storage. It does not wait: reading a piece that is not available stops
+// with ErrPieceNotAvailable or ErrPieceEvicted after the bytes read so far.
+func (t *Torrent) ReadAt(p []byte, off int64) (int, error) {
+	if t.readsFromDisk() {
+		return t.readFilesAt(p, off, readFileAt)
+	}
+
+	pieceLength := t.PieceLength()
+	if pieceLength == 0 {
+		return 0, ErrNoMetadata
+	}
+	numPieces := t.NumPieces()
+
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		piece := int(pos / pieceLength)
+		if piece >= numPieces {
+			return n, io.EOF
+		}
+		start := int(pos % pieceLength)
+		size := len(p) - n
+		if size > int(pieceLength)-start {
+			size = int(pieceLength) - start
+		}
+
+		if !t.Handle.HavePieceInt(piece) {
+			return n, ErrPieceNotAvailable
+		}
+		data, ok := lt.ReadPieceData(t.StorageIndex, piece, start, size)
+		if !ok {
+			return n, ErrPieceEvicted
+		}
+		n += copy(p[n:], data)
+		// Only the last piece is short
+		if len(data) < size {
+			return n, io.EOF
+		}
+	}
+	return n, nil
+}
+
+func (t *Torrent) readsFromDisk() bool {
+	return t.service != nil && t.service.Storage() == StorageDisk
+}
+
+// fileReadFunc fills p from the torrent file at index, found at name, at
+// off. moves is the storage move count name was built for.
+type fileReadFunc func(index int, name string, moves int, p []byte, off int64) (int, error)
+
+// readFilesAt reads verified torrent data from the files under the save
+// path through readFile. Pad files are never written and read as zeros.
+func (t *Torrent) readFilesAt(p []byte, off int64, readFile fileReadFunc) (int, error) {
+	ti := t.Handle.TorrentFile()
+	if ti == nil {
+		return 0, ErrNoMetadata
+	}
+	pieceLength := int64(ti.PieceLengthInt())
+	totalSize := ti.TotalSizeInt()
+	if off >= totalSize {
+		return 0, io.EOF
+	}
+
+	// Read up to the end of the torrent or the first missing piece
+	end := off + int64(len(p))
+	var stopErr error
+	if end > totalSize {
+		end, stopErr = totalSize, io.EOF
+	}
+	for pos := off - off%pieceLength; pos < end; pos += pieceLength {
+		if !t.Handle.HavePieceInt(int(pos / pieceLength)) {
+			if pos > off {
+				end = pos
+			} else {
+				end = off
+			}
+			stopErr = ErrPieceNotAvailable
+			break
+		}
+	}
+
+	savePath, moves := t.savePath()
+	n := 0
+	for i := 0; i < ti.NumFilesInt() && off+int64(n) < end; i++ {
+		fileOffset, fileSize := ti.FileOffsetAt(i), ti.FileSizeAt(i)
+		pos := off + int64(n)
+		if pos >= fileOffset+fileSize {
+			continue
+		}
+		chunkEnd := fileOffset + fileSize
+		if chunkEnd > end {
+			chunkEnd = end
+		}
+		chunk := p[n : n+int(chunkEnd-pos)]
+
+		if ti.FileIsPadAt(i) {
+			for j := range chunk {
+				chunk[j] = 0
+			}
+			n += len(chunk)
+			continue
+		}
+		read, err := readFile(i, filepath.Join(savePath, ti.FilePathAt(i)), moves, chunk, pos-fileOffset)
+		n += read
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, stopErr
+}
+
+// readFileAt fills p from one file at off, opening it for this read only
+func readFileAt(_ int, name string, _ int, p []byte, off int64) (int, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return f.ReadAt(p, off)
+}
+
+// diskLocation caches the save path, so reads from disk storage do not
+// query the torrent status every time. It is forgotten when the storage
+// moves; moves counts the moves so data readers reopen their files.
+type diskLocation struct {
+	mu       sync.Mutex
+	savePath string
+	moves    int
+}
+
+// savePath returns the save path and the storage move count it is for
+func (t *Torrent) savePath() (string, int) {
+	l := &t.location
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.savePath == "" {
+		l.savePath = t.GetStatus().GetSavePath()
+	}
+	return l.savePath, l.moves
+}
+
+// storageMoved forgets the save path once the files moved. Called from the
+// alert loop on storage_moved_alert.
+func (t *Torrent) storageMoved() {
+	l := &t.location
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.savePath = ""
+	l.moves++
+}
+
+// ReadAtCloser is the reader NewDataReader returns. It is an alias, so
+// interfaces in other packages can declare the same method signature.
+type ReadAtCloser = interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// NewDataReader returns a reader of the torrent data. It reads like ReadAt,
+// but with disk storage keeps the files it opened until Close instead of
+// opening them for every read. Open one per consumer, e.g. per stream
+// reader. It is safe for concurrent use.
+func (t *Torrent) NewDataReader() ReadAtCloser {
+	return &dataReader{torrent: t}
+}
+
+type dataReader struct {
+	torrent *Torrent
+
+	mu     sync.Mutex
+	files  map[int]*os.File // by file index
+	moves  int              // storage move count the files were opened at
+	closed bool
+}
+
+func (r *dataReader) ReadAt(p []byte, off int64) (int, error) {
+	if r.torrent.readsFromDisk() {
+		return r.torrent.readFilesAt(p, off, r.readFileAt)
+	}
+	return r.torrent.ReadAt(p, off)
+}
+
+func (r *dataReader) readFileAt(index int, name string, moves int, p []byte, off int64) (int, error) {
+	f, err := r.file(index, name, moves)
+	if err != nil {
+		return 0, err
+	}
+	return f.ReadAt(p, off)
+}
+
+// file returns the open file at index, closing every file opened before
+// the storage last moved
+func (r *dataReader) file(index int, name string, moves int) (*os.File, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil, os.ErrClosed
+	}
+	if moves != r.moves {
+		r.closeFilesLocked()
+		r.moves = moves
+	}
+	if f, ok := r.files[index]; ok {
+		return f, nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if r.files == nil {
+		r.files = make(map[int]*os.File)
+	}
+	r.files[index] = f
+	return f, nil
+}
+
+// Close closes the files the reader opened. Reads fail afterwards.
+func (r *dataReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	r.closeFilesLocked()
+	return nil
+}
+
+func (r *dataReader) closeFilesLocked() {
+	for index, f := range r.files {
+		f.Close()
+		delete(r.files, index)
+	}
+}
+
+// OnPieceFinished calls fn from the alert loop for every piece the torrent
+// verifies, until the returned function is called. fn must not block.
+func (t *Torrent) OnPieceFinished(fn func(piece int)) (remove func()) {
+	l := &t.pieceListeners
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.fns == nil {
+		l.fns = make(map[int]func(piece int))
+	}
+	l.lastID++
+	id := l.lastID
+	l.fns[id] = fn
+
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		delete(l.fns, id)
+	}
+}
+
+// pieceFinished notifies the OnPieceFinished listeners
+func (t *Torrent) pieceFinished(piece int) {
+	l := &t.pieceListeners
+	l.mu.Lock()
+	fns := make([]func(piece int), 0, len(l.fns))
+	for _, fn := range l.fns {
+		fns = append(fns, fn)
+	}
+	l.mu.Unlock()
+
+	for _, fn := range fns {
+		fn(piece)
+	}
+}
+
+// IsPlaying reports whether a stream of the torrent is playing. It
+// replaces the exported IsPlaying field; set the state with SetPlaying so
+// the queue, scheduler and throttles follow.
+func (t *Torrent) IsPlaying() bool {
+	return t.playing.Load()
+}
+
+// SetPlaying marks the torrent as playing or not. A playing torrent jumps
+// the queue, and the bandwidth scheduler and throttles are updated right
+// away so limits relax as soon as a stream starts.
+func (t *Torrent) SetPlaying(playing bool) {
+	t.playing.Store(playing)
+	if t.service == nil {
+		return
+	}
+	if playing {
+		if err := t.service.jumpQueue(t); err != nil {
+			log.Warningf("Playing torrent not moved to the top of the queue: %s", err)
+		}
+	} else {
+		t.service.updateQueue()
+	}
+	if bs := t.service.BandwidthScheduler(); bs != nil {
+		bs.Update()
+	}
+	t.service.updateThrottling()
+}
+
 // Timing helpers (chrono -> int64 seconds)
 
 // GetActiveTime returns active time in seconds
@@ -133,6 +547,32 @@ func (t *Torrent) GetSeedingTime() int64 {
 	return t.GetStatus().GetSeedingTimeSeconds()
 }
 
+// GetIdleTime returns seconds since payload was last uploaded, or -1 if
+// nothing was uploaded yet
+func (t *Torrent) GetIdleTime() int64 {
+	return t.GetStatus().GetSecondsSinceUpload()
+}
+
+// GetRatio returns the share ratio: all-time upload over all-time download.
+// Torrents added from complete data download nothing, so their wanted size
+// stands in for the download.
+func (t *Torrent) GetRatio() float64 {
+	status := t.GetStatus()
+	downloaded := status.GetAllTimeDownloadBytes()
+	if downloaded <= 0 {
+		downloaded = status.GetTotalWantedBytes()
+	}
+	if downloaded <= 0 {
+		return 0
+	}
+	return float64(status.GetAllTimeUploadBytes()) / float64(downloaded)
+}
+
+// IsSeeding returns true if the torrent has all wanted pieces and uploads
+func (t *Torrent) IsSeeding() bool {
+	return t.GetStatus().GetIsSeeding()
+}
+
 // Tracker operations (updated for hybrid torrent support)
 
 // TrackerInfo holds announce results for a tracker
@@ -185,21 +625,36 @@ func (t *Torrent) GetTrackers() []TrackerInfo {
 
 // Resume data operations
 
-// SaveResumeData requests resume data save
+// SaveResumeData requests resume data save.
+// The resume data holds the user's limits even while throttled.
 func (t *Torrent) SaveResumeData() {
-	t.Handle.SaveResumeData()
+	t.saveResumeDataWithUserLimits()
 }
 
 // Control operations
 
-// Pause pauses the torrent
+// Pause pauses the torrent. The queue leaves it paused until Resume.
 func (t *Torrent) Pause() {
-	t.Handle.Pause()
+	if t.service == nil {
+		t.Handle.Pause()
+		return
+	}
+	t.service.setUserPaused(t, true)
 }
 
-// Resume resumes the torrent
+// Resume resumes the torrent. If no active slot is free it stays queued
+// until one is.
 func (t *Torrent) Resume() {
-	t.Handle.Resume()
+	if t.service == nil {
+		t.Handle.Resume()
+		return
+	}
+	t.service.setUserPaused(t, false)
+}
+
+// IsQueued returns true if the torrent is waiting for an active slot
+func (t *Torrent) IsQueued() bool {
+	return t.service != nil && t.service.isQueued(t)
 }
 
 // ForceRecheck forces a recheck of all pieces
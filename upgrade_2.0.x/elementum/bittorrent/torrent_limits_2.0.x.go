@@ -0,0 +1,283 @@
+// torrent_limits_2.0.x.go - Per-torrent rate limits for libtorrent 2.0.x
+//
+// Limits set here are stored on the torrent_handle, so libtorrent writes
+// them into resume data (upload_rate_limit, download_rate_limit,
+// max_connections, max_uploads) and restores them when the torrent is
+// added from that resume data.
+//
+// While a stream is buffering, the throttle policy caps every other torrent
+// so background seeding does not compete with it. The bandwidth schedule
+// can cap them the same way for as long as a torrent plays. Throttling
+// never touches the user's limits: they are restored when buffering or
+// playback ends, and resume data is always saved with the user's limits.
+
+package bittorrent
+
+import (
+	"sync"
+)
+
+// TorrentLimits holds per-torrent limits. Rates are in bytes per second;
+// 0 means unlimited for every field.
+type TorrentLimits struct {
+	DownloadLimit  int `json:"download_limit"`
+	UploadLimit    int `json:"upload_limit"`
+	MaxConnections int `json:"max_connections"`
+	MaxUploads     int `json:"max_uploads"`
+}
+
+// ThrottlePolicy caps non-playing torrents while any stream is buffering
+type ThrottlePolicy struct {
+	Enabled bool          `json:"enabled"`
+	Limits  TorrentLimits `json:"limits"`
+}
+
+// DefaultThrottlePolicy leaves background torrents a trickle of bandwidth
+// and a handful of peers, enough to keep their swarms alive
+var DefaultThrottlePolicy = ThrottlePolicy{
+	Enabled: true,
+	Limits: TorrentLimits{
+		DownloadLimit:  32 * 1024,
+		UploadLimit:    16 * 1024,
+		MaxConnections: 8,
+		MaxUploads:     2,
+	},
+}
+
+// torrentLimitState tracks the user's limits and whether a throttle is in
+// effect on top of them
+type torrentLimitState struct {
+	mu        sync.Mutex
+	loaded    bool
+	user      TorrentLimits
+	throttle  *TorrentLimits
+	buffering bool
+}
+
+// loadLimitsLocked reads the limits restored from resume data
+func (t *Torrent) loadLimitsLocked() {
+	if t.limits.loaded {
+		return
+	}
+	t.limits.user = TorrentLimits{
+		DownloadLimit:  unlimitedToZero(t.Handle.DownloadLimit()),
+		UploadLimit:    unlimitedToZero(t.Handle.UploadLimit()),
+		MaxConnections: unlimitedToZero(t.Handle.MaxConnections()),
+		MaxUploads:     unlimitedToZero(t.Handle.MaxUploads()),
+	}
+	t.limits.loaded = true
+}
+
+// applyLimitsLocked pushes the effective limits to the handle
+func (t *Torrent) applyLimitsLocked() {
+	effective := t.limits.user
+	if t.limits.throttle != nil {
+		effective = effective.capped(*t.limits.throttle)
+	}
+	t.applyToHandle(effective)
+}
+
+func (t *Torrent) applyToHandle(l TorrentLimits) {
+	t.Handle.SetDownloadLimit(zeroToUnlimited(l.DownloadLimit))
+	t.Handle.SetUploadLimit(zeroToUnlimited(l.UploadLimit))
+	t.Handle.SetMaxConnections(zeroToUnlimited(l.MaxConnections))
+	t.Handle.SetMaxUploads(zeroToUnlimited(l.MaxUploads))
+}
+
+// SetDownloadLimit sets the download rate limit in bytes/s, 0 for unlimited
+func (t *Torrent) SetDownloadLimit(limit int) {
+	t.updateLimits(func(l *TorrentLimits) { l.DownloadLimit = limit })
+}
+
+// SetUploadLimit sets the upload rate limit in bytes/s, 0 for unlimited
+func (t *Torrent) SetUploadLimit(limit int) {
+	t.updateLimits(func(l *TorrentLimits) { l.UploadLimit = limit })
+}
+
+// SetMaxConnections caps the number of peer connections, 0 for unlimited
+func (t *Torrent) SetMaxConnections(limit int) {
+	t.updateLimits(func(l *TorrentLimits) { l.MaxConnections = limit })
+}
+
+// SetMaxUploads caps the number of unchoked peers, 0 for unlimited
+func (t *Torrent) SetMaxUploads(limit int) {
+	t.updateLimits(func(l *TorrentLimits) { l.MaxUploads = limit })
+}
+
+// SetLimits replaces all per-torrent limits at once
+func (t *Torrent) SetLimits(limits TorrentLimits) {
+	t.updateLimits(func(l *TorrentLimits) { *l = limits })
+}
+
+func (t *Torrent) updateLimits(update func(*TorrentLimits)) {
+	t.limits.mu.Lock()
+	defer t.limits.mu.Unlock()
+
+	t.loadLimitsLocked()
+	update(&t.limits.user)
+	t.limits.user = t.limits.user.normalized()
+	t.applyLimitsLocked()
+}
+
+// Limits returns the limits set by the user, ignoring any throttle
+func (t *Torrent) Limits() TorrentLimits {
+	t.limits.mu.Lock()
+	defer t.limits.mu.Unlock()
+
+	t.loadLimitsLocked()
+	return t.limits.user
+}
+
+// IsThrottled reports whether a buffering or playback throttle is applied
+func (t *Torrent) IsThrottled() bool {
+	t.limits.mu.Lock()
+	defer t.limits.mu.Unlock()
+	return t.limits.throttle != nil
+}
+
+// setThrottle applies or lifts a throttle on top of the user's limits
+func (t *Torrent) setThrottle(throttle *TorrentLimits) {
+	t.limits.mu.Lock()
+	defer t.limits.mu.Unlock()
+
+	if throttle == nil && t.limits.throttle == nil {
+		return
+	}
+	t.loadLimitsLocked()
+	t.limits.throttle = throttle
+	t.applyLimitsLocked()
+}
+
+// saveResumeDataWithUserLimits requests resume data holding the user's
+// limits. Handle calls run in order on the session thread, so the throttle
+// is lifted exactly for the save and put back straight after it.
+func (t *Torrent) saveResumeDataWithUserLimits() {
+	t.limits.mu.Lock()
+	defer t.limits.mu.Unlock()
+
+	if t.limits.throttle == nil {
+		t.Handle.SaveResumeData()
+		return
+	}
+	t.applyToHandle(t.limits.user)
+	t.Handle.SaveResumeData()
+	t.applyLimitsLocked()
+}
+
+// SetBuffering marks the torrent's stream as buffering or not, and
+// throttles or releases the other torrents accordingly
+func (t *Torrent) SetBuffering(buffering bool) {
+	t.limits.mu.Lock()
+	t.limits.buffering = buffering
+	t.limits.mu.Unlock()
+
+	if t.service != nil {
+		t.service.updateThrottling()
+	}
+}
+
+// IsBuffering reports whether the torrent's stream is buffering
+func (t *Torrent) IsBuffering() bool {
+	t.limits.mu.Lock()
+	defer t.limits.mu.Unlock()
+	return t.limits.buffering
+}
+
+// updateThrottling applies the throttle policy: while any stream is
+// buffering, every torrent that is neither playing nor buffering is capped.
+// While any torrent plays, the bandwidth schedule's background limits cap
+// the torrents that are not playing as well.
+func (s *BTService) updateThrottling() {
+	policy := s.config.ThrottlePolicy
+	var background *TorrentLimits
+	if bs := s.BandwidthScheduler(); bs != nil {
+		background = bs.backgroundLimits()
+	}
+
+	s.mu.RLock()
+	torrents := make([]*Torrent, 0, len(s.torrents))
+	for _, torrent := range s.torrents {
+		torrents = append(torrents, torrent)
+	}
+	s.mu.RUnlock()
+
+	anyBuffering, anyPlaying := false, false
+	for _, torrent := range torrents {
+		anyBuffering = anyBuffering || torrent.IsBuffering()
+		anyPlaying = anyPlaying || torrent.IsPlaying()
+	}
+
+	for _, torrent := range torrents {
+		if torrent.IsPlaying() || torrent.IsBuffering() {
+			torrent.setThrottle(nil)
+			continue
+		}
+
+		var throttle *TorrentLimits
+		if policy != nil && policy.Enabled && anyBuffering {
+			limits := policy.Limits
+			throttle = &limits
+		}
+		if background != nil && anyPlaying {
+			limits := *background
+			if throttle != nil {
+				limits = throttle.capped(limits)
+			}
+			throttle = &limits
+		}
+		torrent.setThrottle(throttle)
+	}
+}
+
+// SetThrottlePolicy stores a new throttle policy in the config and applies it
+func (s *BTService) SetThrottlePolicy(policy *ThrottlePolicy) {
+	s.config.ThrottlePolicy = policy
+	s.updateThrottling()
+}
+
+// capped returns l with every limit lowered to at most c's, treating 0 as
+// unlimited on both sides
+func (l TorrentLimits) capped(c TorrentLimits) TorrentLimits {
+	return TorrentLimits{
+		DownloadLimit:  minLimit(l.DownloadLimit, c.DownloadLimit),
+		UploadLimit:    minLimit(l.UploadLimit, c.UploadLimit),
+		MaxConnections: minLimit(l.MaxConnections, c.MaxConnections),
+		MaxUploads:     minLimit(l.MaxUploads, c.MaxUploads),
+	}
+}
+
+// normalized maps negative values (libtorrent's unlimited) to 0
+func (l TorrentLimits) normalized() TorrentLimits {
+	return TorrentLimits{
+		DownloadLimit:  unlimitedToZero(l.DownloadLimit),
+		UploadLimit:    unlimitedToZero(l.UploadLimit),
+		MaxConnections: unlimitedToZero(l.MaxConnections),
+		MaxUploads:     unlimitedToZero(l.MaxUploads),
+	}
+}
+
+func minLimit(a, b int) int {
+	switch {
+	case a == 0:
+		return b
+	case b == 0 || a < b:
+		return a
+	}
+	return b
+}
+
+// libtorrent reports unlimited connection and upload caps as -1 or a
+// huge value, and unlimited rates as 0 or -1
+func unlimitedToZero(v int) int {
+	if v < 0 || v >= 0xffffff {
+		return 0
+	}
+	return v
+}
+
+func zeroToUnlimited(v int) int {
+	if v <= 0 {
+		return -1
+	}
+	return v
+}
@@ -0,0 +1,99 @@
+// torrent_loader_2.0.x.go - Loading .torrent files from memory and URLs
+//
+// Torrent files from the network are size-limited and their bencode
+// structure is checked before libtorrent sees them, see the torrentfile
+// package. Errors are *TorrentFileError values and match
+// ErrInvalidBencode, ErrMissingInfoDict, ErrPieceLength,
+// ErrTorrentTooLarge or ErrTorrentDownload with errors.Is.
+
+package bittorrent
+
+import (
+	"context"
+	"errors"
+
+	lt "github.com/ElementumOrg/libtorrent-go"
+	"github.com/elgatito/elementum/torrentfile"
+)
+
+// DefaultMaxTorrentFileSize limits .torrent files when
+// ServiceConfig.MaxTorrentFileSize is not set
+const DefaultMaxTorrentFileSize = torrentfile.DefaultMaxSize
+
+// Torrent file error kinds
+var (
+	ErrInvalidBencode     = torrentfile.ErrInvalidBencode
+	ErrMissingInfoDict    = torrentfile.ErrMissingInfoDict
+	ErrPieceLength        = torrentfile.ErrPieceLength
+	ErrTorrentTooLarge    = torrentfile.ErrTooLarge
+	ErrTorrentDownload    = torrentfile.ErrDownload
+	ErrInvalidTorrentFile = torrentfile.ErrInvalid
+)
+
+// TorrentFileError describes why a torrent file was rejected
+type TorrentFileError = torrentfile.Error
+
+// HTTPClient is the part of *http.Client used to download torrent files
+type HTTPClient = torrentfile.HTTPClient
+
+// SetHTTPClient replaces the client AddTorrentFromURL downloads with
+func (s *BTService) SetHTTPClient(client HTTPClient) {
+	s.httpClient = client
+}
+
+func (s *BTService) maxTorrentFileSize() int64 {
+	if s.config.MaxTorrentFileSize > 0 {
+		return s.config.MaxTorrentFileSize
+	}
+	return DefaultMaxTorrentFileSize
+}
+
+// AddTorrentFromBytes adds a torrent from the contents of a .torrent file
+func (s *BTService) AddTorrentFromBytes(data []byte, opts AddTorrentOptions) (*Torrent, error) {
+	if err := torrentfile.ValidateSize(data, s.maxTorrentFileSize()); err != nil {
+		return nil, err
+	}
+
+	savePath, err := s.resolveSavePath(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ti, err := lt.NewTorrentInfoFromBuffer(data)
+	if err != nil {
+		return nil, &TorrentFileError{Kind: ErrInvalidTorrentFile, Detail: err.Error()}
+	}
+
+	params := lt.NewAddTorrentParams()
+	params.SavePath = savePath
+	params.SetTorrentInfo(ti)
+	s.cacheTorrentInfo(ti)
+
+	return s.addTorrentParams(params, opts.metadata())
+}
+
+// AddTorrentFromURL downloads a .torrent file and adds it. Magnet links
+// are added directly. The download stops at the size limit.
+func (s *BTService) AddTorrentFromURL(ctx context.Context, rawURL string, opts AddTorrentOptions) (*Torrent, error) {
+	if isMagnet(rawURL) {
+		return s.AddTorrentWithOptions(rawURL, opts)
+	}
+
+	data, err := torrentfile.Download(ctx, s.httpClient, rawURL, s.maxTorrentFileSize())
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := s.AddTorrentFromBytes(data, opts)
+	var tfe *TorrentFileError
+	if errors.As(err, &tfe) {
+		tfe.Source = rawURL
+	}
+	return t, err
+}
+
+// ValidateTorrentFile checks that data is a bencoded dictionary with an
+// info dictionary whose piece length is in range
+func ValidateTorrentFile(data []byte) error {
+	return torrentfile.Validate(data)
+}
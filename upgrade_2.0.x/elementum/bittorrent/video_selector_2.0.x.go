@@ -0,0 +1,310 @@
+// video_selector_2.0.x.go - Picking the file to stream from a torrent
+//
+// Ranks the video files of a torrent by size and, when an episode is
+// requested, by how well their names match it. Samples, trailers and
+// extras are never chosen. The result carries a confidence between 0 and
+// 1, so callers can ask the user when the pick is uncertain.
+//
+// Episode numbers are read from the file name (S01E02, 1x02, "Season 1
+// Episode 2", "Episode 2", "Show - 02", "02 - Title") with the season
+// taken from the directory ("Season 1", "S01") when the name has none.
+
+package bittorrent
+
+import (
+	"errors"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrNoVideoFile is returned when a torrent has no file worth streaming
+var ErrNoVideoFile = errors.New("no matching video file")
+
+var videoExtensions = map[string]bool{
+	".mkv":  true,
+	".mp4":  true,
+	".m4v":  true,
+	".avi":  true,
+	".mov":  true,
+	".wmv":  true,
+	".webm": true,
+	".ts":   true,
+	".m2ts": true,
+	".mpg":  true,
+	".mpeg": true,
+	".flv":  true,
+	".vob":  true,
+}
+
+var (
+	// Samples, trailers and bonus material, as a word in a path component
+	reExcluded = regexp.MustCompile(`(?i)(?:^|[\W_])(sample|trailers?|extras?|featurettes?|bonus|behind[\W_]the[\W_]scenes|deleted[\W_]scenes|making[\W_]of|interviews?)(?:[\W_]|$)`)
+
+	// S01E02, S01.E02, S01E02E03, S01E02-03, S01E02-E03
+	reSeasonEpisode = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])s(\d{1,2})[ ._-]?e(\d{1,3})(?:(?:[ ._]?e|-e?)(\d{1,3}))?(?:\D|$)`)
+	// 1x02, not part of a resolution such as 1920x1080
+	reCrossEpisode = regexp.MustCompile(`(?i)(?:^|[^\dx])(\d{1,2})x(\d{2,3})(?:\D|$)`)
+	// Season 1 Episode 2
+	reSeasonEpisodeWords = regexp.MustCompile(`(?i)season[ ._-]?(\d{1,2})[ ._-]*episode[ ._-]?(\d{1,3})(?:\D|$)`)
+	// Episode 2, Ep02, E02 without a season
+	reEpisodeWord = regexp.MustCompile(`(?i)(?:^|[ ._\-\[])(?:episode|ep|e)[ ._-]?(\d{1,4})(?:v\d)?(?:\D|$)`)
+	// "Show - 02" as used by anime releases
+	reDashNumber = regexp.MustCompile(`(?:^|\s)-\s(\d{1,4})(?:v\d)?(?:[\s.\[(]|$)`)
+	// "02 - Title" inside a season folder
+	reLeadingNumber = regexp.MustCompile(`^(\d{1,3})(?:[ ._-]|$)`)
+	// Season folder: "Season 1", "Series 2", "S01", "Show S01 1080p"
+	reSeasonDir = regexp.MustCompile(`(?i)(?:^|[ ._-])(?:season|series|s)[ ._-]?(\d{1,2})(?:[ ._-]|$)`)
+)
+
+// EpisodeQuery identifies the episode to stream. Season 0 with an
+// Episode, or Absolute, match absolute numbering used by anime.
+type EpisodeQuery struct {
+	Season   int
+	Episode  int
+	Absolute int
+}
+
+// VideoChoice is a ranked candidate file
+type VideoChoice struct {
+	File TorrentFile
+	// Score orders the candidates; only relative values matter
+	Score float64
+	// Confidence in [0, 1] that this is the right file
+	Confidence float64
+	// Match describes how the file matched the query
+	Match string
+}
+
+// Episode match strength, strongest last
+const (
+	matchNone    = iota // query given but the name has no episode number
+	matchEpisode        // episode or absolute number matches, season unknown
+	matchExact          // season and episode match
+)
+
+var matchNames = []string{"size", "episode", "season and episode"}
+
+// episodeInfo is what could be read from a file path
+type episodeInfo struct {
+	season       int // -1 if unknown
+	first, last  int // episode range; 0 if unknown
+	fromSeasonEp bool
+}
+
+// IsVideo reports whether the file has a video extension
+func (f TorrentFile) IsVideo() bool {
+	return videoExtensions[strings.ToLower(path.Ext(f.Path))]
+}
+
+// isExcludedVideo reports whether a path names a sample, trailer or extra.
+// A single-file torrent is never excluded. Words that appear in the torrent
+// name, the first path component, are part of the title, so a show called
+// "Extras" still works.
+func isExcludedVideo(p string) bool {
+	parts := strings.Split(strings.ToLower(p), "/")
+	if len(parts) < 2 {
+		return false
+	}
+
+	title := make(map[string]bool)
+	for _, m := range reExcluded.FindAllStringSubmatch(parts[0], -1) {
+		title[m[1]] = true
+	}
+	for _, part := range parts[1:] {
+		for _, m := range reExcluded.FindAllStringSubmatch(part, -1) {
+			if !title[m[1]] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseEpisode reads season and episode numbers from a file path
+func parseEpisode(p string) episodeInfo {
+	name := strings.TrimSuffix(path.Base(p), path.Ext(p))
+	info := episodeInfo{season: -1}
+
+	atoi := func(s string) int {
+		n, _ := strconv.Atoi(s)
+		return n
+	}
+
+	switch {
+	case reSeasonEpisode.MatchString(name):
+		m := reSeasonEpisode.FindStringSubmatch(name)
+		info.season, info.first, info.last = atoi(m[1]), atoi(m[2]), atoi(m[2])
+		if m[3] != "" && atoi(m[3]) > info.first {
+			info.last = atoi(m[3])
+		}
+		info.fromSeasonEp = true
+		return info
+	case reSeasonEpisodeWords.MatchString(name):
+		m := reSeasonEpisodeWords.FindStringSubmatch(name)
+		info.season, info.first = atoi(m[1]), atoi(m[2])
+	case reCrossEpisode.MatchString(name):
+		m := reCrossEpisode.FindStringSubmatch(name)
+		info.season, info.first = atoi(m[1]), atoi(m[2])
+	case reEpisodeWord.MatchString(name):
+		info.first = atoi(reEpisodeWord.FindStringSubmatch(name)[1])
+	case reDashNumber.MatchString(name):
+		info.first = atoi(reDashNumber.FindStringSubmatch(name)[1])
+	case reLeadingNumber.MatchString(name):
+		info.first = atoi(reLeadingNumber.FindStringSubmatch(name)[1])
+	default:
+		return info
+	}
+	info.last = info.first
+	info.fromSeasonEp = info.season >= 0
+
+	// Season from the nearest directory that names one
+	if info.season < 0 {
+		dirs := strings.Split(path.Dir(p), "/")
+		for i := len(dirs) - 1; i >= 0; i-- {
+			if m := reSeasonDir.FindStringSubmatch(dirs[i]); m != nil {
+				info.season = atoi(m[1])
+				break
+			}
+		}
+	}
+	return info
+}
+
+// match rates how a file's episode info matches the query.
+// ok is false when the file clearly is another episode.
+func (q *EpisodeQuery) match(info episodeInfo) (strength int, ok bool) {
+	if info.first == 0 {
+		return matchNone, true
+	}
+	inRange := func(n int) bool { return n > 0 && n >= info.first && n <= info.last }
+
+	if q.Season > 0 && info.season >= 0 {
+		if info.season == q.Season && inRange(q.Episode) {
+			return matchExact, true
+		}
+		// Season packs with absolute numbering inside a season folder
+		if !info.fromSeasonEp && inRange(q.Absolute) {
+			return matchEpisode, true
+		}
+		return matchNone, false
+	}
+
+	if inRange(q.Absolute) || (q.Season <= 0 && inRange(q.Episode)) {
+		return matchEpisode, true
+	}
+	// Season unknown: the episode number alone is a weaker match
+	if info.season < 0 && inRange(q.Episode) {
+		return matchEpisode, true
+	}
+	return matchNone, false
+}
+
+// RankVideoFiles returns the candidate video files, best first. Samples,
+// trailers, extras and files of other episodes are left out. A nil query
+// ranks by size, as for a movie.
+func RankVideoFiles(files []TorrentFile, query *EpisodeQuery) []VideoChoice {
+	var maxSize int64
+	for _, f := range files {
+		if f.IsVideo() && f.Size > maxSize {
+			maxSize = f.Size
+		}
+	}
+
+	var choices []VideoChoice
+	for _, f := range files {
+		if !f.IsVideo() || f.Size <= 0 || isExcludedVideo(f.Path) {
+			continue
+		}
+
+		sizeScore := float64(f.Size) / float64(maxSize)
+		choice := VideoChoice{File: f, Score: sizeScore, Match: matchNames[matchNone]}
+		if query != nil {
+			strength, ok := query.match(parseEpisode(f.Path))
+			if !ok {
+				continue
+			}
+			// A name match always beats size
+			choice.Score += float64(strength) * 2
+			choice.Match = matchNames[strength]
+		}
+		choices = append(choices, choice)
+	}
+
+	sort.SliceStable(choices, func(i, j int) bool {
+		return choices[i].Score > choices[j].Score
+	})
+	return choices
+}
+
+// SelectVideoFile returns the file to stream and the confidence in it.
+// ok is false if no file qualifies.
+func SelectVideoFile(files []TorrentFile, query *EpisodeQuery) (choice VideoChoice, ok bool) {
+	choices := RankVideoFiles(files, query)
+	if len(choices) == 0 {
+		return VideoChoice{}, false
+	}
+
+	best := choices[0]
+	best.Confidence = selectionConfidence(choices, query)
+	return best, true
+}
+
+// selectionConfidence scores how clearly the first choice wins
+func selectionConfidence(choices []VideoChoice, query *EpisodeQuery) float64 {
+	best := choices[0]
+
+	// How much bigger the pick is than the runner-up: 0.5 for a tie,
+	// approaching 1 when the others are small
+	sizeLead := 1.0
+	if len(choices) > 1 && best.File.Size > 0 {
+		ratio := float64(choices[1].File.Size) / float64(best.File.Size)
+		if ratio > 1 {
+			ratio = 1
+		}
+		sizeLead = 1 - ratio/2
+	}
+
+	if query == nil {
+		return sizeLead
+	}
+
+	// Several files with the same match strength, e.g. two qualities of
+	// the same episode
+	tied := len(choices) > 1 && choices[1].Match == best.Match
+
+	switch best.Match {
+	case matchNames[matchExact]:
+		if tied {
+			return 0.9
+		}
+		return 1
+	case matchNames[matchEpisode]:
+		if tied {
+			return 0.7
+		}
+		return 0.85
+	default:
+		// No file names an episode: only size to go on
+		if len(choices) == 1 {
+			return 0.5
+		}
+		return sizeLead * 0.5
+	}
+}
+
+// SelectVideoFile picks the file to stream. A nil query picks the main
+// video, as for a movie.
+func (t *Torrent) SelectVideoFile(query *EpisodeQuery) (VideoChoice, error) {
+	files, err := t.Files()
+	if err != nil {
+		return VideoChoice{}, err
+	}
+	choice, ok := SelectVideoFile(files, query)
+	if !ok {
+		return VideoChoice{}, ErrNoVideoFile
+	}
+	return choice, nil
+}
@@ -0,0 +1,104 @@
+package bittorrent
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+// selectorCase is one torrent file list from testdata/video_selector_corpus.json
+type selectorCase struct {
+	Name  string `json:"name"`
+	Files []struct {
+		Path string `json:"path"`
+		Size int64  `json:"size"`
+	} `json:"files"`
+	Query *struct {
+		Season   int `json:"season"`
+		Episode  int `json:"episode"`
+		Absolute int `json:"absolute"`
+	} `json:"query"`
+	// Expect is the chosen path, or "" when no file should be chosen
+	Expect        string   `json:"expect"`
+	MinConfidence float64  `json:"min_confidence"`
+	MaxConfidence *float64 `json:"max_confidence"`
+}
+
+// TestSelectVideoFileCorpus checks the selector against real-world file lists
+func TestSelectVideoFileCorpus(t *testing.T) {
+	data, err := os.ReadFile("testdata/video_selector_corpus.json")
+	if err != nil {
+		t.Fatalf("Failed to read corpus: %v", err)
+	}
+	var cases []selectorCase
+	if err := json.Unmarshal(data, &cases); err != nil {
+		t.Fatalf("Failed to parse corpus: %v", err)
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			files := make([]TorrentFile, len(tc.Files))
+			var offset int64
+			for i, f := range tc.Files {
+				files[i] = TorrentFile{Index: i, Path: f.Path, Size: f.Size, Offset: offset}
+				offset += f.Size
+			}
+
+			var query *EpisodeQuery
+			if tc.Query != nil {
+				query = &EpisodeQuery{Season: tc.Query.Season, Episode: tc.Query.Episode, Absolute: tc.Query.Absolute}
+			}
+
+			choice, ok := SelectVideoFile(files, query)
+			if tc.Expect == "" {
+				if ok {
+					t.Fatalf("Expected no choice, got %s (confidence %.2f)", choice.File.Path, choice.Confidence)
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("Expected %s, got no choice", tc.Expect)
+			}
+			if choice.File.Path != tc.Expect {
+				t.Fatalf("Expected %s, got %s (%s)", tc.Expect, choice.File.Path, choice.Match)
+			}
+			if choice.Confidence < tc.MinConfidence {
+				t.Errorf("Confidence %.2f below %.2f", choice.Confidence, tc.MinConfidence)
+			}
+			if tc.MaxConfidence != nil && choice.Confidence > *tc.MaxConfidence {
+				t.Errorf("Confidence %.2f above %.2f", choice.Confidence, *tc.MaxConfidence)
+			}
+		})
+	}
+}
+
+// TestParseEpisode checks the episode patterns individually
+func TestParseEpisode(t *testing.T) {
+	tests := []struct {
+		path        string
+		season      int
+		first, last int
+	}{
+		{"Show/Show.S01E02.mkv", 1, 2, 2},
+		{"Show/show.s10e100.mkv", 10, 100, 100},
+		{"Show/Show.S02E03-04.mkv", 2, 3, 4},
+		{"Show/Show.S02E03E04.mkv", 2, 3, 4},
+		{"Show/Show.S02E03.1080p.mkv", 2, 3, 3},
+		{"Show/Show 3x07.avi", 3, 7, 7},
+		{"Show/Show.1920x1080.mkv", -1, 0, 0},
+		{"Show/Show Season 2 Episode 5.mp4", 2, 5, 5},
+		{"Show/Season 4/Show Episode 9.mp4", 4, 9, 9},
+		{"Anime/[Group] Anime - 1024 [1080p].mkv", -1, 1024, 1024},
+		{"Anime/[Group] Anime - 07v2 [720p].mkv", -1, 7, 7},
+		{"Show/S03/05 - Title.mkv", 3, 5, 5},
+		{"Movie.2019.1080p.x264.mkv", -1, 0, 0},
+	}
+
+	for _, tt := range tests {
+		info := parseEpisode(tt.path)
+		if info.season != tt.season || info.first != tt.first || info.last != tt.last {
+			t.Errorf("%s: got season %d episodes %d-%d, want %d %d-%d",
+				tt.path, info.season, info.first, info.last, tt.season, tt.first, tt.last)
+		}
+	}
+}
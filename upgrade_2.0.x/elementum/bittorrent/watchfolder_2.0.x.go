@@ -0,0 +1,49 @@
+// watchfolder_2.0.x.go - Watch-folder ingestion for the service
+//
+// The folder itself lives in the watchfolder package; files it finds are
+// added with the folder's category and labels.
+
+package bittorrent
+
+import (
+	"github.com/elgatito/elementum/watchfolder"
+)
+
+// WatchFolderConfig configures watch-folder ingestion
+type WatchFolderConfig = watchfolder.Config
+
+// WatchFolder adds torrents dropped into a directory
+type WatchFolder = watchfolder.Folder
+
+// NewWatchFolder creates a watch folder adding torrents to the service.
+// The done and failed subfolders are created if needed.
+func NewWatchFolder(service *BTService, config WatchFolderConfig) (*WatchFolder, error) {
+	opts := AddTorrentOptions{
+		Category: config.Category,
+		Labels:   config.Labels,
+	}
+	return watchfolder.New(config, func(uri string) error {
+		_, err := service.AddTorrentWithOptions(uri, opts)
+		return err
+	})
+}
+
+// SetWatchFolder stores a watch-folder config and restarts ingestion
+func (s *BTService) SetWatchFolder(config *WatchFolderConfig) error {
+	if s.watchFolder != nil {
+		s.watchFolder.Stop()
+		s.watchFolder = nil
+	}
+	s.config.WatchFolder = config
+
+	if config == nil || !config.Enabled {
+		return nil
+	}
+	w, err := NewWatchFolder(s, *config)
+	if err != nil {
+		return err
+	}
+	s.watchFolder = w
+	w.Start()
+	return nil
+}
@@ -0,0 +1,281 @@
+// metadata_cache_2.0.x.go - On-disk cache of torrent metadata
+//
+// Stores the bencoded info dictionary of every torrent whose metadata was
+// received, so a magnet that was resolved before starts immediately instead
+// of fetching the metadata from the swarm again. Each entry is a file
+// named <sha1>.info holding the raw info dictionary; the v1 (SHA-1) and v2
+// (SHA-256) info hashes are computed from its contents, so one file serves
+// lookups by either hash and a corrupted file never matches.
+//
+// The least recently used entries are evicted once the cache grows past
+// its size limit.
+//
+// The package does not depend on libtorrent, so it builds and tests
+// without the SWIG bindings.
+
+package metadatacache
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("metadatacache")
+
+// DefaultSize limits the cache when no size is given
+const DefaultSize = 64 * 1024 * 1024
+
+const fileExt = ".info"
+
+// Cache is a size-limited on-disk cache of info dictionaries
+type Cache struct {
+	dir     string
+	maxSize int64
+
+	mu      sync.Mutex
+	entries map[string]*entry // v1 and v2 hex hash -> entry
+	size    int64
+}
+
+type entry struct {
+	path   string
+	v1, v2 string
+	size   int64
+	usedAt time.Time
+}
+
+// New opens the cache in dir, creating it if needed, and indexes the
+// entries already there. maxSize <= 0 uses DefaultSize.
+func New(dir string, maxSize int64) (*Cache, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultSize
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create metadata cache: %w", err)
+	}
+
+	c := &Cache{
+		dir:     dir,
+		maxSize: maxSize,
+		entries: make(map[string]*entry),
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read metadata cache: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != fileExt {
+			continue
+		}
+		path := filepath.Join(dir, file.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+
+		entry := newEntry(path, data)
+		if file.Name() != entry.v1+fileExt {
+			// Truncated or foreign file
+			log.Warningf("Metadata cache: removing invalid entry %s", file.Name())
+			os.Remove(path)
+			continue
+		}
+		entry.usedAt = info.ModTime()
+		c.addLocked(entry)
+	}
+	c.evictLocked()
+	return c, nil
+}
+
+func newEntry(path string, info []byte) *entry {
+	v1 := sha1.Sum(info)
+	entry := &entry{
+		path:   path,
+		v1:     hex.EncodeToString(v1[:]),
+		size:   int64(len(info)),
+		usedAt: time.Now(),
+	}
+	// Only BitTorrent v2 info dictionaries have a v2 hash
+	if bytes.Contains(info, []byte("12:meta versioni2e")) {
+		v2 := sha256.Sum256(info)
+		entry.v2 = hex.EncodeToString(v2[:])
+	}
+	return entry
+}
+
+func (c *Cache) addLocked(entry *entry) {
+	if old, ok := c.entries[entry.v1]; ok {
+		c.size -= old.size
+	}
+	c.entries[entry.v1] = entry
+	if entry.v2 != "" {
+		c.entries[entry.v2] = entry
+	}
+	c.size += entry.size
+}
+
+func (c *Cache) removeLocked(entry *entry) {
+	delete(c.entries, entry.v1)
+	if entry.v2 != "" {
+		delete(c.entries, entry.v2)
+	}
+	c.size -= entry.size
+	if err := os.Remove(entry.path); err != nil && !os.IsNotExist(err) {
+		log.Warningf("Metadata cache: cannot remove %s: %s", entry.path, err)
+	}
+}
+
+// evictLocked removes least recently used entries until the cache fits
+func (c *Cache) evictLocked() {
+	if c.size <= c.maxSize {
+		return
+	}
+
+	unique := make([]*entry, 0, len(c.entries))
+	for hash, entry := range c.entries {
+		if hash == entry.v1 {
+			unique = append(unique, entry)
+		}
+	}
+	sort.Slice(unique, func(i, j int) bool {
+		return unique[i].usedAt.Before(unique[j].usedAt)
+	})
+
+	for _, entry := range unique {
+		if c.size <= c.maxSize {
+			break
+		}
+		log.Infof("Metadata cache: evicting %s", entry.v1)
+		c.removeLocked(entry)
+	}
+}
+
+// Put stores a bencoded info dictionary
+func (c *Cache) Put(info []byte) error {
+	if len(info) == 0 {
+		return fmt.Errorf("empty info dictionary")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := newEntry("", info)
+	entry.path = filepath.Join(c.dir, entry.v1+fileExt)
+	if _, ok := c.entries[entry.v1]; ok {
+		c.touchLocked(c.entries[entry.v1])
+		return nil
+	}
+	if entry.size > c.maxSize {
+		return fmt.Errorf("info dictionary of %d bytes exceeds cache size %d", entry.size, c.maxSize)
+	}
+
+	// Write then rename, so readers never see a partial entry
+	tmp := entry.path + ".tmp"
+	if err := os.WriteFile(tmp, info, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, entry.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	c.addLocked(entry)
+	c.evictLocked()
+	return nil
+}
+
+// Get returns the info dictionary for a v1 or v2 hex info hash
+func (c *Cache) Get(infoHash string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[strings.ToLower(infoHash)]
+	if !ok {
+		return nil, false
+	}
+	data, err := os.ReadFile(entry.path)
+	if err != nil {
+		c.removeLocked(entry)
+		return nil, false
+	}
+	c.touchLocked(entry)
+	return data, true
+}
+
+// Has reports whether the cache holds metadata for an info hash
+func (c *Cache) Has(infoHash string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.entries[strings.ToLower(infoHash)]
+	return ok
+}
+
+// Remove drops the entry for an info hash
+func (c *Cache) Remove(infoHash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[strings.ToLower(infoHash)]; ok {
+		c.removeLocked(entry)
+	}
+}
+
+// Size returns the total size of the cached info dictionaries
+func (c *Cache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// touchLocked marks an entry as used; the mtime keeps the order across
+// restarts
+func (c *Cache) touchLocked(entry *entry) {
+	entry.usedAt = time.Now()
+	os.Chtimes(entry.path, entry.usedAt, entry.usedAt)
+}
+
+// ExportTorrent returns a .torrent file built from the cached metadata.
+// It has no trackers and, for v2 torrents, no piece layers; like after a
+// magnet, libtorrent requests the missing hashes from peers.
+func (c *Cache) ExportTorrent(infoHash string) ([]byte, error) {
+	info, ok := c.Get(infoHash)
+	if !ok {
+		return nil, fmt.Errorf("no cached metadata for %s", infoHash)
+	}
+	return TorrentFile(info), nil
+}
+
+// WriteTorrentFile writes the exported .torrent file to path
+func (c *Cache) WriteTorrentFile(infoHash, path string) error {
+	data, err := c.ExportTorrent(infoHash)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// TorrentFile wraps an info dictionary in a minimal .torrent
+func TorrentFile(info []byte) []byte {
+	buf := make([]byte, 0, len(info)+8)
+	buf = append(buf, "d4:info"...)
+	buf = append(buf, info...)
+	return append(buf, 'e')
+}
@@ -0,0 +1,252 @@
+package metadatacache
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elgatito/elementum/torrentfile"
+)
+
+// testInfo returns an info dictionary named name, all of the same size
+func testInfo(name string, v2 bool) []byte {
+	if v2 {
+		return []byte(fmt.Sprintf("d12:meta versioni2e4:name%d:%s12:piece lengthi16384ee", len(name), name))
+	}
+	return []byte(fmt.Sprintf("d4:name%d:%s12:piece lengthi16384ee", len(name), name))
+}
+
+func testHashV1(info []byte) string {
+	h := sha1.Sum(info)
+	return hex.EncodeToString(h[:])
+}
+
+func testHashV2(info []byte) string {
+	h := sha256.Sum256(info)
+	return hex.EncodeToString(h[:])
+}
+
+func TestEviction(t *testing.T) {
+	entrySize := int64(len(testInfo("a", false)))
+
+	tests := []struct {
+		name    string
+		entries int64 // cache size in entries
+		ops     []string
+		want    []string
+	}{
+		{"fits", 3, []string{"put a", "put b", "put c"}, []string{"a", "b", "c"}},
+		{"evicts least recently put", 3, []string{"put a", "put b", "put c", "put d"}, []string{"b", "c", "d"}},
+		{"get refreshes", 3, []string{"put a", "put b", "put c", "get a", "put d"}, []string{"a", "c", "d"}},
+		{"put again refreshes", 3, []string{"put a", "put b", "put c", "put a", "put d"}, []string{"a", "c", "d"}},
+		{"get of evicted entry misses", 2, []string{"put a", "put b", "put c", "get a", "put d"}, []string{"c", "d"}},
+		{"room for one", 1, []string{"put a", "put b"}, []string{"b"}},
+	}
+
+	for _, tt := range tests {
+		c, err := New(t.TempDir(), tt.entries*entrySize)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Space the uses a second apart so the order never depends on the
+		// clock resolution
+		base := time.Now().Add(-time.Hour)
+		for i, op := range tt.ops {
+			action, name := op[:3], op[4:]
+			info := testInfo(name, false)
+			switch action {
+			case "put":
+				if err := c.Put(info); err != nil {
+					t.Errorf("%s: %s: %s", tt.name, op, err)
+				}
+			case "get":
+				c.Get(testHashV1(info))
+			}
+			if entry, ok := c.entries[testHashV1(info)]; ok {
+				entry.usedAt = base.Add(time.Duration(i) * time.Second)
+			}
+		}
+
+		var got []string
+		for _, name := range []string{"a", "b", "c", "d"} {
+			if c.Has(testHashV1(testInfo(name, false))) {
+				got = append(got, name)
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: cache holds %v, want %v", tt.name, got, tt.want)
+		}
+		if size := c.Size(); size != int64(len(tt.want))*entrySize {
+			t.Errorf("%s: size %d, want %d", tt.name, size, int64(len(tt.want))*entrySize)
+		}
+
+		files, _ := filepath.Glob(filepath.Join(c.dir, "*"+fileExt))
+		if len(files) != len(tt.want) {
+			t.Errorf("%s: %d files on disk, want %d", tt.name, len(files), len(tt.want))
+		}
+	}
+}
+
+func TestTooLarge(t *testing.T) {
+	info := testInfo("a", false)
+	c, err := New(t.TempDir(), int64(len(info))-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Put(info); err == nil {
+		t.Error("Put of an entry larger than the cache succeeded")
+	}
+	if err := c.Put(nil); err == nil {
+		t.Error("Put of an empty info dictionary succeeded")
+	}
+	if c.Size() != 0 {
+		t.Errorf("Size %d, want 0", c.Size())
+	}
+}
+
+func TestIndex(t *testing.T) {
+	v1Info := testInfo("v1", false)
+	v2Info := testInfo("v2", true)
+
+	c, err := New(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, info := range [][]byte{v1Info, v2Info} {
+		if err := c.Put(info); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		hash string
+		want []byte // nil for a miss
+	}{
+		{"v1 torrent by v1 hash", testHashV1(v1Info), v1Info},
+		{"v1 hash upper case", strings.ToUpper(testHashV1(v1Info)), v1Info},
+		{"v1 torrent has no v2 hash", testHashV2(v1Info), nil},
+		{"v2 torrent by v1 hash", testHashV1(v2Info), v2Info},
+		{"v2 torrent by v2 hash", testHashV2(v2Info), v2Info},
+		{"v2 hash upper case", strings.ToUpper(testHashV2(v2Info)), v2Info},
+		{"unknown hash", testHashV1([]byte("unknown")), nil},
+	}
+
+	for _, tt := range tests {
+		data, ok := c.Get(tt.hash)
+		if ok != (tt.want != nil) || string(data) != string(tt.want) {
+			t.Errorf("%s: Get = %q, %v, want %q", tt.name, data, ok, tt.want)
+		}
+		if has := c.Has(tt.hash); has != (tt.want != nil) {
+			t.Errorf("%s: Has = %v", tt.name, has)
+		}
+	}
+
+	// Both hashes count the entry once
+	if want := int64(len(v1Info) + len(v2Info)); c.Size() != want {
+		t.Errorf("Size %d, want %d", c.Size(), want)
+	}
+
+	// Removing by the v2 hash drops the v1 key too
+	c.Remove(testHashV2(v2Info))
+	if c.Has(testHashV1(v2Info)) || c.Has(testHashV2(v2Info)) {
+		t.Error("v2 entry still indexed after Remove")
+	}
+	if c.Size() != int64(len(v1Info)) {
+		t.Errorf("Size after Remove %d, want %d", c.Size(), len(v1Info))
+	}
+}
+
+// TestMetadataCacheReopen rebuilds the index and the LRU order from disk
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+	names := []string{"a", "b", "c"}
+	infos := make([][]byte, len(names))
+
+	c, err := New(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := time.Now().Add(-time.Hour)
+	for i, name := range names {
+		infos[i] = testInfo(name, i == 2)
+		if err := c.Put(infos[i]); err != nil {
+			t.Fatal(err)
+		}
+		// "a" is the least recently used
+		used := base.Add(time.Duration(i) * time.Second)
+		if err := os.Chtimes(c.entries[testHashV1(infos[i])].path, used, used); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	invalid := filepath.Join(dir, strings.Repeat("0", 40)+fileExt)
+	if err := os.WriteFile(invalid, []byte("truncated"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	entrySize := int64(len(infos[0]))
+	c, err = New(dir, 2*int64(len(infos[2])))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(invalid); !os.IsNotExist(err) {
+		t.Error("Invalid entry not removed")
+	}
+	if c.Has(testHashV1(infos[0])) {
+		t.Error("Least recently used entry not evicted on open")
+	}
+	for _, hash := range []string{testHashV1(infos[1]), testHashV1(infos[2]), testHashV2(infos[2])} {
+		if !c.Has(hash) {
+			t.Errorf("Entry %s missing after reopen", hash)
+		}
+	}
+	if want := entrySize + int64(len(infos[2])); c.Size() != want {
+		t.Errorf("Size %d, want %d", c.Size(), want)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	sort.Strings(files)
+	want := []string{
+		filepath.Join(dir, testHashV1(infos[1])+fileExt),
+		filepath.Join(dir, testHashV1(infos[2])+fileExt),
+	}
+	sort.Strings(want)
+	if strings.Join(files, ",") != strings.Join(want, ",") {
+		t.Errorf("Files %v, want %v", files, want)
+	}
+}
+
+func TestExportTorrent(t *testing.T) {
+	info := testInfo("a", false)
+	c, err := New(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Put(info); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := c.ExportTorrent(testHashV1(info))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "d4:info" + string(info) + "e"; string(data) != want {
+		t.Errorf("ExportTorrent = %q, want %q", data, want)
+	}
+	if err := torrentfile.Validate(data); err != nil {
+		t.Errorf("Exported torrent is invalid: %s", err)
+	}
+	if _, err := c.ExportTorrent(testHashV1([]byte("unknown"))); err == nil {
+		t.Error("ExportTorrent of an unknown hash succeeded")
+	}
+}
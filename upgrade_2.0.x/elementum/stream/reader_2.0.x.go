@@ -0,0 +1,205 @@
+// reader_2.0.x.go - Blocking reader of a streamed torrent file
+//
+// A Reader keeps its own position and read-ahead window. The window is
+// moved on the first read after a seek, not on the seek itself, so probing
+// seeks (e.g. to find the file size) download nothing. Pieces in the
+// window get deadlines and are protected from eviction through the
+// torrent's reader ranges.
+
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Reader reads the file of a Stream. It implements io.ReadSeekCloser and
+// is not safe for concurrent use; open one reader per consumer.
+type Reader struct {
+	stream *Stream
+	ctx    context.Context
+	pos    int64
+	data   io.ReaderAt  // the torrent, or own
+	own    ReadAtCloser // data reader from a DataReaderOpener, or nil
+
+	// Guarded by stream.mu
+	readerID    int          // torrent reader id, 0 until the first read
+	window      map[int]bool // read-ahead pieces holding a deadline
+	windowPiece int          // piece the window starts at, -1 for none
+	detached    bool
+}
+
+// NewReader opens a reader at the start of the file. Pending reads fail
+// when ctx is done; ctx may be nil.
+func (s *Stream) NewReader(ctx context.Context) *Reader {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	r := &Reader{
+		stream:      s,
+		ctx:         ctx,
+		data:        s.torrent,
+		window:      make(map[int]bool),
+		windowPiece: -1,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.closed:
+		r.detached = true
+	default:
+		s.readers[r] = true
+		if o, ok := s.torrent.(DataReaderOpener); ok {
+			r.own = o.NewDataReader()
+			r.data = r.own
+		}
+	}
+	return r
+}
+
+// Read reads from the current position, waiting for pieces to download
+func (r *Reader) Read(p []byte) (int, error) {
+	s := r.stream
+	if r.pos >= s.file.Size {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	off := s.file.Offset + r.pos
+	piece := int(off / s.pieceLength)
+	if err := r.updateWindow(piece); err != nil {
+		return 0, err
+	}
+	if err := s.waitPiece(r.ctx, piece); err != nil {
+		return 0, err
+	}
+
+	// Stay within the piece and the file
+	size := int64(piece+1)*s.pieceLength - off
+	if remaining := s.file.Size - r.pos; size > remaining {
+		size = remaining
+	}
+	if int64(len(p)) > size {
+		p = p[:size]
+	}
+
+	n, err := r.data.ReadAt(p, off)
+	r.pos += int64(n)
+	if n > 0 {
+		return n, nil
+	}
+	if err == nil {
+		err = io.ErrNoProgress
+	}
+	return 0, err
+}
+
+// Seek sets the position for the next Read
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = r.pos + offset
+	case io.SeekEnd:
+		pos = r.stream.file.Size + offset
+	default:
+		return r.pos, fmt.Errorf("invalid whence %d", whence)
+	}
+	if pos < 0 {
+		return r.pos, errors.New("negative position")
+	}
+	r.pos = pos
+	return pos, nil
+}
+
+// Position returns the offset of the next Read
+func (r *Reader) Position() int64 {
+	return r.pos
+}
+
+// Close releases the reader's deadlines, reader range and data reader
+func (r *Reader) Close() error {
+	s := r.stream
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r.detachLocked()
+	delete(s.readers, r)
+	return nil
+}
+
+// updateWindow moves the read-ahead window to start at piece
+func (r *Reader) updateWindow(piece int) error {
+	s := r.stream
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.detached {
+		return ErrClosed
+	}
+	if piece == r.windowPiece {
+		return nil
+	}
+	r.windowPiece = piece
+
+	length := s.config.ReadAhead
+	if remaining := s.file.Size - r.pos; length > remaining {
+		length = remaining
+	}
+	first, last := s.pieceRange(r.pos, length)
+
+	wanted := make(map[int]bool, last-first+1)
+	for p := first; p <= last; p++ {
+		if r.window[p] {
+			wanted[p] = true
+			continue
+		}
+		if s.torrent.HavePiece(p) {
+			continue
+		}
+		wanted[p] = true
+		s.acquireDeadlineLocked(p, (p-first)*s.config.DeadlineStep)
+	}
+	// Pieces left behind by a seek no longer need to be rushed
+	for p := range r.window {
+		if !wanted[p] {
+			s.releaseDeadlineLocked(p)
+		}
+	}
+	r.window = wanted
+
+	if r.readerID == 0 {
+		r.readerID = s.torrent.AddReader(first, last)
+	} else {
+		s.torrent.UpdateReader(r.readerID, first, last)
+	}
+	return nil
+}
+
+// detachLocked releases the reader's hold on the torrent. Reads fail with
+// ErrClosed afterwards.
+func (r *Reader) detachLocked() {
+	if r.detached {
+		return
+	}
+	r.detached = true
+
+	s := r.stream
+	for p := range r.window {
+		s.releaseDeadlineLocked(p)
+	}
+	r.window = nil
+	if r.readerID != 0 {
+		s.torrent.RemoveReader(r.readerID)
+	}
+	if r.own != nil {
+		r.own.Close()
+	}
+}
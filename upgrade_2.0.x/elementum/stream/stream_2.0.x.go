@@ -0,0 +1,326 @@
+// stream_2.0.x.go - HTTP streaming of torrent files for libtorrent 2.0.x
+//
+// A Stream serves one file of a torrent while it downloads. Each HTTP
+// request reads through its own Reader with its own read-ahead, so the
+// player can probe the end of the file or seek while playback continues.
+// Reads block until the pieces they need are verified, up to ReadTimeout;
+// a verified piece the torrent can no longer read fails the read at once.
+//
+// Range, If-Range and HEAD are handled by net/http's ServeContent; the
+// ETag identifies the torrent and file so If-Range works across requests.
+
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stream errors
+var (
+	ErrReadTimeout = errors.New("timed out waiting for piece")
+	ErrClosed      = errors.New("stream closed")
+	ErrNoMetadata  = errors.New("torrent has no metadata yet")
+)
+
+// Torrent is what a Stream needs from a torrent. *bittorrent.Torrent
+// implements it.
+type Torrent interface {
+	GetInfoHashV1() string
+	PieceLength() int64
+	// HavePiece reports whether a piece is verified
+	HavePiece(piece int) bool
+	// ReadAt reads torrent data without waiting for pieces. Errors for
+	// verified pieces are returned to the reader as they are.
+	ReadAt(p []byte, off int64) (int, error)
+	SetPieceDeadline(piece int, deadline int)
+	ResetPieceDeadline(piece int)
+	AddReader(firstPiece, lastPiece int) int
+	UpdateReader(readerID, firstPiece, lastPiece int)
+	RemoveReader(readerID int)
+}
+
+// PieceNotifier is implemented by torrents that report verified pieces, as
+// *bittorrent.Torrent does from the alert loop. A Stream of such a torrent
+// wakes its readers as soon as a piece finishes instead of polling.
+type PieceNotifier interface {
+	OnPieceFinished(fn func(piece int)) (remove func())
+}
+
+// ReadAtCloser is a reader of torrent data holding resources until Close.
+// It is an alias so torrents in other packages can return the same type.
+type ReadAtCloser = interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// DataReaderOpener is implemented by torrents that hand out data readers
+// of their own, as *bittorrent.Torrent does to keep files open with disk
+// storage. Each Reader of a Stream of such a torrent reads through its own
+// data reader and closes it with the Reader.
+type DataReaderOpener interface {
+	NewDataReader() ReadAtCloser
+}
+
+// File is the part of the torrent a Stream serves
+type File struct {
+	Path   string
+	Offset int64 // byte offset in the torrent's concatenated data
+	Size   int64
+}
+
+// Config holds stream configuration
+type Config struct {
+	ReadAhead    int64         // Bytes each reader downloads ahead of its position
+	ReadTimeout  time.Duration // How long a read waits for a piece
+	PollInterval time.Duration // Piece re-check interval between PieceFinished calls
+	DeadlineStep int           // Milliseconds between consecutive read-ahead deadlines
+}
+
+// DefaultConfig returns default configuration
+func DefaultConfig() *Config {
+	return &Config{
+		ReadAhead:    32 * 1024 * 1024,
+		ReadTimeout:  60 * time.Second,
+		PollInterval: 500 * time.Millisecond,
+		DeadlineStep: 100,
+	}
+}
+
+// Content types of common video files missing from the system table
+var videoContentTypes = map[string]string{
+	".mkv":  "video/x-matroska",
+	".mp4":  "video/mp4",
+	".m4v":  "video/mp4",
+	".avi":  "video/x-msvideo",
+	".mov":  "video/quicktime",
+	".webm": "video/webm",
+	".ts":   "video/mp2t",
+	".m2ts": "video/mp2t",
+	".srt":  "application/x-subrip",
+}
+
+// pieceDeadline is a piece some reader wants soon
+type pieceDeadline struct {
+	readers int
+	at      time.Time // when the earliest deadline expires
+}
+
+// Stream serves one torrent file to any number of readers
+type Stream struct {
+	torrent     Torrent
+	file        File
+	config      *Config
+	pieceLength int64
+	etag        string
+
+	mu        sync.Mutex
+	deadlines map[int]*pieceDeadline
+	readers   map[*Reader]bool
+	changed   chan struct{} // closed and replaced when a piece finishes
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	// Removes the PieceFinished listener, nil without a PieceNotifier
+	unsubscribe func()
+}
+
+// NewStream creates a stream of a torrent file
+func NewStream(t Torrent, file File, config *Config) (*Stream, error) {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	pieceLength := t.PieceLength()
+	if pieceLength <= 0 {
+		return nil, ErrNoMetadata
+	}
+	if file.Size < 0 || file.Offset < 0 {
+		return nil, fmt.Errorf("invalid file %s: offset %d, size %d", file.Path, file.Offset, file.Size)
+	}
+
+	s := &Stream{
+		torrent:     t,
+		file:        file,
+		config:      config,
+		pieceLength: pieceLength,
+		etag:        fmt.Sprintf(`"%s-%d-%d"`, t.GetInfoHashV1(), file.Offset, file.Size),
+		deadlines:   make(map[int]*pieceDeadline),
+		readers:     make(map[*Reader]bool),
+		changed:     make(chan struct{}),
+		closed:      make(chan struct{}),
+	}
+	if n, ok := t.(PieceNotifier); ok {
+		s.unsubscribe = n.OnPieceFinished(s.PieceFinished)
+	}
+	return s, nil
+}
+
+// File returns the streamed file
+func (s *Stream) File() File {
+	return s.file
+}
+
+// ETag returns the entity tag sent with every response
+func (s *Stream) ETag() string {
+	return s.etag
+}
+
+// Readers returns the number of open readers
+func (s *Stream) Readers() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.readers)
+}
+
+// PieceFinished wakes readers waiting for a piece. NewStream registers it
+// with torrents implementing PieceNotifier; otherwise call it on
+// piece_finished_alert, or readers notice new pieces every PollInterval.
+func (s *Stream) PieceFinished(piece int) {
+	first, last := s.pieceRange(0, s.file.Size)
+	if piece < first || piece > last {
+		return
+	}
+
+	s.mu.Lock()
+	close(s.changed)
+	s.changed = make(chan struct{})
+	s.mu.Unlock()
+}
+
+// Close fails pending and future reads and releases the deadlines and
+// reader ranges of every reader
+func (s *Stream) Close() {
+	s.closeOnce.Do(func() {
+		if s.unsubscribe != nil {
+			s.unsubscribe()
+		}
+		close(s.closed)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for r := range s.readers {
+			r.detachLocked()
+		}
+	})
+}
+
+// ServeHTTP serves the file with Range, If-Range and HEAD support. Every
+// request reads through its own Reader.
+func (s *Stream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-s.closed:
+		http.Error(w, ErrClosed.Error(), http.StatusGone)
+		return
+	default:
+	}
+
+	reader := s.NewReader(r.Context())
+	defer reader.Close()
+
+	h := w.Header()
+	h.Set("ETag", s.etag)
+	if h.Get("Content-Type") == "" {
+		h.Set("Content-Type", contentType(s.file.Path))
+	}
+	// A zero modification time leaves out Last-Modified, so If-Range only
+	// matches the ETag
+	http.ServeContent(w, r, path.Base(s.file.Path), time.Time{}, reader)
+}
+
+// contentType returns the Content-Type for a file name
+func contentType(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if t, ok := videoContentTypes[ext]; ok {
+		return t
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// pieceRange returns the pieces holding length bytes at offset of the file
+func (s *Stream) pieceRange(offset, length int64) (first, last int) {
+	start := s.file.Offset + offset
+	end := start + length
+	if end <= start {
+		end = start + 1
+	}
+	return int(start / s.pieceLength), int((end - 1) / s.pieceLength)
+}
+
+// waitPiece blocks until a piece is verified
+func (s *Stream) waitPiece(ctx context.Context, piece int) error {
+	if s.torrent.HavePiece(piece) {
+		return nil
+	}
+
+	timeout := time.NewTimer(s.config.ReadTimeout)
+	defer timeout.Stop()
+	poll := time.NewTicker(s.config.PollInterval)
+	defer poll.Stop()
+
+	for {
+		// Take the channel before checking, so a piece finishing in
+		// between still wakes us
+		s.mu.Lock()
+		changed := s.changed
+		s.mu.Unlock()
+
+		if s.torrent.HavePiece(piece) {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-poll.C:
+		case <-timeout.C:
+			return fmt.Errorf("%w %d after %s", ErrReadTimeout, piece, s.config.ReadTimeout)
+		case <-s.closed:
+			return ErrClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// acquireDeadlineLocked registers a reader's interest in a piece due in
+// deadline milliseconds. The earliest deadline among readers wins.
+func (s *Stream) acquireDeadlineLocked(piece, deadline int) {
+	at := time.Now().Add(time.Duration(deadline) * time.Millisecond)
+	d, ok := s.deadlines[piece]
+	if !ok {
+		d = &pieceDeadline{}
+		s.deadlines[piece] = d
+	}
+	d.readers++
+	if !ok || at.Before(d.at) {
+		d.at = at
+		s.torrent.SetPieceDeadline(piece, deadline)
+	}
+}
+
+// releaseDeadlineLocked drops a reader's interest in a piece. The deadline
+// is reset when no reader wants the piece any more.
+func (s *Stream) releaseDeadlineLocked(piece int) {
+	d, ok := s.deadlines[piece]
+	if !ok {
+		return
+	}
+	d.readers--
+	if d.readers > 0 {
+		return
+	}
+	delete(s.deadlines, piece)
+	if !s.torrent.HavePiece(piece) {
+		s.torrent.ResetPieceDeadline(piece)
+	}
+}
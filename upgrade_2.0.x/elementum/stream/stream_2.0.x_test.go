@@ -0,0 +1,628 @@
+package stream
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeTorrent is an in-memory torrent whose pieces complete on demand
+type fakeTorrent struct {
+	data        []byte
+	pieceLength int64
+
+	mu        sync.Mutex
+	have      map[int]bool
+	deadlines map[int]int
+	readers   map[int][2]int
+	lastID    int
+	stream    *Stream
+	evicted   map[int]bool    // verified but unreadable pieces
+	listener  func(piece int) // set through notifyingTorrent
+}
+
+func newFakeTorrent(size int, pieceLength int64) *fakeTorrent {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i*31 + i/256)
+	}
+	return &fakeTorrent{
+		data:        data,
+		pieceLength: pieceLength,
+		have:        make(map[int]bool),
+		deadlines:   make(map[int]int),
+		readers:     make(map[int][2]int),
+	}
+}
+
+func (t *fakeTorrent) numPieces() int {
+	return int((int64(len(t.data)) + t.pieceLength - 1) / t.pieceLength)
+}
+
+// complete marks pieces as downloaded and notifies the stream
+func (t *fakeTorrent) complete(pieces ...int) {
+	for _, p := range pieces {
+		t.mu.Lock()
+		t.have[p] = true
+		delete(t.deadlines, p)
+		s, listener := t.stream, t.listener
+		t.mu.Unlock()
+		if s != nil {
+			s.PieceFinished(p)
+		}
+		if listener != nil {
+			listener(p)
+		}
+	}
+}
+
+func (t *fakeTorrent) completeAll() {
+	for p := 0; p < t.numPieces(); p++ {
+		t.complete(p)
+	}
+}
+
+// download completes pieces with a deadline until stop is closed, like
+// libtorrent's time-critical piece picker
+func (t *fakeTorrent) download(stop chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(time.Millisecond):
+		}
+		t.mu.Lock()
+		next := -1
+		for p := range t.deadlines {
+			if next < 0 || t.deadlines[p] < t.deadlines[next] || (t.deadlines[p] == t.deadlines[next] && p < next) {
+				next = p
+			}
+		}
+		t.mu.Unlock()
+		if next >= 0 {
+			t.complete(next)
+		}
+	}
+}
+
+var errPieceEvicted = errors.New("piece evicted")
+
+// notifyingTorrent reports finished pieces like *bittorrent.Torrent
+type notifyingTorrent struct {
+	*fakeTorrent
+}
+
+func (t notifyingTorrent) OnPieceFinished(fn func(piece int)) func() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.listener = fn
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.listener = nil
+	}
+}
+
+func (t *fakeTorrent) GetInfoHashV1() string { return "0123456789abcdef0123456789abcdef01234567" }
+func (t *fakeTorrent) PieceLength() int64    { return t.pieceLength }
+
+func (t *fakeTorrent) HavePiece(piece int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.have[piece]
+}
+
+func (t *fakeTorrent) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= int64(len(t.data)) {
+			return n, io.EOF
+		}
+		piece := int(pos / t.pieceLength)
+		if !t.HavePiece(piece) {
+			return n, errors.New("piece not available")
+		}
+		t.mu.Lock()
+		evicted := t.evicted[piece]
+		t.mu.Unlock()
+		if evicted {
+			return n, errPieceEvicted
+		}
+		n++
+		p[n-1] = t.data[pos]
+	}
+	return n, nil
+}
+
+func (t *fakeTorrent) SetPieceDeadline(piece int, deadline int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.deadlines[piece] = deadline
+}
+
+func (t *fakeTorrent) ResetPieceDeadline(piece int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.deadlines, piece)
+}
+
+func (t *fakeTorrent) AddReader(firstPiece, lastPiece int) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lastID++
+	t.readers[t.lastID] = [2]int{firstPiece, lastPiece}
+	return t.lastID
+}
+
+func (t *fakeTorrent) UpdateReader(readerID, firstPiece, lastPiece int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.readers[readerID] = [2]int{firstPiece, lastPiece}
+}
+
+func (t *fakeTorrent) RemoveReader(readerID int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.readers, readerID)
+}
+
+func (t *fakeTorrent) counts() (deadlines, readers int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.deadlines), len(t.readers)
+}
+
+// testStream streams the second file of a torrent with a 1000 byte first
+// file, so the streamed file starts in the middle of a piece
+func testStream(t *testing.T, config *Config) (*fakeTorrent, *Stream) {
+	ft := newFakeTorrent(1000+10000+500, 1024)
+	if config == nil {
+		config = &Config{ReadAhead: 4096, ReadTimeout: 2 * time.Second, PollInterval: 10 * time.Millisecond, DeadlineStep: 10}
+	}
+	s, err := NewStream(ft, File{Path: "Movie/movie.mkv", Offset: 1000, Size: 10000}, config)
+	if err != nil {
+		t.Fatalf("NewStream: %v", err)
+	}
+	ft.stream = s
+	return ft, s
+}
+
+func (t *fakeTorrent) file(s *Stream) []byte {
+	f := s.File()
+	return t.data[f.Offset : f.Offset+f.Size]
+}
+
+func serve(s *Stream, method string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/stream/movie.mkv", nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	return w
+}
+
+// TestServeRanges checks full, partial and conditional responses
+func TestServeRanges(t *testing.T) {
+	ft, s := testStream(t, nil)
+	stop := make(chan struct{})
+	defer close(stop)
+	go ft.download(stop)
+
+	content := ft.file(s)
+	tests := []struct {
+		name    string
+		headers map[string]string
+		status  int
+		body    []byte
+		cr      string
+	}{
+		{"full", nil, http.StatusOK, content, ""},
+		{"range", map[string]string{"Range": "bytes=100-199"}, http.StatusPartialContent, content[100:200], "bytes 100-199/10000"},
+		{"open range", map[string]string{"Range": "bytes=9000-"}, http.StatusPartialContent, content[9000:], "bytes 9000-9999/10000"},
+		{"suffix range", map[string]string{"Range": "bytes=-300"}, http.StatusPartialContent, content[9700:], "bytes 9700-9999/10000"},
+		{"across pieces", map[string]string{"Range": "bytes=1000-3100"}, http.StatusPartialContent, content[1000:3101], "bytes 1000-3100/10000"},
+		{"unsatisfiable", map[string]string{"Range": "bytes=20000-"}, http.StatusRequestedRangeNotSatisfiable, nil, "bytes */10000"},
+		{"if-range match", map[string]string{"Range": "bytes=0-9", "If-Range": s.ETag()}, http.StatusPartialContent, content[:10], "bytes 0-9/10000"},
+		{"if-range stale", map[string]string{"Range": "bytes=0-9", "If-Range": `"other"`}, http.StatusOK, content, ""},
+		{"if-range date", map[string]string{"Range": "bytes=0-9", "If-Range": time.Now().UTC().Format(http.TimeFormat)}, http.StatusOK, content, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(s, http.MethodGet, tt.headers)
+			if w.Code != tt.status {
+				t.Fatalf("Status %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("Content-Range"); got != tt.cr {
+				t.Errorf("Content-Range %q, want %q", got, tt.cr)
+			}
+			if tt.body != nil && !bytes.Equal(w.Body.Bytes(), tt.body) {
+				t.Errorf("Body of %d bytes differs from the expected %d", w.Body.Len(), len(tt.body))
+			}
+			// Error responses carry no ETag
+			if w.Code < 300 && w.Header().Get("ETag") != s.ETag() {
+				t.Errorf("ETag %q, want %q", w.Header().Get("ETag"), s.ETag())
+			}
+		})
+	}
+
+	if n := s.Readers(); n != 0 {
+		t.Errorf("%d readers left open", n)
+	}
+}
+
+// TestServeHead answers without downloading anything
+func TestServeHead(t *testing.T) {
+	ft, s := testStream(t, nil)
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- serve(s, http.MethodHead, map[string]string{"Range": "bytes=500-"}) }()
+
+	select {
+	case w := <-done:
+		if w.Code != http.StatusPartialContent || w.Body.Len() != 0 {
+			t.Errorf("HEAD returned %d with %d bytes", w.Code, w.Body.Len())
+		}
+		if got := w.Header().Get("Content-Length"); got != "9500" {
+			t.Errorf("Content-Length %s, want 9500", got)
+		}
+		if got := w.Header().Get("Content-Type"); got != "video/x-matroska" {
+			t.Errorf("Content-Type %s", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("HEAD blocked on missing pieces")
+	}
+
+	if deadlines, readers := ft.counts(); deadlines != 0 || readers != 0 {
+		t.Errorf("HEAD left %d deadlines and %d readers", deadlines, readers)
+	}
+}
+
+// TestReadBlocksUntilPiece wakes a blocked read when its piece finishes
+func TestReadBlocksUntilPiece(t *testing.T) {
+	ft, s := testStream(t, nil)
+	r := s.NewReader(nil)
+	defer r.Close()
+
+	if _, err := r.Seek(5000, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	type result struct {
+		n   int
+		err error
+	}
+	done := make(chan result)
+	buf := make([]byte, 100)
+	go func() {
+		n, err := r.Read(buf)
+		done <- result{n, err}
+	}()
+
+	select {
+	case res := <-done:
+		t.Fatalf("Read returned %d, %v before the piece arrived", res.n, res.err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The read-ahead window starts at the read position
+	piece := int((1000 + 5000) / ft.pieceLength)
+	ft.mu.Lock()
+	deadline, ok := ft.deadlines[piece]
+	window := ft.readers[1]
+	ft.mu.Unlock()
+	if !ok || deadline != 0 {
+		t.Errorf("Piece %d has deadline %d (set: %v), want 0", piece, deadline, ok)
+	}
+	if window != [2]int{piece, int((1000 + 5000 + 4096 - 1) / ft.pieceLength)} {
+		t.Errorf("Reader range %v", window)
+	}
+
+	ft.complete(piece)
+	select {
+	case res := <-done:
+		if res.err != nil || !bytes.Equal(buf[:res.n], ft.file(s)[5000:5000+res.n]) {
+			t.Fatalf("Read returned %d, %v", res.n, res.err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Read not woken by PieceFinished")
+	}
+}
+
+// TestReadPolls notices pieces that finish without PieceFinished
+func TestReadPolls(t *testing.T) {
+	ft, s := testStream(t, nil)
+	ft.stream = nil
+	r := s.NewReader(nil)
+	defer r.Close()
+
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		ft.complete(0)
+	}()
+	buf := make([]byte, 10)
+	if n, err := r.Read(buf); err != nil || n != 10 {
+		t.Fatalf("Read returned %d, %v", n, err)
+	}
+}
+
+// TestReadTimeout fails reads of pieces that never arrive
+func TestReadTimeout(t *testing.T) {
+	ft, s := testStream(t, &Config{ReadAhead: 2048, ReadTimeout: 50 * time.Millisecond, PollInterval: 10 * time.Millisecond, DeadlineStep: 10})
+	r := s.NewReader(nil)
+
+	start := time.Now()
+	_, err := r.Read(make([]byte, 10))
+	if !errors.Is(err, ErrReadTimeout) {
+		t.Fatalf("Expected ErrReadTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Timed out after %s", elapsed)
+	}
+
+	r.Close()
+	if deadlines, readers := ft.counts(); deadlines != 0 || readers != 0 {
+		t.Errorf("Close left %d deadlines and %d readers", deadlines, readers)
+	}
+}
+
+// TestReadEvicted fails reads of a verified piece the torrent cannot read
+// at once instead of waiting for it
+func TestReadEvicted(t *testing.T) {
+	ft, s := testStream(t, nil)
+	ft.complete(0)
+	ft.mu.Lock()
+	ft.evicted = map[int]bool{0: true}
+	ft.mu.Unlock()
+
+	r := s.NewReader(nil)
+	defer r.Close()
+	start := time.Now()
+	if _, err := r.Read(make([]byte, 10)); !errors.Is(err, errPieceEvicted) {
+		t.Fatalf("Expected errPieceEvicted, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= s.config.ReadTimeout {
+		t.Errorf("Failed after %s", elapsed)
+	}
+}
+
+// TestPieceNotifier wakes readers through the torrent's notifications and
+// unsubscribes on Close
+func TestPieceNotifier(t *testing.T) {
+	ft := newFakeTorrent(1000+10000+500, 1024)
+	config := &Config{ReadAhead: 4096, ReadTimeout: 2 * time.Second, PollInterval: time.Hour, DeadlineStep: 10}
+	s, err := NewStream(notifyingTorrent{ft}, File{Path: "Movie/movie.mkv", Offset: 1000, Size: 10000}, config)
+	if err != nil {
+		t.Fatalf("NewStream: %v", err)
+	}
+
+	r := s.NewReader(nil)
+	done := make(chan error, 1)
+	go func() {
+		_, err := r.Read(make([]byte, 10))
+		done <- err
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	ft.complete(0)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Read returned %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Read not woken by the torrent's notification")
+	}
+
+	r.Close()
+	s.Close()
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	if ft.listener != nil {
+		t.Error("Close left the piece listener registered")
+	}
+}
+
+// openerTorrent hands out data readers like *bittorrent.Torrent
+type openerTorrent struct {
+	*fakeTorrent
+	opened []*fakeDataReader
+}
+
+type fakeDataReader struct {
+	torrent *fakeTorrent
+	reads   int
+	closed  bool
+}
+
+func (t *openerTorrent) NewDataReader() ReadAtCloser {
+	r := &fakeDataReader{torrent: t.fakeTorrent}
+	t.opened = append(t.opened, r)
+	return r
+}
+
+func (r *fakeDataReader) ReadAt(p []byte, off int64) (int, error) {
+	r.reads++
+	return r.torrent.ReadAt(p, off)
+}
+
+func (r *fakeDataReader) Close() error {
+	r.closed = true
+	return nil
+}
+
+// TestDataReaderOpener reads through a data reader of each Reader's own
+// and closes it with the Reader
+func TestDataReaderOpener(t *testing.T) {
+	ft := newFakeTorrent(1000+10000+500, 1024)
+	ft.completeAll()
+	ot := &openerTorrent{fakeTorrent: ft}
+	config := &Config{ReadAhead: 4096, ReadTimeout: 2 * time.Second, PollInterval: 10 * time.Millisecond, DeadlineStep: 10}
+	s, err := NewStream(ot, File{Path: "Movie/movie.mkv", Offset: 1000, Size: 10000}, config)
+	if err != nil {
+		t.Fatalf("NewStream: %v", err)
+	}
+	defer s.Close()
+
+	r1, r2 := s.NewReader(nil), s.NewReader(nil)
+	if len(ot.opened) != 2 {
+		t.Fatalf("Opened %d data readers for 2 readers", len(ot.opened))
+	}
+
+	got, err := io.ReadAll(r1)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(got, ft.file(s)) {
+		t.Error("Read data does not match the file")
+	}
+	if ot.opened[0].reads == 0 || ot.opened[1].reads != 0 {
+		t.Errorf("Reads went to the wrong data reader: %d, %d", ot.opened[0].reads, ot.opened[1].reads)
+	}
+
+	r1.Close()
+	if !ot.opened[0].closed || ot.opened[1].closed {
+		t.Error("Close did not close exactly the reader's own data reader")
+	}
+	r2.Close()
+	if !ot.opened[1].closed {
+		t.Error("Data reader left open")
+	}
+}
+
+// TestReadCancel stops waiting when the request goes away
+func TestReadCancel(t *testing.T) {
+	_, s := testStream(t, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	r := s.NewReader(ctx)
+	defer r.Close()
+
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := r.Read(make([]byte, 10)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+}
+
+// TestMultipleReaders keeps each reader's window and shared deadlines apart
+func TestMultipleReaders(t *testing.T) {
+	ft, s := testStream(t, nil)
+
+	a := s.NewReader(nil)
+	b := s.NewReader(nil)
+	defer b.Close()
+
+	// Pieces 1-5 for a, 4-8 for b: pieces 4 and 5 are shared
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, rd := range []struct {
+		r   *Reader
+		pos int64
+	}{{a, 100}, {b, 3200}} {
+		rd.r.ctx = ctx
+		rd.r.Seek(rd.pos, io.SeekStart)
+		wg.Add(1)
+		go func(r *Reader) {
+			defer wg.Done()
+			r.Read(make([]byte, 10))
+		}(rd.r)
+	}
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	wg.Wait()
+
+	ft.mu.Lock()
+	ranges := fmt.Sprint(ft.readers[1], ft.readers[2])
+	shared := ft.deadlines[4]
+	ft.mu.Unlock()
+	if ranges != "[1 5] [4 8]" && ranges != "[4 8] [1 5]" {
+		t.Errorf("Reader ranges %s", ranges)
+	}
+	// b asked for piece 4 first, a only later in its window
+	if shared != 0 {
+		t.Errorf("Shared piece deadline %d, want the earlier 0", shared)
+	}
+
+	// Closing a keeps the pieces b still wants
+	a.Close()
+	ft.mu.Lock()
+	_, kept := ft.deadlines[4]
+	_, dropped := ft.deadlines[1]
+	readers := len(ft.readers)
+	ft.mu.Unlock()
+	if !kept || dropped || readers != 1 {
+		t.Errorf("After closing a: piece 4 kept %v, piece 1 kept %v, %d readers", kept, dropped, readers)
+	}
+	if s.Readers() != 1 {
+		t.Errorf("%d readers open", s.Readers())
+	}
+}
+
+// TestConcurrentRequests serves overlapping ranges in parallel
+func TestConcurrentRequests(t *testing.T) {
+	ft, s := testStream(t, nil)
+	stop := make(chan struct{})
+	defer close(stop)
+	go ft.download(stop)
+
+	content := ft.file(s)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			start := i * 1100
+			w := serve(s, http.MethodGet, map[string]string{"Range": "bytes=" + strconv.Itoa(start) + "-" + strconv.Itoa(start+2999)})
+			end := start + 3000
+			if end > len(content) {
+				end = len(content)
+			}
+			if w.Code != http.StatusPartialContent || !bytes.Equal(w.Body.Bytes(), content[start:end]) {
+				t.Errorf("Request %d: status %d, %d bytes", i, w.Code, w.Body.Len())
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// TestClose fails pending reads and new requests
+func TestClose(t *testing.T) {
+	ft, s := testStream(t, nil)
+	r := s.NewReader(nil)
+	defer r.Close()
+
+	done := make(chan error)
+	go func() {
+		_, err := r.Read(make([]byte, 10))
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	s.Close()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrClosed) {
+			t.Errorf("Expected ErrClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close did not wake the read")
+	}
+
+	if deadlines, readers := ft.counts(); deadlines != 0 || readers != 0 {
+		t.Errorf("Close left %d deadlines and %d readers", deadlines, readers)
+	}
+	ft.completeAll()
+	if w := serve(s, http.MethodGet, nil); w.Code != http.StatusGone {
+		t.Errorf("Status after Close %d", w.Code)
+	}
+}
@@ -0,0 +1,278 @@
+// torrentfile_2.0.x.go - Checks on .torrent files from untrusted sources
+//
+// Torrent files from the network are size-limited and their bencode
+// structure is checked before libtorrent sees them, so callers get an
+// *Error saying what is wrong instead of an opaque failure. Errors match
+// ErrInvalidBencode, ErrMissingInfoDict, ErrPieceLength, ErrTooLarge or
+// ErrDownload with errors.Is.
+//
+// The package does not depend on libtorrent, so it builds and tests
+// without the SWIG bindings.
+
+package torrentfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// DefaultMaxSize limits .torrent files when no limit is given
+const DefaultMaxSize = 10 * 1024 * 1024
+
+// Piece length bounds accepted for torrent files
+const (
+	minPieceLength = 16 * 1024
+	maxPieceLength = 128 * 1024 * 1024
+)
+
+// maxBencodeDepth bounds nesting so hostile input cannot exhaust the stack
+const maxBencodeDepth = 64
+
+// Torrent file error kinds
+var (
+	ErrInvalidBencode  = errors.New("invalid bencode")
+	ErrMissingInfoDict = errors.New("missing info dictionary")
+	ErrPieceLength     = errors.New("piece length out of range")
+	ErrTooLarge        = errors.New("torrent file too large")
+	ErrDownload        = errors.New("torrent download failed")
+	ErrInvalid         = errors.New("invalid torrent file")
+)
+
+// Error describes why a torrent file was rejected
+type Error struct {
+	Kind   error // one of the torrent file error kinds above
+	Detail string
+	Source string // URL, if downloaded
+}
+
+func (e *Error) Error() string {
+	msg := e.Kind.Error()
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	if e.Source != "" {
+		msg = e.Source + ": " + msg
+	}
+	return msg
+}
+
+// Unwrap lets errors.Is match the error kind
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+func newError(kind error, format string, args ...interface{}) *Error {
+	return &Error{Kind: kind, Detail: fmt.Sprintf(format, args...)}
+}
+
+// HTTPClient is the part of *http.Client used to download torrent files
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Download fetches a torrent file over HTTP(S), stopping at limit bytes.
+// A nil client uses http.DefaultClient; limit <= 0 uses DefaultMaxSize.
+func Download(ctx context.Context, client HTTPClient, rawURL string, limit int64) ([]byte, error) {
+	downloadErr := func(format string, args ...interface{}) error {
+		err := newError(ErrDownload, format, args...)
+		err.Source = rawURL
+		return err
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, downloadErr("unsupported URL")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, downloadErr("%s", err)
+	}
+	req.Header.Set("Accept", "application/x-bittorrent")
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, downloadErr("%s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, downloadErr("HTTP %d", resp.StatusCode)
+	}
+
+	if limit <= 0 {
+		limit = DefaultMaxSize
+	}
+	if resp.ContentLength > limit {
+		err := newError(ErrTooLarge, "%d bytes, limit %d", resp.ContentLength, limit)
+		err.Source = rawURL
+		return nil, err
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, downloadErr("%s", err)
+	}
+	if int64(len(data)) > limit {
+		err := newError(ErrTooLarge, "more than %d bytes", limit)
+		err.Source = rawURL
+		return nil, err
+	}
+	return data, nil
+}
+
+// ValidateSize checks data against a size limit, then like Validate.
+// limit <= 0 uses DefaultMaxSize.
+func ValidateSize(data []byte, limit int64) error {
+	if limit <= 0 {
+		limit = DefaultMaxSize
+	}
+	if int64(len(data)) > limit {
+		return newError(ErrTooLarge, "%d bytes, limit %d", len(data), limit)
+	}
+	return Validate(data)
+}
+
+// Validate checks that data is a bencoded dictionary with an info
+// dictionary whose piece length is in range
+func Validate(data []byte) error {
+	d := bdecoder{data: data}
+	root, err := d.value(0)
+	if err != nil {
+		return err
+	}
+	if d.pos != len(data) {
+		return newError(ErrInvalidBencode, "trailing data at offset %d", d.pos)
+	}
+
+	dict, ok := root.(map[string]interface{})
+	if !ok {
+		return newError(ErrInvalidBencode, "top level is not a dictionary")
+	}
+	info, ok := dict["info"].(map[string]interface{})
+	if !ok {
+		return &Error{Kind: ErrMissingInfoDict}
+	}
+
+	pieceLength, ok := info["piece length"].(int64)
+	if !ok {
+		return newError(ErrPieceLength, "missing piece length")
+	}
+	if pieceLength < minPieceLength || pieceLength > maxPieceLength {
+		return newError(ErrPieceLength, "%d not in [%d, %d]", pieceLength, minPieceLength, maxPieceLength)
+	}
+	// BitTorrent v2 requires power-of-two piece lengths
+	if _, v2 := info["meta version"]; v2 && pieceLength&(pieceLength-1) != 0 {
+		return newError(ErrPieceLength, "%d is not a power of two", pieceLength)
+	}
+	return nil
+}
+
+// bdecoder is a minimal bencode parser used for validation. Strings are
+// kept as strings, integers as int64, lists as []interface{} and
+// dictionaries as map[string]interface{}.
+type bdecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *bdecoder) fail(format string, args ...interface{}) error {
+	return newError(ErrInvalidBencode, "offset %d: %s", d.pos, fmt.Sprintf(format, args...))
+}
+
+func (d *bdecoder) value(depth int) (interface{}, error) {
+	if depth > maxBencodeDepth {
+		return nil, d.fail("nested too deeply")
+	}
+	if d.pos >= len(d.data) {
+		return nil, d.fail("unexpected end of data")
+	}
+
+	switch c := d.data[d.pos]; {
+	case c == 'i':
+		d.pos++
+		return d.integer('e')
+	case c == 'l':
+		d.pos++
+		var list []interface{}
+		for {
+			if d.pos >= len(d.data) {
+				return nil, d.fail("unterminated list")
+			}
+			if d.data[d.pos] == 'e' {
+				d.pos++
+				return list, nil
+			}
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+	case c == 'd':
+		d.pos++
+		dict := make(map[string]interface{})
+		for {
+			if d.pos >= len(d.data) {
+				return nil, d.fail("unterminated dictionary")
+			}
+			if d.data[d.pos] == 'e' {
+				d.pos++
+				return dict, nil
+			}
+			key, err := d.str()
+			if err != nil {
+				return nil, err
+			}
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			dict[key] = v
+		}
+	case c >= '0' && c <= '9':
+		return d.str()
+	default:
+		return nil, d.fail("unexpected byte %q", c)
+	}
+}
+
+func (d *bdecoder) integer(end byte) (int64, error) {
+	start := d.pos
+	for d.pos < len(d.data) && d.data[d.pos] != end {
+		d.pos++
+	}
+	if d.pos >= len(d.data) {
+		return 0, d.fail("unterminated integer")
+	}
+	n, err := strconv.ParseInt(string(d.data[start:d.pos]), 10, 64)
+	if err != nil {
+		return 0, d.fail("invalid integer")
+	}
+	d.pos++
+	return n, nil
+}
+
+func (d *bdecoder) str() (string, error) {
+	if d.pos >= len(d.data) || d.data[d.pos] < '0' || d.data[d.pos] > '9' {
+		return "", d.fail("expected string")
+	}
+	n, err := d.integer(':')
+	if err != nil {
+		return "", err
+	}
+	if n < 0 || n > int64(len(d.data)-d.pos) {
+		return "", d.fail("string length %d out of range", n)
+	}
+	s := string(d.data[d.pos : d.pos+int(n)])
+	d.pos += int(n)
+	return s, nil
+}
@@ -0,0 +1,130 @@
+package torrentfile
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	deep := strings.Repeat("l", maxBencodeDepth+1) + strings.Repeat("e", maxBencodeDepth+1)
+	nested := strings.Repeat("l", maxBencodeDepth) + strings.Repeat("e", maxBencodeDepth)
+
+	tests := []struct {
+		name string
+		data string
+		kind error // nil for a valid file
+	}{
+		{"valid", "d4:infod6:lengthi1e4:name1:a12:piece lengthi16384eee", nil},
+		{"valid v2", "d4:infod12:meta versioni2e4:name1:a12:piece lengthi65536eee", nil},
+		{"valid with nested lists", "d4:infod12:piece lengthi16384ee1:x" + nested + "e", nil},
+		{"empty", "", ErrInvalidBencode},
+		{"not a dictionary", "li1ee", ErrInvalidBencode},
+		{"trailing data", "d4:infod12:piece lengthi16384eeexyz", ErrInvalidBencode},
+		{"unterminated dictionary", "d4:infod12:piece lengthi16384e", ErrInvalidBencode},
+		{"unterminated integer", "d1:xi12", ErrInvalidBencode},
+		{"invalid integer", "d1:xi1x2ee", ErrInvalidBencode},
+		{"string past the end", "d4:info99:abce", ErrInvalidBencode},
+		{"non-string key", "di1ei2ee", ErrInvalidBencode},
+		{"unexpected byte", "d1:xze", ErrInvalidBencode},
+		{"nested too deeply", "d1:x" + deep + "e", ErrInvalidBencode},
+		{"missing info", "d8:announce3:urle", ErrMissingInfoDict},
+		{"info not a dictionary", "d4:infoli1eee", ErrMissingInfoDict},
+		{"missing piece length", "d4:infod4:name1:aee", ErrPieceLength},
+		{"piece length too small", "d4:infod12:piece lengthi8192eee", ErrPieceLength},
+		{"piece length too large", "d4:infod12:piece lengthi268435456eee", ErrPieceLength},
+		{"v2 piece length not a power of two", "d4:infod12:meta versioni2e12:piece lengthi49152eee", ErrPieceLength},
+		{"v1 piece length not a power of two", "d4:infod12:piece lengthi49152eee", nil},
+	}
+
+	for _, tt := range tests {
+		err := Validate([]byte(tt.data))
+		if tt.kind == nil {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", tt.name, err)
+			}
+			continue
+		}
+		var tfe *Error
+		if !errors.Is(err, tt.kind) || !errors.As(err, &tfe) {
+			t.Errorf("%s: expected %v as *Error, got %v", tt.name, tt.kind, err)
+		}
+	}
+}
+
+func TestValidateSize(t *testing.T) {
+	data := []byte("d4:infod12:piece lengthi16384eee")
+	tests := []struct {
+		limit int64
+		kind  error
+	}{
+		{0, nil}, // DefaultMaxSize
+		{int64(len(data)), nil},
+		{int64(len(data)) - 1, ErrTooLarge},
+	}
+
+	for _, tt := range tests {
+		if err := ValidateSize(data, tt.limit); !errors.Is(err, tt.kind) {
+			t.Errorf("Limit %d: expected %v, got %v", tt.limit, tt.kind, err)
+		}
+	}
+}
+
+// fakeHTTPClient answers every request with one response
+type fakeHTTPClient struct {
+	status        int
+	body          []byte
+	contentLength int64 // -1 for unknown
+	err           error
+}
+
+func (c *fakeHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &http.Response{
+		StatusCode:    c.status,
+		ContentLength: c.contentLength,
+		Body:          io.NopCloser(bytes.NewReader(c.body)),
+		Request:       req,
+	}, nil
+}
+
+func TestDownload(t *testing.T) {
+	const limit = 64
+	small := []byte("d4:infod12:piece lengthi16384eee")
+	large := bytes.Repeat([]byte("x"), limit+1)
+
+	tests := []struct {
+		name   string
+		url    string
+		client *fakeHTTPClient
+		kind   error
+	}{
+		{"ok", "https://example.org/a.torrent", &fakeHTTPClient{status: 200, body: small, contentLength: -1}, nil},
+		{"unsupported scheme", "ftp://example.org/a.torrent", &fakeHTTPClient{status: 200, body: small}, ErrDownload},
+		{"HTTP error", "https://example.org/a.torrent", &fakeHTTPClient{status: 404, contentLength: -1}, ErrDownload},
+		{"request error", "https://example.org/a.torrent", &fakeHTTPClient{err: errors.New("refused")}, ErrDownload},
+		{"declared too large", "https://example.org/a.torrent", &fakeHTTPClient{status: 200, body: large, contentLength: limit + 1}, ErrTooLarge},
+		{"body too large", "https://example.org/a.torrent", &fakeHTTPClient{status: 200, body: large, contentLength: -1}, ErrTooLarge},
+	}
+
+	for _, tt := range tests {
+		data, err := Download(context.Background(), tt.client, tt.url, limit)
+		if !errors.Is(err, tt.kind) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.kind, err)
+			continue
+		}
+		if tt.kind == nil && !bytes.Equal(data, small) {
+			t.Errorf("%s: got %q", tt.name, data)
+		}
+		var tfe *Error
+		if tt.kind != nil && (!errors.As(err, &tfe) || tfe.Source != tt.url) {
+			t.Errorf("%s: error %v does not name the URL", tt.name, err)
+		}
+	}
+}
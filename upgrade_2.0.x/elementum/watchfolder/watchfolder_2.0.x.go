@@ -0,0 +1,268 @@
+// watchfolder_2.0.x.go - Watch-folder ingestion for libtorrent 2.0.x
+//
+// Polls a directory for .torrent files and text files holding a magnet
+// link (.magnet or .txt) and adds them through the service. Each processed
+// file is moved to the done or failed subfolder; failures get a
+// <file>.error.log next to the moved file explaining what went wrong.
+//
+// The folder is often shared with other machines, so a file is only picked
+// up once its size and modification time are unchanged between two scans.
+//
+// The package does not depend on libtorrent, so it builds and tests
+// without the SWIG bindings.
+
+package watchfolder
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("watchfolder")
+
+const (
+	doneDir   = "done"
+	failedDir = "failed"
+
+	defaultInterval = 5 * time.Second
+)
+
+// Config configures watch-folder ingestion
+type Config struct {
+	Enabled bool   `json:"enabled"`
+	Path    string `json:"path"`
+	// Interval between scans in seconds; 0 uses the default
+	Interval int `json:"interval"`
+	// Category and labels the service gives added torrents
+	Category string   `json:"category,omitempty"`
+	Labels   []string `json:"labels,omitempty"`
+}
+
+// Folder adds torrents dropped into a directory
+type Folder struct {
+	config Config
+	// Adds a .torrent file path or magnet URI
+	add func(uri string) error
+
+	// Last seen size and mtime per file, to wait for copies to finish
+	scanMu sync.Mutex
+	seen   map[string]watchedFile
+
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+}
+
+type watchedFile struct {
+	size    int64
+	modTime time.Time
+	// Processed but could not be moved out of the folder. It is skipped
+	// until its size or mtime change, instead of being added again.
+	stuck bool
+}
+
+func (f watchedFile) sameAs(other watchedFile) bool {
+	return f.size == other.size && f.modTime.Equal(other.modTime)
+}
+
+// New creates a watch folder passing every file path or magnet link it
+// finds to add. The done and failed subfolders are created if needed.
+func New(config Config, add func(uri string) error) (*Folder, error) {
+	if config.Path == "" {
+		return nil, fmt.Errorf("watch folder path is empty")
+	}
+	for _, sub := range []string{doneDir, failedDir} {
+		if err := os.MkdirAll(filepath.Join(config.Path, sub), 0755); err != nil {
+			return nil, fmt.Errorf("create watch folder: %w", err)
+		}
+	}
+
+	return &Folder{
+		config: config,
+		add:    add,
+		seen:   make(map[string]watchedFile),
+	}, nil
+}
+
+// Start scans the folder periodically until Stop is called
+func (w *Folder) Start() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stop != nil {
+		return
+	}
+
+	interval := time.Duration(w.config.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	w.stop, w.done = stop, done
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				w.Scan()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the scans and waits for the current one to finish
+func (w *Folder) Stop() {
+	w.mu.Lock()
+	stop, done := w.stop, w.done
+	w.stop, w.done = nil, nil
+	w.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// Scan processes every settled file in the folder once.
+// It returns the number of files added and failed.
+func (w *Folder) Scan() (added, failed int) {
+	w.scanMu.Lock()
+	defer w.scanMu.Unlock()
+
+	entries, err := os.ReadDir(w.config.Path)
+	if err != nil {
+		log.Warningf("Watch folder %s: %s", w.config.Path, err)
+		return 0, 0
+	}
+
+	current := make(map[string]watchedFile, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !isWatchedFile(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		path := filepath.Join(w.config.Path, entry.Name())
+		state := watchedFile{size: info.Size(), modTime: info.ModTime()}
+
+		prev, ok := w.seen[path]
+		if ok && prev.stuck && prev.sameAs(state) {
+			current[path] = prev
+			continue
+		}
+		// Still being written: wait for the next scan
+		if !ok || !prev.sameAs(state) {
+			current[path] = state
+			continue
+		}
+
+		var moved bool
+		if err := w.ingest(path); err != nil {
+			log.Warningf("Watch folder: failed to add %s: %s", entry.Name(), err)
+			moved = w.finish(path, failedDir, err)
+			failed++
+		} else {
+			log.Infof("Watch folder: added %s", entry.Name())
+			moved = w.finish(path, doneDir, nil)
+			added++
+		}
+		if !moved {
+			state.stuck = true
+			current[path] = state
+		}
+	}
+
+	w.seen = current
+	return added, failed
+}
+
+// ingest adds one file
+func (w *Folder) ingest(path string) error {
+	uri := path
+	if !strings.EqualFold(filepath.Ext(path), ".torrent") {
+		magnet, err := readMagnetFile(path)
+		if err != nil {
+			return err
+		}
+		uri = magnet
+	}
+	return w.add(uri)
+}
+
+// finish moves a processed file into a subfolder, writing an error log
+// beside it on failure. It reports whether the file was moved.
+func (w *Folder) finish(path, sub string, ingestErr error) bool {
+	target := uniquePath(filepath.Join(w.config.Path, sub, filepath.Base(path)))
+	if err := os.Rename(path, target); err != nil {
+		log.Errorf("Watch folder: cannot move %s to %s, skipping it until it changes: %s", path, sub, err)
+		return false
+	}
+
+	if ingestErr != nil {
+		msg := fmt.Sprintf("%s\n%s\n%s\n", time.Now().Format(time.RFC3339), filepath.Base(path), ingestErr)
+		if err := os.WriteFile(target+".error.log", []byte(msg), 0644); err != nil {
+			log.Errorf("Watch folder: cannot write error log for %s: %s", target, err)
+		}
+	}
+	return true
+}
+
+// isWatchedFile reports whether a file name is one the folder ingests
+func isWatchedFile(name string) bool {
+	if strings.HasPrefix(name, ".") {
+		return false
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".torrent", ".magnet", ".txt":
+		return true
+	}
+	return false
+}
+
+// readMagnetFile returns the first magnet link in a text file
+func readMagnetFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "magnet:?") {
+			return line, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no magnet link found")
+}
+
+// uniquePath returns path, or path with a timestamp suffix if it exists
+func uniquePath(path string) string {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return path
+	}
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	return fmt.Sprintf("%s.%s%s", base, time.Now().Format("20060102-150405.000"), ext)
+}
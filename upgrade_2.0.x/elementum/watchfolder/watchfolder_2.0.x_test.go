@@ -0,0 +1,196 @@
+package watchfolder
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testMagnet = "magnet:?xt=urn:btih:c9e15763f722f23e98a29decdfae341b98d53056"
+
+// newTestFolder returns a watch folder in a temporary directory whose adds
+// are recorded in uris and fail with addErr
+func newTestFolder(t *testing.T, addErr error, uris *[]string) *Folder {
+	w, err := New(Config{Path: t.TempDir()}, func(uri string) error {
+		*uris = append(*uris, uri)
+		return addErr
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestScan(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		addErr  error
+		sub     string // where the file ends up, "" if left in place
+		uri     string // URI passed to add, the file name for the file's path
+	}{
+		{"torrent", "a.torrent", "d4:infodee", nil, doneDir, "a.torrent"},
+		{"torrent upper case", "B.TORRENT", "d4:infodee", nil, doneDir, "B.TORRENT"},
+		{"magnet file", "c.magnet", testMagnet + "\n", nil, doneDir, testMagnet},
+		{"magnet after other lines", "d.txt", "# from the tracker\n\n  " + testMagnet + "  \n", nil, doneDir, testMagnet},
+		{"text without magnet", "e.txt", "nothing to see\n", nil, failedDir, ""},
+		{"add fails", "f.torrent", "garbage", errors.New("invalid torrent"), failedDir, "f.torrent"},
+		{"hidden file", ".g.torrent", "d4:infodee", nil, "", ""},
+		{"other extension", "h.nfo", testMagnet, nil, "", ""},
+	}
+
+	for _, tt := range tests {
+		var uris []string
+		w := newTestFolder(t, tt.addErr, &uris)
+		path := filepath.Join(w.config.Path, tt.file)
+		if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		// The first scan only records the file
+		if added, failed := w.Scan(); added != 0 || failed != 0 || len(uris) != 0 {
+			t.Errorf("%s: first scan added %d, failed %d", tt.name, added, failed)
+		}
+		added, failed := w.Scan()
+
+		wantAdded, wantFailed := 0, 0
+		switch tt.sub {
+		case doneDir:
+			wantAdded = 1
+		case failedDir:
+			wantFailed = 1
+		}
+		if added != wantAdded || failed != wantFailed {
+			t.Errorf("%s: added %d, failed %d, want %d, %d", tt.name, added, failed, wantAdded, wantFailed)
+		}
+
+		wantURI := tt.uri
+		if wantURI == tt.file {
+			wantURI = path
+		}
+		if wantURI == "" && len(uris) != 0 || wantURI != "" && (len(uris) != 1 || uris[0] != wantURI) {
+			t.Errorf("%s: added %q, want %q", tt.name, uris, wantURI)
+		}
+
+		target := path
+		if tt.sub != "" {
+			target = filepath.Join(w.config.Path, tt.sub, tt.file)
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Errorf("%s: file left in the watch folder", tt.name)
+			}
+		}
+		if _, err := os.Stat(target); err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+		}
+
+		errLog, err := os.ReadFile(target + ".error.log")
+		if tt.sub == failedDir {
+			if err != nil {
+				t.Errorf("%s: no error log: %s", tt.name, err)
+			} else if !strings.Contains(string(errLog), tt.file) {
+				t.Errorf("%s: error log does not name the file: %q", tt.name, errLog)
+			}
+		} else if err == nil {
+			t.Errorf("%s: unexpected error log %q", tt.name, errLog)
+		}
+	}
+}
+
+// TestSettle waits for a file still being written
+func TestSettle(t *testing.T) {
+	var uris []string
+	w := newTestFolder(t, nil, &uris)
+	path := filepath.Join(w.config.Path, "a.magnet")
+
+	steps := []struct {
+		content string // written before the scan, "" to leave the file alone
+		added   int
+	}{
+		{"magnet:?xt=urn:btih:", 0},
+		{testMagnet, 0}, // grew since the last scan
+		{"", 1},
+		{"", 0}, // already moved
+	}
+	for i, step := range steps {
+		if step.content != "" {
+			if err := os.WriteFile(path, []byte(step.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if added, _ := w.Scan(); added != step.added {
+			t.Errorf("Scan %d: added %d, want %d", i+1, added, step.added)
+		}
+	}
+	if len(uris) != 1 || uris[0] != testMagnet {
+		t.Errorf("Added %q, want %q", uris, testMagnet)
+	}
+}
+
+// TestDuplicate keeps both copies of a file added twice
+func TestDuplicate(t *testing.T) {
+	var uris []string
+	w := newTestFolder(t, nil, &uris)
+	path := filepath.Join(w.config.Path, "a.torrent")
+
+	for i := 0; i < 2; i++ {
+		if err := os.WriteFile(path, []byte("d4:infodee"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		w.Scan()
+		if added, _ := w.Scan(); added != 1 {
+			t.Errorf("Copy %d: added %d, want 1", i+1, added)
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Join(w.config.Path, doneDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("Done folder holds %d files, want 2", len(entries))
+	}
+}
+
+// TestStuck skips a file that could not be moved until it changes
+func TestStuck(t *testing.T) {
+	var uris []string
+	w := newTestFolder(t, nil, &uris)
+	path := filepath.Join(w.config.Path, "a.torrent")
+
+	// A file where the done folder should be makes every move fail
+	done := filepath.Join(w.config.Path, doneDir)
+	if err := os.Remove(done); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(done, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		content string // written before the scan, "" to leave the file alone
+		added   int
+	}{
+		{"d4:infodee", 0},
+		{"", 1}, // added, but cannot be moved
+		{"", 0},
+		{"", 0},
+		{"d4:infod1:xi1eee", 0}, // replaced: settle again
+		{"", 1},
+	}
+	for i, step := range steps {
+		if step.content != "" {
+			if err := os.WriteFile(path, []byte(step.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if added, _ := w.Scan(); added != step.added {
+			t.Errorf("Scan %d: added %d, want %d", i+1, added, step.added)
+		}
+	}
+	if len(uris) != 2 {
+		t.Errorf("Added %d times, want 2", len(uris))
+	}
+}
@@ -53,10 +53,14 @@ func (sp *SessionParams) SetSettings(settings *SettingsPack) {
 	}
 }
 
-// GetSettings returns the current settings_pack
+// GetSettings returns the settings_pack held by the params.
+// The pack is owned by the params; do not Delete it.
 func (sp *SessionParams) GetSettings() *SettingsPack {
-	// Calls session_params::get_settings from SWIG interface
-	return nil
+	if sp.ptr == nil {
+		return nil
+	}
+	swigPtr := (lt.Session_params)(sp.ptr)
+	return &SettingsPack{ptr: unsafe.Pointer(lt.Session_params_get_settings(swigPtr))}
 }
 
 // Session wraps libtorrent::session for 2.0.x
@@ -64,6 +68,8 @@ type Session struct {
 	handle unsafe.Pointer
 	// Storage index tracking for lookbehind access
 	storageIndices map[string]int // info_hash_v1 -> storage_index
+	// Profile last applied with ApplyProfile, nil before the first switch
+	profile *SettingsProfile
 }
 
 // CreateSessionWithParams creates a new session using session_params (2.0.x way)
@@ -157,6 +163,21 @@ func RestoreSessionState(data []byte) (*SessionParams, error) {
 	return nil, nil
 }
 
+// ApplySettings applies a settings_pack to the running session.
+// Changes reach memory disk I/O through disk_interface::settings_updated.
+func (s *Session) ApplySettings(settings *SettingsPack) error {
+	if s == nil || s.handle == nil {
+		return fmt.Errorf("invalid session")
+	}
+	if settings == nil || settings.ptr == nil {
+		return fmt.Errorf("invalid settings_pack")
+	}
+
+	sessionHandle := (lt.Session)(s.handle)
+	sessionHandle.Apply_settings_pack(lt.Settings_pack(settings.ptr))
+	return nil
+}
+
 // PostTorrentUpdates requests torrent status updates (replaces stats_alert)
 func (s *Session) PostTorrentUpdates() {
 	// Calls session_handle::post_torrent_updates()
@@ -176,6 +197,14 @@ func NewSettingsPack() *SettingsPack {
 	}
 }
 
+// DefaultSettingsPack creates a settings_pack holding every setting at
+// libtorrent's default value
+func DefaultSettingsPack() *SettingsPack {
+	return &SettingsPack{
+		ptr: unsafe.Pointer(lt.Default_settings_pack()),
+	}
+}
+
 // Delete frees the underlying SWIG settings_pack
 func (sp *SettingsPack) Delete() {
 	if sp.ptr != nil {
@@ -193,129 +222,47 @@ func (sp *SettingsPack) HasSetting(name string) bool {
 	return swigSettings.Has_setting(name)
 }
 
-// SetBool sets a boolean setting by name
+// SetBool sets a boolean setting by name.
+// Failures are ignored; use SetBoolValue to see them.
 func (sp *SettingsPack) SetBool(name string, value bool) {
-	if sp.ptr == nil {
-		return
-	}
-
-	// Check for removed settings in 2.0.x
-	removedSettings := map[string]bool{
-		"lazy_bitfields":      true, // Removed in 1.2.x
-		"use_dht_as_fallback": true, // Deprecated
-		"cache_size":          true, // Removed in 2.0.x (mmap handles caching)
-		"cache_expiry":        true, // Removed in 2.0.x
-		"use_read_cache":      true, // Removed in 2.0.x
-		"use_write_cache":     true, // Removed in 2.0.x
-	}
-
-	if removedSettings[name] {
-		// Silently ignore removed settings for compatibility
-		return
-	}
-
-	// Call SWIG binding
-	swigSettings := lt.Settings_pack(sp.ptr)
-	swigSettings.Set_bool(name, value)
+	sp.SetBoolValue(name, value)
 }
 
-// SetInt sets an integer setting by name
+// SetInt sets an integer setting by name.
+// Failures are ignored; use SetIntValue to see them.
 func (sp *SettingsPack) SetInt(name string, value int) {
-	if sp.ptr == nil {
-		return
-	}
-
-	// Check for removed integer settings in 2.0.x
-	removedSettings := map[string]bool{
-		"cache_size":        true, // Removed in 2.0.x (mmap handles caching)
-		"cache_expiry":      true, // Removed in 2.0.x
-		"cache_buffer_chunk_size": true, // Removed in 2.0.x
-		"read_cache_line_size":    true, // Removed in 2.0.x
-		"write_cache_line_size":   true, // Removed in 2.0.x
-	}
-
-	if removedSettings[name] {
-		// Silently ignore removed settings for compatibility
-		return
-	}
-
-	// Handle renamed settings
-	renamedSettings := map[string]string{
-		// aio_threads was split in 2.0.x
-		// "aio_threads": Now separate from hashing_threads
-	}
-
-	if newName, ok := renamedSettings[name]; ok {
-		name = newName
-	}
-
-	// Call SWIG binding
-	swigSettings := lt.Settings_pack(sp.ptr)
-	swigSettings.Set_int(name, value)
+	sp.SetIntValue(name, value)
 }
 
-// SetStr sets a string setting by name
+// SetStr sets a string setting by name.
+// Failures are ignored; use SetStrValue to see them.
 func (sp *SettingsPack) SetStr(name string, value string) {
-	if sp.ptr == nil {
-		return
-	}
-
-	// Call SWIG binding
-	swigSettings := lt.Settings_pack(sp.ptr)
-	swigSettings.Set_str(name, value)
+	sp.SetStrValue(name, value)
 }
 
-// GetBool gets a boolean setting by name
+// GetBool gets a boolean setting by name, or false on failure.
+// Use BoolValue to tell a false setting from an error.
 func (sp *SettingsPack) GetBool(name string) bool {
-	if sp.ptr == nil {
-		return false
-	}
-
-	// Call SWIG binding
-	swigSettings := lt.Settings_pack(sp.ptr)
-	return swigSettings.Get_bool(name)
+	value, _ := sp.BoolValue(name)
+	return value
 }
 
-// GetInt gets an integer setting by name
+// GetInt gets an integer setting by name, or 0 on failure.
+// Use IntValue to tell a zero setting from an error.
 func (sp *SettingsPack) GetInt(name string) int {
-	if sp.ptr == nil {
-		return 0
-	}
-
-	// Call SWIG binding
-	swigSettings := lt.Settings_pack(sp.ptr)
-	return swigSettings.Get_int(name)
+	value, _ := sp.IntValue(name)
+	return value
 }
 
-// GetStr gets a string setting by name
+// GetStr gets a string setting by name, or "" on failure.
+// Use StrValue to tell an empty setting from an error.
 func (sp *SettingsPack) GetStr(name string) string {
-	if sp.ptr == nil {
-		return ""
-	}
-
-	// Call SWIG binding
-	swigSettings := lt.Settings_pack(sp.ptr)
-	return swigSettings.Get_str(name)
+	value, _ := sp.StrValue(name)
+	return value
 }
 
-// ConfigureForStreaming sets optimal settings for video streaming
+// ConfigureForStreaming sets optimal settings for video streaming.
+// The values live in the built-in streaming profile.
 func (sp *SettingsPack) ConfigureForStreaming() {
-	// Optimized settings for Elementum streaming use case
-	sp.SetInt("connections_limit", 200)
-	sp.SetInt("max_out_request_queue", 5000)
-	sp.SetInt("max_peer_recv_buffer_size", 5*1024*1024)
-	sp.SetInt("send_buffer_watermark", 10*1024*1024)
-	sp.SetInt("send_buffer_watermark_factor", 150)
-	sp.SetInt("send_buffer_low_watermark", 1024*1024)
-	sp.SetInt("max_queued_disk_bytes", 10*1024*1024)
-	sp.SetInt("request_timeout", 10)
-	sp.SetInt("peer_timeout", 30)
-	sp.SetBool("strict_end_game_mode", false)
-	sp.SetBool("announce_to_all_trackers", true)
-	sp.SetBool("announce_to_all_tiers", true)
-	sp.SetBool("rate_limit_ip_overhead", false)
-
-	// 2.0.x specific: separate hashing threads
-	sp.SetInt("aio_threads", 4)
-	sp.SetInt("hashing_threads", 2)
+	sp.ApplyProfile(ProfileStreaming)
 }
@@ -0,0 +1,281 @@
+// settings_compat.go - Version-aware settings compatibility table
+//
+// Records, per setting name, the libtorrent version it was added,
+// deprecated, removed or renamed in, and its type. SettingsPack setters
+// consult the table so old configs keep working across upgrades: removed
+// settings are dropped, renamed ones are translated, and each is logged
+// once. Supporting a new libtorrent release is a change to the table below.
+
+package libtorrent
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// LibtorrentVersion is the libtorrent release these bindings target
+const LibtorrentVersion = "2.0"
+
+// SettingType is the settings_pack value type of a setting
+type SettingType int
+
+const (
+	SettingTypeBool SettingType = iota
+	SettingTypeInt
+	SettingTypeStr
+)
+
+func (t SettingType) String() string {
+	switch t {
+	case SettingTypeBool:
+		return "bool"
+	case SettingTypeInt:
+		return "int"
+	case SettingTypeStr:
+		return "string"
+	}
+	return "unknown"
+}
+
+// SettingCompat describes the history of one setting
+type SettingCompat struct {
+	Name       string
+	Type       SettingType
+	Added      string // version the setting appeared in, empty if always present
+	Deprecated string // version the setting was deprecated in
+	Removed    string // version the setting was removed in
+	RenamedTo  string // replacement name, set together with Removed
+	Note       string
+}
+
+// settingsCompatTable lists settings whose availability differs between
+// the libtorrent versions Elementum has shipped with. Settings missing from
+// the table are passed through to settings_pack unchanged.
+var settingsCompatTable = []SettingCompat{
+	// Removed in 1.2.x
+	{Name: "lazy_bitfields", Type: SettingTypeBool, Removed: "1.2"},
+	{Name: "ssl_listen", Type: SettingTypeInt, Removed: "1.2", Note: "use listen_interfaces with the s suffix"},
+	{Name: "utp_dynamic_sock_buf", Type: SettingTypeBool, Removed: "1.2"},
+	{Name: "use_dht_as_fallback", Type: SettingTypeBool, Deprecated: "1.2", Removed: "2.0"},
+	{Name: "upnp_ignore_nonrouters", Type: SettingTypeBool, Deprecated: "1.2", Removed: "2.0"},
+
+	// Disk cache removed in 2.0.x (mmap handles caching)
+	{Name: "cache_size", Type: SettingTypeInt, Removed: "2.0", Note: "OS handles caching with mmap"},
+	{Name: "cache_expiry", Type: SettingTypeInt, Removed: "2.0"},
+	{Name: "cache_buffer_chunk_size", Type: SettingTypeInt, Removed: "2.0"},
+	{Name: "read_cache_line_size", Type: SettingTypeInt, Removed: "2.0"},
+	{Name: "write_cache_line_size", Type: SettingTypeInt, Removed: "2.0"},
+	{Name: "explicit_cache_interval", Type: SettingTypeInt, Removed: "2.0"},
+	{Name: "use_read_cache", Type: SettingTypeBool, Removed: "2.0"},
+	{Name: "use_write_cache", Type: SettingTypeBool, Removed: "2.0"},
+	{Name: "lock_disk_cache", Type: SettingTypeBool, Removed: "2.0"},
+	{Name: "explicit_read_cache", Type: SettingTypeBool, Removed: "2.0"},
+	{Name: "volatile_read_cache", Type: SettingTypeBool, Removed: "2.0"},
+	{Name: "guided_read_cache", Type: SettingTypeBool, Removed: "2.0"},
+	{Name: "coalesce_reads", Type: SettingTypeBool, Removed: "2.0"},
+	{Name: "coalesce_writes", Type: SettingTypeBool, Removed: "2.0"},
+	{Name: "use_disk_read_ahead", Type: SettingTypeBool, Removed: "2.0"},
+	{Name: "allow_partial_disk_writes", Type: SettingTypeBool, Removed: "2.0"},
+
+	// Renamed in 2.0.x
+	{Name: "peer_tos", Type: SettingTypeInt, Deprecated: "2.0", Removed: "2.0", RenamedTo: "peer_dscp"},
+
+	// Added in 2.0.x
+	{Name: "hashing_threads", Type: SettingTypeInt, Added: "2.0", Note: "split from aio_threads"},
+	{Name: "peer_dscp", Type: SettingTypeInt, Added: "2.0"},
+}
+
+var settingsCompat = func() map[string]SettingCompat {
+	m := make(map[string]SettingCompat, len(settingsCompatTable))
+	for _, c := range settingsCompatTable {
+		m[c.Name] = c
+	}
+	return m
+}()
+
+// SettingsWarningf logs settings compatibility warnings.
+// Replace it to route warnings into the application logger.
+var SettingsWarningf = log.Printf
+
+var warnedSettings sync.Map
+
+// warnSettingOnce logs msg the first time it is seen for a setting
+func warnSettingOnce(name, msg string) {
+	if _, seen := warnedSettings.LoadOrStore(name+"\x00"+msg, true); seen {
+		return
+	}
+	SettingsWarningf("libtorrent: setting %s: %s", name, msg)
+}
+
+// LookupSettingCompat returns the compatibility entry for a setting
+func LookupSettingCompat(name string) (SettingCompat, bool) {
+	c, ok := settingsCompat[name]
+	return c, ok
+}
+
+// IsRemoved reports whether the setting is gone in the target version
+func (c SettingCompat) IsRemoved() bool {
+	return c.Removed != "" && compareVersions(LibtorrentVersion, c.Removed) >= 0
+}
+
+// IsDeprecated reports whether the setting is deprecated but still present
+func (c SettingCompat) IsDeprecated() bool {
+	return !c.IsRemoved() && c.Deprecated != "" && compareVersions(LibtorrentVersion, c.Deprecated) >= 0
+}
+
+// IsAvailable reports whether the setting exists in the target version
+func (c SettingCompat) IsAvailable() bool {
+	if c.Added != "" && compareVersions(LibtorrentVersion, c.Added) < 0 {
+		return false
+	}
+	return !c.IsRemoved()
+}
+
+// translateSetting maps a setting name to the name used by the target
+// libtorrent version. It returns false when the setting has no
+// replacement and should be dropped, logging the reason once.
+func translateSetting(name string) (string, bool) {
+	c, ok := settingsCompat[name]
+	if !ok {
+		return name, true
+	}
+
+	switch {
+	case c.IsRemoved() && c.RenamedTo != "":
+		warnSettingOnce(name, fmt.Sprintf("renamed to %s in libtorrent %s", c.RenamedTo, c.Removed))
+		return c.RenamedTo, true
+	case c.IsRemoved():
+		warnSettingOnce(name, fmt.Sprintf("removed in libtorrent %s, ignoring%s", c.Removed, noteSuffix(c)))
+		return "", false
+	case c.IsDeprecated():
+		warnSettingOnce(name, fmt.Sprintf("deprecated since libtorrent %s", c.Deprecated))
+	case !c.IsAvailable():
+		warnSettingOnce(name, fmt.Sprintf("not available before libtorrent %s, ignoring", c.Added))
+		return "", false
+	}
+	return name, true
+}
+
+func noteSuffix(c SettingCompat) string {
+	if c.Note == "" {
+		return ""
+	}
+	return " (" + c.Note + ")"
+}
+
+// SettingIssue is one problem found by ValidateSettings
+type SettingIssue struct {
+	Name    string
+	Level   string // "error" or "warning"
+	Message string
+}
+
+func (i SettingIssue) String() string {
+	return fmt.Sprintf("%s: %s: %s", i.Level, i.Name, i.Message)
+}
+
+// SettingsReport is the result of validating a whole config
+type SettingsReport struct {
+	Issues []SettingIssue
+}
+
+// OK reports whether the config has no errors. Warnings are allowed.
+func (r *SettingsReport) OK() bool {
+	for _, issue := range r.Issues {
+		if issue.Level == "error" {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *SettingsReport) add(name, level, format string, args ...interface{}) {
+	r.Issues = append(r.Issues, SettingIssue{Name: name, Level: level, Message: fmt.Sprintf(format, args...)})
+}
+
+// ValidateSettings checks a config of setting names to values against the
+// compatibility table and settings_pack without applying anything. Every
+// known setting is type checked against settings_pack, listed in the
+// table or not.
+// Values may be bool, any integer type or string; float64 is accepted as
+// an integer so configs decoded from JSON can be checked directly.
+func ValidateSettings(config map[string]interface{}) *SettingsReport {
+	report := &SettingsReport{}
+
+	sp := NewSettingsPack()
+	defer sp.Delete()
+
+	names := make([]string, 0, len(config))
+	for name := range config {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := config[name]
+		target := name
+
+		if c, ok := settingsCompat[name]; ok {
+			switch {
+			case c.IsRemoved() && c.RenamedTo != "":
+				report.add(name, "warning", "renamed to %s in libtorrent %s", c.RenamedTo, c.Removed)
+				target = c.RenamedTo
+			case c.IsRemoved():
+				report.add(name, "warning", "removed in libtorrent %s and will be ignored%s", c.Removed, noteSuffix(c))
+				continue
+			case c.IsDeprecated():
+				report.add(name, "warning", "deprecated since libtorrent %s", c.Deprecated)
+			case !c.IsAvailable():
+				report.add(name, "error", "not available before libtorrent %s", c.Added)
+				continue
+			}
+		}
+
+		if !sp.HasSetting(target) {
+			report.add(name, "error", "unknown setting")
+			continue
+		}
+		if want, err := sp.SettingType(target); err == nil && valueType(value) != want {
+			report.add(name, "error", "expected %s value, got %T", want, value)
+		}
+	}
+	return report
+}
+
+func valueType(v interface{}) SettingType {
+	switch v.(type) {
+	case bool:
+		return SettingTypeBool
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float64:
+		return SettingTypeInt
+	case string:
+		return SettingTypeStr
+	}
+	return SettingType(-1)
+}
+
+// compareVersions compares dotted version strings numerically
+func compareVersions(a, b string) int {
+	as := strings.Split(a, ".")
+	bs := strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,363 @@
+// settings_profile.go - Named settings profiles for libtorrent 2.0.x
+//
+// A profile is a named, versioned set of settings_pack values declared as
+// data. Built-in profiles cover the common Elementum use cases; custom
+// profiles can be exported and imported as JSON and switched at runtime
+// with Session.ApplyProfile.
+
+package libtorrent
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Built-in profile names
+const (
+	ProfileStreaming = "streaming"
+	ProfileSeeding   = "seeding"
+	ProfileLowMemory = "low-memory"
+	ProfileMetered   = "metered"
+)
+
+// SettingsProfile is a named set of settings applied through SettingsPack
+type SettingsProfile struct {
+	Name        string            `json:"name"`
+	Version     int               `json:"version"`
+	Description string            `json:"description,omitempty"`
+	Bools       map[string]bool   `json:"bools,omitempty"`
+	Ints        map[string]int    `json:"ints,omitempty"`
+	Strs        map[string]string `json:"strs,omitempty"`
+}
+
+// Built-in profiles. Bump Version whenever the values change so exported
+// copies can be told apart from the current defaults.
+var builtinProfiles = []*SettingsProfile{
+	{
+		Name:        ProfileStreaming,
+		Version:     1,
+		Description: "Low latency piece delivery for video streaming",
+		Bools: map[string]bool{
+			"strict_end_game_mode":     false,
+			"announce_to_all_trackers": true,
+			"announce_to_all_tiers":    true,
+			"rate_limit_ip_overhead":   false,
+		},
+		Ints: map[string]int{
+			"connections_limit":            200,
+			"max_out_request_queue":        5000,
+			"max_peer_recv_buffer_size":    5 * 1024 * 1024,
+			"send_buffer_watermark":        10 * 1024 * 1024,
+			"send_buffer_watermark_factor": 150,
+			"send_buffer_low_watermark":    1024 * 1024,
+			"max_queued_disk_bytes":        10 * 1024 * 1024,
+			"request_timeout":              10,
+			"peer_timeout":                 30,
+			// 2.0.x specific: separate hashing threads
+			"aio_threads":     4,
+			"hashing_threads": 2,
+		},
+	},
+	{
+		Name:        ProfileSeeding,
+		Version:     1,
+		Description: "Upload throughput for long running seeds",
+		Bools: map[string]bool{
+			"announce_to_all_trackers": true,
+			"announce_to_all_tiers":    true,
+			"rate_limit_ip_overhead":   false,
+		},
+		Ints: map[string]int{
+			"connections_limit":      400,
+			"unchoke_slots_limit":    16,
+			"seed_choking_algorithm": 1, // fastest_upload
+			"send_buffer_watermark":  5 * 1024 * 1024,
+			"max_queued_disk_bytes":  4 * 1024 * 1024,
+			"peer_timeout":           120,
+			"aio_threads":            4,
+			"hashing_threads":        1,
+		},
+	},
+	{
+		Name:        ProfileLowMemory,
+		Version:     1,
+		Description: "Small buffers and queues for low-memory devices",
+		Bools: map[string]bool{
+			"strict_end_game_mode": false,
+		},
+		Ints: map[string]int{
+			"connections_limit":         50,
+			"max_out_request_queue":     500,
+			"max_peer_recv_buffer_size": 512 * 1024,
+			"send_buffer_watermark":     512 * 1024,
+			"send_buffer_low_watermark": 64 * 1024,
+			"max_queued_disk_bytes":     1024 * 1024,
+			"aio_threads":               1,
+			"hashing_threads":           1,
+		},
+	},
+	{
+		Name:        ProfileMetered,
+		Version:     1,
+		Description: "Limit background traffic on metered connections",
+		Bools: map[string]bool{
+			"announce_to_all_trackers": false,
+			"announce_to_all_tiers":    false,
+			"enable_lsd":               false,
+			"enable_upnp":              false,
+			"enable_natpmp":            false,
+			"rate_limit_ip_overhead":   true,
+		},
+		Ints: map[string]int{
+			"connections_limit":     60,
+			"upload_rate_limit":     50 * 1024,
+			"dht_upload_rate_limit": 2 * 1024,
+			"unchoke_slots_limit":   2,
+			"aio_threads":           2,
+			"hashing_threads":       1,
+		},
+	},
+}
+
+var (
+	profilesMu sync.RWMutex
+	profiles   = make(map[string]*SettingsProfile)
+)
+
+func init() {
+	for _, p := range builtinProfiles {
+		profiles[p.Name] = p
+	}
+}
+
+// IsBuiltinProfile reports whether name is one of the built-in profiles
+func IsBuiltinProfile(name string) bool {
+	for _, p := range builtinProfiles {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// GetProfile returns a copy of the named profile
+func GetProfile(name string) (*SettingsProfile, error) {
+	profilesMu.RLock()
+	defer profilesMu.RUnlock()
+
+	p, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown settings profile: %s", name)
+	}
+	return p.clone(), nil
+}
+
+// ProfileNames returns the names of all registered profiles, sorted
+func ProfileNames() []string {
+	profilesMu.RLock()
+	defer profilesMu.RUnlock()
+
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RegisterProfile adds or replaces a custom profile.
+// Built-in profiles cannot be replaced.
+func RegisterProfile(p *SettingsProfile) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	if IsBuiltinProfile(p.Name) {
+		return fmt.Errorf("cannot replace built-in settings profile: %s", p.Name)
+	}
+
+	profilesMu.Lock()
+	profiles[p.Name] = p.clone()
+	profilesMu.Unlock()
+	return nil
+}
+
+// UnregisterProfile removes a custom profile
+func UnregisterProfile(name string) error {
+	if IsBuiltinProfile(name) {
+		return fmt.Errorf("cannot remove built-in settings profile: %s", name)
+	}
+
+	profilesMu.Lock()
+	defer profilesMu.Unlock()
+	if _, ok := profiles[name]; !ok {
+		return fmt.Errorf("unknown settings profile: %s", name)
+	}
+	delete(profiles, name)
+	return nil
+}
+
+// ExportProfile returns the named profile as JSON
+func ExportProfile(name string) ([]byte, error) {
+	p, err := GetProfile(name)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(p, "", "  ")
+}
+
+// ImportProfile parses a JSON profile and registers it
+func ImportProfile(data []byte) (*SettingsProfile, error) {
+	p := &SettingsProfile{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("invalid settings profile: %w", err)
+	}
+	if err := RegisterProfile(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Validate checks that the profile has a name, a version and only
+// settings usable with the target libtorrent version
+func (p *SettingsProfile) Validate() error {
+	if p == nil || p.Name == "" {
+		return fmt.Errorf("settings profile has no name")
+	}
+	if p.Version < 1 {
+		return fmt.Errorf("settings profile %s has invalid version %d", p.Name, p.Version)
+	}
+
+	config := make(map[string]interface{})
+	for name, v := range p.Bools {
+		config[name] = v
+	}
+	for name, v := range p.Ints {
+		config[name] = v
+	}
+	for name, v := range p.Strs {
+		config[name] = v
+	}
+	for _, issue := range ValidateSettings(config).Issues {
+		if issue.Level == "error" {
+			return fmt.Errorf("settings profile %s: %s", p.Name, issue)
+		}
+	}
+	return nil
+}
+
+// ApplyTo writes the profile values into a settings pack.
+// Settings are applied in name order so results are reproducible.
+func (p *SettingsProfile) ApplyTo(sp *SettingsPack) {
+	for _, name := range sortedKeys(p.Bools) {
+		sp.SetBool(name, p.Bools[name])
+	}
+	for _, name := range sortedKeys(p.Ints) {
+		sp.SetInt(name, p.Ints[name])
+	}
+	for _, name := range sortedKeys(p.Strs) {
+		sp.SetStr(name, p.Strs[name])
+	}
+}
+
+// SettingsPack returns a new settings pack holding only the profile values.
+// The caller owns the pack and must Delete it.
+func (p *SettingsProfile) SettingsPack() *SettingsPack {
+	sp := NewSettingsPack()
+	p.ApplyTo(sp)
+	return sp
+}
+
+// SwitchTo returns a settings pack replacing profile p with next on a
+// running session. Settings p sets and next does not go back to their
+// value in base, the settings in effect without any profile; a nil base
+// means libtorrent's defaults. A base built with NewSettingsPack only holds
+// the settings set on it, so start it from DefaultSettingsPack.
+// The caller owns the pack and must Delete it.
+func (p *SettingsProfile) SwitchTo(next *SettingsProfile, base *SettingsPack) *SettingsPack {
+	if base == nil {
+		base = DefaultSettingsPack()
+		defer base.Delete()
+	}
+
+	sp := NewSettingsPack()
+	for _, name := range sortedKeys(p.Bools) {
+		if _, ok := next.Bools[name]; !ok {
+			sp.SetBool(name, base.GetBool(name))
+		}
+	}
+	for _, name := range sortedKeys(p.Ints) {
+		if _, ok := next.Ints[name]; !ok {
+			sp.SetInt(name, base.GetInt(name))
+		}
+	}
+	for _, name := range sortedKeys(p.Strs) {
+		if _, ok := next.Strs[name]; !ok {
+			sp.SetStr(name, base.GetStr(name))
+		}
+	}
+	next.ApplyTo(sp)
+	return sp
+}
+
+func (p *SettingsProfile) clone() *SettingsProfile {
+	c := *p
+	c.Bools = make(map[string]bool, len(p.Bools))
+	for k, v := range p.Bools {
+		c.Bools[k] = v
+	}
+	c.Ints = make(map[string]int, len(p.Ints))
+	for k, v := range p.Ints {
+		c.Ints[k] = v
+	}
+	c.Strs = make(map[string]string, len(p.Strs))
+	for k, v := range p.Strs {
+		c.Strs[k] = v
+	}
+	return &c
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ApplyProfile writes the named profile into the settings pack
+func (sp *SettingsPack) ApplyProfile(name string) error {
+	p, err := GetProfile(name)
+	if err != nil {
+		return err
+	}
+	p.ApplyTo(sp)
+	return nil
+}
+
+// ApplyProfile switches the running session to the named profile.
+// Settings the profile previously applied with ApplyProfile set and the
+// new one does not go back to libtorrent's defaults; other settings keep
+// their current values.
+func (s *Session) ApplyProfile(name string) error {
+	p, err := GetProfile(name)
+	if err != nil {
+		return err
+	}
+
+	var sp *SettingsPack
+	if s.profile != nil {
+		sp = s.profile.SwitchTo(p, nil)
+	} else {
+		sp = p.SettingsPack()
+	}
+	defer sp.Delete()
+
+	if err := s.ApplySettings(sp); err != nil {
+		return err
+	}
+	s.profile = p
+	return nil
+}
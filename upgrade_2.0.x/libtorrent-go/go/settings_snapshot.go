@@ -0,0 +1,121 @@
+// settings_snapshot.go - Settings snapshots and diffs
+//
+// A snapshot captures every settings_pack value as a Go map so the
+// effective settings of a live session can be compared with libtorrent's
+// defaults or with an earlier snapshot.
+
+package libtorrent
+
+import (
+	"fmt"
+	"sort"
+	"unsafe"
+
+	lt "github.com/ElementumOrg/libtorrent-go"
+)
+
+// SettingsSnapshot maps setting names to bool, int or string values
+type SettingsSnapshot map[string]interface{}
+
+// SettingChange is one setting that differs between two snapshots.
+// Old or New is nil when the setting is missing from that snapshot.
+type SettingChange struct {
+	Name string      `json:"name"`
+	Old  interface{} `json:"old"`
+	New  interface{} `json:"new"`
+}
+
+func (c SettingChange) String() string {
+	return fmt.Sprintf("%s: %v -> %v", c.Name, c.Old, c.New)
+}
+
+// AllSettingNames returns the names of every setting known to settings_pack
+func AllSettingNames() []string {
+	vec := lt.All_setting_names()
+	defer lt.DeleteStdVectorString(vec)
+
+	names := make([]string, 0, vec.Size())
+	for i := 0; i < int(vec.Size()); i++ {
+		names = append(names, vec.Get(i))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Snapshot returns every setting in the pack. Settings the pack does not
+// set explicitly report libtorrent's default value.
+func (sp *SettingsPack) Snapshot() (SettingsSnapshot, error) {
+	if sp == nil || sp.ptr == nil {
+		return nil, ErrInvalidSettingsPack
+	}
+
+	swigSettings := lt.Settings_pack(sp.ptr)
+	snapshot := make(SettingsSnapshot)
+	for _, name := range AllSettingNames() {
+		switch SettingType(swigSettings.Setting_type(name)) {
+		case SettingTypeBool:
+			snapshot[name] = swigSettings.Get_bool(name)
+		case SettingTypeInt:
+			snapshot[name] = swigSettings.Get_int(name)
+		case SettingTypeStr:
+			snapshot[name] = swigSettings.Get_str(name)
+		}
+	}
+	return snapshot, nil
+}
+
+// DefaultSettingsSnapshot returns libtorrent's stock settings
+func DefaultSettingsSnapshot() (SettingsSnapshot, error) {
+	sp := DefaultSettingsPack()
+	defer sp.Delete()
+	return sp.Snapshot()
+}
+
+// Settings returns a copy of the session's current settings.
+// The caller owns the pack and must Delete it.
+func (s *Session) Settings() (*SettingsPack, error) {
+	if s == nil || s.handle == nil {
+		return nil, fmt.Errorf("invalid session")
+	}
+	sessionHandle := (lt.Session)(s.handle)
+	return &SettingsPack{ptr: unsafe.Pointer(sessionHandle.Current_settings())}, nil
+}
+
+// SnapshotSettings returns the session's current effective settings
+func (s *Session) SnapshotSettings() (SettingsSnapshot, error) {
+	settings, err := s.Settings()
+	if err != nil {
+		return nil, err
+	}
+	defer settings.Delete()
+	return settings.Snapshot()
+}
+
+// Diff returns the settings whose values differ from base, sorted by name.
+// Old holds the base value and New the value in this snapshot.
+func (snap SettingsSnapshot) Diff(base SettingsSnapshot) []SettingChange {
+	var changes []SettingChange
+	for name, value := range snap {
+		old, ok := base[name]
+		if !ok || old != value {
+			changes = append(changes, SettingChange{Name: name, Old: old, New: value})
+		}
+	}
+	for name, old := range base {
+		if _, ok := snap[name]; !ok {
+			changes = append(changes, SettingChange{Name: name, Old: old})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+	return changes
+}
+
+// DiffFromDefaults returns the settings that differ from libtorrent's defaults
+func (snap SettingsSnapshot) DiffFromDefaults() ([]SettingChange, error) {
+	defaults, err := DefaultSettingsSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return snap.Diff(defaults), nil
+}
@@ -0,0 +1,279 @@
+// settings_typed.go - Typed settings_pack accessors with errors
+//
+// SetBool/GetBool and friends on SettingsPack never fail; the accessors
+// here report why a setting could not be read or written. Errors are
+// *SettingError values and match ErrUnknownSetting, ErrSettingType,
+// ErrSettingRemoved or ErrInvalidSettingsPack with errors.Is.
+
+package libtorrent
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	lt "github.com/ElementumOrg/libtorrent-go"
+)
+
+// Setting error kinds
+var (
+	ErrUnknownSetting      = errors.New("unknown setting")
+	ErrSettingType         = errors.New("setting type mismatch")
+	ErrSettingRemoved      = errors.New("setting removed")
+	ErrInvalidSettingsPack = errors.New("invalid settings_pack")
+)
+
+// SettingError describes a failed typed settings access
+type SettingError struct {
+	Name string
+	Kind error // one of the Err* setting kinds above
+	// Expected and Got are set for ErrSettingType
+	Expected SettingType
+	Got      string
+	// Version is set for ErrSettingRemoved
+	Version string
+}
+
+func (e *SettingError) Error() string {
+	switch e.Kind {
+	case ErrSettingType:
+		return fmt.Sprintf("setting %s: expected %s value, got %s", e.Name, e.Expected, e.Got)
+	case ErrSettingRemoved:
+		return fmt.Sprintf("setting %s: removed in libtorrent %s", e.Name, e.Version)
+	}
+	return fmt.Sprintf("setting %s: %v", e.Name, e.Kind)
+}
+
+// Unwrap lets errors.Is match the error kind
+func (e *SettingError) Unwrap() error {
+	return e.Kind
+}
+
+// SettingErrors collects every failure from ApplyMap
+type SettingErrors []*SettingError
+
+func (errs SettingErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d settings failed: %s", len(errs), strings.Join(msgs, "; "))
+}
+
+// SettingType returns the value type of a setting, resolving renamed
+// settings to the name used by the target libtorrent version
+func (sp *SettingsPack) SettingType(name string) (SettingType, error) {
+	_, typ, err := sp.resolveSetting(name)
+	return typ, err
+}
+
+// resolveSetting translates a setting name through the compatibility
+// table and looks up its type in settings_pack
+func (sp *SettingsPack) resolveSetting(name string) (string, SettingType, error) {
+	if sp == nil || sp.ptr == nil {
+		return "", 0, &SettingError{Name: name, Kind: ErrInvalidSettingsPack}
+	}
+
+	target, ok := translateSetting(name)
+	if !ok {
+		c, _ := LookupSettingCompat(name)
+		if c.IsRemoved() {
+			return "", 0, &SettingError{Name: name, Kind: ErrSettingRemoved, Version: c.Removed}
+		}
+		return "", 0, &SettingError{Name: name, Kind: ErrUnknownSetting}
+	}
+
+	typ := lt.Settings_pack(sp.ptr).Setting_type(target)
+	if typ < 0 {
+		return "", 0, &SettingError{Name: name, Kind: ErrUnknownSetting}
+	}
+	return target, SettingType(typ), nil
+}
+
+// checkSetting resolves a setting and checks it holds values of type want
+func (sp *SettingsPack) checkSetting(name string, want SettingType) (string, error) {
+	target, typ, err := sp.resolveSetting(name)
+	if err != nil {
+		return "", err
+	}
+	if typ != want {
+		return "", &SettingError{Name: name, Kind: ErrSettingType, Expected: typ, Got: want.String()}
+	}
+	return target, nil
+}
+
+// BoolValue returns a boolean setting
+func (sp *SettingsPack) BoolValue(name string) (bool, error) {
+	target, err := sp.checkSetting(name, SettingTypeBool)
+	if err != nil {
+		return false, err
+	}
+	return lt.Settings_pack(sp.ptr).Get_bool(target), nil
+}
+
+// IntValue returns an integer setting
+func (sp *SettingsPack) IntValue(name string) (int, error) {
+	target, err := sp.checkSetting(name, SettingTypeInt)
+	if err != nil {
+		return 0, err
+	}
+	return lt.Settings_pack(sp.ptr).Get_int(target), nil
+}
+
+// StrValue returns a string setting
+func (sp *SettingsPack) StrValue(name string) (string, error) {
+	target, err := sp.checkSetting(name, SettingTypeStr)
+	if err != nil {
+		return "", err
+	}
+	return lt.Settings_pack(sp.ptr).Get_str(target), nil
+}
+
+// SetBoolValue sets a boolean setting
+func (sp *SettingsPack) SetBoolValue(name string, value bool) error {
+	target, err := sp.checkSetting(name, SettingTypeBool)
+	if err != nil {
+		return err
+	}
+	lt.Settings_pack(sp.ptr).Set_bool(target, value)
+	return nil
+}
+
+// SetIntValue sets an integer setting
+func (sp *SettingsPack) SetIntValue(name string, value int) error {
+	target, err := sp.checkSetting(name, SettingTypeInt)
+	if err != nil {
+		return err
+	}
+	lt.Settings_pack(sp.ptr).Set_int(target, value)
+	return nil
+}
+
+// SetStrValue sets a string setting
+func (sp *SettingsPack) SetStrValue(name string, value string) error {
+	target, err := sp.checkSetting(name, SettingTypeStr)
+	if err != nil {
+		return err
+	}
+	lt.Settings_pack(sp.ptr).Set_str(target, value)
+	return nil
+}
+
+// ApplyMap sets every setting in values and returns SettingErrors listing
+// each one that failed; the others are still applied. Values may be bool,
+// integers, whole float64 (as decoded from JSON) or strings. Strings are
+// parsed for bool and int settings, since the Kodi addon passes all
+// settings as text.
+func (sp *SettingsPack) ApplyMap(values map[string]any) error {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs SettingErrors
+	for _, name := range names {
+		if err := sp.applyValue(name, values[name]); err != nil {
+			var serr *SettingError
+			if !errors.As(err, &serr) {
+				serr = &SettingError{Name: name, Kind: err}
+			}
+			errs = append(errs, serr)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (sp *SettingsPack) applyValue(name string, value any) error {
+	_, typ, err := sp.resolveSetting(name)
+	if err != nil {
+		return err
+	}
+
+	mismatch := &SettingError{Name: name, Kind: ErrSettingType, Expected: typ, Got: fmt.Sprintf("%T", value)}
+
+	switch typ {
+	case SettingTypeBool:
+		switch v := value.(type) {
+		case bool:
+			return sp.SetBoolValue(name, v)
+		case string:
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				mismatch.Got = strconv.Quote(v)
+				return mismatch
+			}
+			return sp.SetBoolValue(name, b)
+		}
+	case SettingTypeInt:
+		if n, ok := toSettingInt(value); ok {
+			return sp.SetIntValue(name, n)
+		}
+		if s, ok := value.(string); ok {
+			mismatch.Got = strconv.Quote(s)
+		}
+	case SettingTypeStr:
+		if s, ok := value.(string); ok {
+			return sp.SetStrValue(name, s)
+		}
+	}
+	return mismatch
+}
+
+// toSettingInt converts a Go value to a settings_pack int (32 bit)
+func toSettingInt(value any) (int, bool) {
+	var n int64
+	switch v := value.(type) {
+	case int:
+		n = int64(v)
+	case int8:
+		n = int64(v)
+	case int16:
+		n = int64(v)
+	case int32:
+		n = int64(v)
+	case int64:
+		n = v
+	case uint8:
+		n = int64(v)
+	case uint16:
+		n = int64(v)
+	case uint32:
+		n = int64(v)
+	case uint:
+		if uint64(v) > math.MaxInt32 {
+			return 0, false
+		}
+		n = int64(v)
+	case uint64:
+		if v > math.MaxInt32 {
+			return 0, false
+		}
+		n = int64(v)
+	case float64:
+		if v != math.Trunc(v) {
+			return 0, false
+		}
+		n = int64(v)
+	case string:
+		parsed, err := strconv.ParseInt(strings.TrimSpace(v), 10, 32)
+		if err != nil {
+			return 0, false
+		}
+		n = parsed
+	default:
+		return 0, false
+	}
+
+	if n < math.MinInt32 || n > math.MaxInt32 {
+		return 0, false
+	}
+	return int(n), true
+}
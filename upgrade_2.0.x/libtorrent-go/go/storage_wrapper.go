@@ -8,6 +8,7 @@
 package libtorrent
 
 import (
+	"fmt"
 	"sync"
 
 	lt "github.com/ElementumOrg/libtorrent-go"
@@ -63,6 +64,55 @@ func (md *MemoryDiskIO) GetStorageIndex(infoHashV1 string) StorageIndex {
 	return InvalidStorageIndex
 }
 
+// FindStorageIndex returns the storage of a torrent by its v1 info hash,
+// or InvalidStorageIndex until the session created one. Unlike the index
+// predicted before add_torrent, it is read from memory disk I/O itself.
+func FindStorageIndex(infoHashV1 string) StorageIndex {
+	return StorageIndex(lt.MemoryDiskFindStorage(infoHashV1))
+}
+
+// StorageLimits holds the buffer limits of one storage after a memory
+// budget change
+type StorageLimits struct {
+	StorageIndex StorageIndex
+	PieceLength  int
+	BufferLimit  int // Maximum pieces kept in memory
+	BufferUsed   int // Pieces currently in memory
+}
+
+// SetMemorySize changes the memory budget of all live storages without
+// restarting the session. Storages evict least recently used pieces when
+// the budget shrinks. Returns the recomputed limits per storage. The size
+// must be positive; 0, unlimited, is only valid when the session starts.
+func (md *MemoryDiskIO) SetMemorySize(memorySize int64) ([]StorageLimits, error) {
+	if memorySize <= 0 {
+		return nil, fmt.Errorf("invalid memory size: %d", memorySize)
+	}
+	return storageLimits(lt.MemoryDiskSetMemorySize(memorySize)), nil
+}
+
+// StorageLimits returns the current buffer limits of every live storage
+func (md *MemoryDiskIO) StorageLimits() []StorageLimits {
+	return storageLimits(lt.MemoryDiskGetStorageLimits())
+}
+
+// storageLimits converts and frees a SWIG vector of storage limits
+func storageLimits(swigLimits lt.StdVectorMemoryStorageLimits) []StorageLimits {
+	defer lt.DeleteStdVectorMemoryStorageLimits(swigLimits)
+
+	limits := make([]StorageLimits, 0, swigLimits.Size())
+	for i := 0; i < int(swigLimits.Size()); i++ {
+		l := swigLimits.Get(i)
+		limits = append(limits, StorageLimits{
+			StorageIndex: StorageIndex(l.GetStorage_index()),
+			PieceLength:  l.GetPiece_length(),
+			BufferLimit:  l.GetBuffer_limit(),
+			BufferUsed:   l.GetBuffer_used(),
+		})
+	}
+	return limits
+}
+
 // Lookbehind buffer operations
 // These call into the global memory_disk_io instance via SWIG wrappers
 
@@ -98,6 +148,49 @@ func IsLookbehindAvailable(storageIndex StorageIndex, piece int) bool {
 	return lt.MemoryDiskIsLookbehindAvailable(int(storageIndex), piece)
 }
 
+// Reader read-ahead protection
+// Pieces inside any active reader's range are never evicted by trim, so
+// concurrent downloads cannot undo forward buffering
+
+// SetReaderRange declares the pieces [firstPiece, lastPiece] a reader is
+// about to consume. Each torrent can have several readers, identified by
+// readerID. Calling it again replaces that reader's previous range.
+func SetReaderRange(storageIndex StorageIndex, readerID, firstPiece, lastPiece int) {
+	if storageIndex == InvalidStorageIndex {
+		return
+	}
+	lt.MemoryDiskSetReaderRange(int(storageIndex), readerID, firstPiece, lastPiece)
+}
+
+// RemoveReader drops a reader's range, making its pieces evictable again
+func RemoveReader(storageIndex StorageIndex, readerID int) {
+	if storageIndex == InvalidStorageIndex {
+		return
+	}
+	lt.MemoryDiskRemoveReader(int(storageIndex), readerID)
+}
+
+// ReadPieceData copies up to size bytes of a piece, starting at offset, out
+// of memory. ok is false if the piece is not held in memory.
+func ReadPieceData(storageIndex StorageIndex, piece, offset, size int) (data []byte, ok bool) {
+	if storageIndex == InvalidStorageIndex {
+		return nil, false
+	}
+	buf := lt.MemoryDiskRead(int(storageIndex), piece, offset, size)
+	if len(buf) == 0 {
+		return nil, false
+	}
+	return []byte(buf), true
+}
+
+// HasPieceData reports whether a piece's data is held in memory
+func HasPieceData(storageIndex StorageIndex, piece int) bool {
+	if storageIndex == InvalidStorageIndex {
+		return false
+	}
+	return lt.MemoryDiskHasPiece(int(storageIndex), piece)
+}
+
 // LookbehindStats holds lookbehind buffer statistics
 type LookbehindStats struct {
 	Available      int   // Number of pieces available
@@ -122,6 +215,56 @@ func GetLookbehindStats(storageIndex StorageIndex) LookbehindStats {
 	}
 }
 
+// CacheStats holds piece cache accounting for one storage
+type CacheStats struct {
+	ReadHits            int64 // Reads served from memory
+	ReadMisses          int64 // Reads that hit EOF
+	ReadMissesEvicted   int64 // Misses on pieces that had been evicted
+	BytesServed         int64 // Bytes returned by reads
+	EvictionsLRU        int64 // Pieces dropped to make room for new ones
+	EvictionsCapacity   int64 // Pieces dropped because the memory budget shrank
+	EvictionsClearPiece int64 // Pieces cleared by libtorrent
+}
+
+// HitRatio returns the fraction of reads served from memory
+func (cs CacheStats) HitRatio() float64 {
+	total := cs.ReadHits + cs.ReadMisses
+	if total == 0 {
+		return 0
+	}
+	return float64(cs.ReadHits) / float64(total)
+}
+
+// GetCacheStats returns piece cache accounting for a torrent's storage
+func GetCacheStats(storageIndex StorageIndex) CacheStats {
+	if storageIndex == InvalidStorageIndex {
+		return CacheStats{}
+	}
+
+	swigStats := lt.MemoryDiskGetCacheStats(int(storageIndex))
+	defer lt.DeleteMemoryStorageCacheStats(swigStats)
+
+	return CacheStats{
+		ReadHits:            swigStats.GetRead_hits(),
+		ReadMisses:          swigStats.GetRead_misses(),
+		ReadMissesEvicted:   swigStats.GetRead_misses_evicted(),
+		BytesServed:         swigStats.GetBytes_served(),
+		EvictionsLRU:        swigStats.GetEvictions_lru(),
+		EvictionsCapacity:   swigStats.GetEvictions_capacity(),
+		EvictionsClearPiece: swigStats.GetEvictions_clear_piece(),
+	}
+}
+
+// GetCacheStats returns piece cache accounting for a torrent by info hash
+func (md *MemoryDiskIO) GetCacheStats(infoHashV1 string) CacheStats {
+	return GetCacheStats(md.GetStorageIndex(infoHashV1))
+}
+
+// GetLookbehindStats returns lookbehind statistics for a torrent by info hash
+func (md *MemoryDiskIO) GetLookbehindStats(infoHashV1 string) LookbehindStats {
+	return GetLookbehindStats(md.GetStorageIndex(infoHashV1))
+}
+
 // TorrentStorage provides a torrent-specific interface to storage operations
 // This is a convenience wrapper that holds the storage index
 type TorrentStorage struct {
@@ -152,11 +295,26 @@ func (ts *TorrentStorage) IsLookbehindAvailable(piece int) bool {
 	return IsLookbehindAvailable(ts.storageIndex, piece)
 }
 
+// SetReaderRange declares the read-ahead range of a reader on this torrent
+func (ts *TorrentStorage) SetReaderRange(readerID, firstPiece, lastPiece int) {
+	SetReaderRange(ts.storageIndex, readerID, firstPiece, lastPiece)
+}
+
+// RemoveReader drops a reader's read-ahead range on this torrent
+func (ts *TorrentStorage) RemoveReader(readerID int) {
+	RemoveReader(ts.storageIndex, readerID)
+}
+
 // GetStats returns lookbehind stats for this torrent
 func (ts *TorrentStorage) GetStats() LookbehindStats {
 	return GetLookbehindStats(ts.storageIndex)
 }
 
+// GetCacheStats returns piece cache accounting for this torrent
+func (ts *TorrentStorage) GetCacheStats() CacheStats {
+	return GetCacheStats(ts.storageIndex)
+}
+
 // StorageIndex returns the storage index
 func (ts *TorrentStorage) StorageIndex() StorageIndex {
 	return ts.storageIndex
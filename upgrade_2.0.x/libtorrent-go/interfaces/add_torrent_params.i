@@ -15,6 +15,7 @@
 #include <libtorrent/magnet_uri.hpp>
 #include <libtorrent/read_resume_data.hpp>
 #include <libtorrent/write_resume_data.hpp>
+#include <libtorrent/bdecode.hpp>
 #include <libtorrent/info_hash.hpp>
 #include <libtorrent/error_code.hpp>
 %}
@@ -45,6 +46,25 @@ libtorrent::add_torrent_params parse_magnet_uri(std::string const& uri) {
     return params;
 }
 
+// Parse resume data, throwing on failure like parse_magnet_uri
+libtorrent::add_torrent_params read_resume_data_buf(std::vector<char> const& buf) {
+    libtorrent::error_code ec;
+    libtorrent::add_torrent_params params = libtorrent::read_resume_data(buf, ec);
+    if (ec) {
+        throw std::runtime_error("Failed to read resume data: " + ec.message());
+    }
+    return params;
+}
+
+// Elementum metadata stored in resume data under the "elementum" key,
+// or an empty string if there is none
+std::string read_resume_metadata(std::vector<char> const& buf) {
+    libtorrent::error_code ec;
+    libtorrent::bdecode_node rd = libtorrent::bdecode(buf, ec);
+    if (ec || rd.type() != libtorrent::bdecode_node::dict_t) return "";
+    return std::string(rd.dict_find_string_value("elementum"));
+}
+
 // Alternative version that also returns the error message for inspection
 // Returns empty params with default-constructed info_hashes on error
 libtorrent::add_torrent_params parse_magnet_uri_with_error(std::string const& uri, std::string& error_out) {
@@ -68,6 +88,21 @@ libtorrent::add_torrent_params parse_magnet_uri_with_error(std::string const& ur
         self->ti = std::make_shared<libtorrent::torrent_info>(torrent_info);
     }
 
+    // Join the swarm without downloading payload: upload mode keeps the
+    // torrent from requesting pieces while ut_metadata still runs. Paused
+    // torrents would not connect to peers at all.
+    void set_metadata_only() {
+        self->flags |= libtorrent::torrent_flags::upload_mode;
+        self->flags &= ~(libtorrent::torrent_flags::paused
+            | libtorrent::torrent_flags::auto_managed);
+    }
+
+    // Leave pausing and resuming to the caller: libtorrent's auto-manager
+    // would otherwise resume torrents the service queue paused
+    void unset_auto_managed() {
+        self->flags &= ~libtorrent::torrent_flags::auto_managed;
+    }
+
     // Note: In 2.0.x, storage is configured at session level via session_params
     // The storage field no longer exists in add_torrent_params
 
@@ -91,6 +126,12 @@ libtorrent::add_torrent_params parse_magnet_uri_with_error(std::string const& ur
         return libtorrent::aux::to_hex(self->info_hashes.v1);
     }
 
+    // Full SHA-256 v2 hash as hex, empty if the params have no v2 hash
+    std::string get_info_hash_v2_hex() const {
+        if (!self->info_hashes.has_v2()) return "";
+        return libtorrent::aux::to_hex(self->info_hashes.v2);
+    }
+
     bool has_v1() const {
         return self->info_hashes.has_v1();
     }
@@ -11,6 +11,8 @@
 %{
 #include <libtorrent/alert.hpp>
 #include <libtorrent/alert_types.hpp>
+#include <libtorrent/bencode.hpp>
+#include <libtorrent/write_resume_data.hpp>
 %}
 
 // Alert vector (already defined in session.i)
@@ -189,6 +191,23 @@ namespace libtorrent {
     std::vector<char> get_resume_data_buf() const {
         return libtorrent::write_resume_data_buf(self->params);
     }
+
+    // Resume data with Elementum metadata (category, labels) stored under
+    // the "elementum" key. libtorrent ignores unknown keys when reading.
+    std::vector<char> get_resume_data_buf_with_metadata(std::string const& metadata) const {
+        libtorrent::entry e = libtorrent::write_resume_data(self->params);
+        e["elementum"] = metadata;
+        std::vector<char> buf;
+        libtorrent::bencode(std::back_inserter(buf), e);
+        return buf;
+    }
+}
+
+// Metadata failed alert (invalid metadata from a peer)
+%extend libtorrent::metadata_failed_alert {
+    std::string get_error_message() const {
+        return self->error.message();
+    }
 }
 
 // Tracker alerts
@@ -240,5 +259,8 @@ namespace libtorrent {
     const int ALERT_DHT_ERROR = dht_error_alert::alert_type;
     const int ALERT_EXTERNAL_IP = external_ip_alert::alert_type;
     const int ALERT_PERFORMANCE = performance_alert::alert_type;
+    const int ALERT_METADATA_RECEIVED = metadata_received_alert::alert_type;
+    const int ALERT_METADATA_FAILED = metadata_failed_alert::alert_type;
+    const int ALERT_STORAGE_MOVED = storage_moved_alert::alert_type;
 }
 %}
@@ -7,6 +7,7 @@
 
 %{
 #include <libtorrent/disk_interface.hpp>
+#include <libtorrent/hex.hpp>
 #include "memory_disk_io.hpp"
 %}
 
@@ -59,6 +60,23 @@ namespace libtorrent {
         }
     }
 
+    // Reader read-ahead ranges, protected from eviction
+    void memory_disk_set_reader_range(int storage_index, int reader,
+        int first_piece, int last_piece) {
+        std::lock_guard<std::mutex> lock(g_memory_disk_io_mutex);
+        if (g_memory_disk_io) {
+            g_memory_disk_io->set_reader_range(
+                storage_index_t(storage_index), reader, first_piece, last_piece);
+        }
+    }
+
+    void memory_disk_remove_reader(int storage_index, int reader) {
+        std::lock_guard<std::mutex> lock(g_memory_disk_io_mutex);
+        if (g_memory_disk_io) {
+            g_memory_disk_io->remove_reader(storage_index_t(storage_index), reader);
+        }
+    }
+
     bool memory_disk_is_lookbehind_available(int storage_index, int piece) {
         std::lock_guard<std::mutex> lock(g_memory_disk_io_mutex);
         if (g_memory_disk_io) {
@@ -68,6 +86,26 @@ namespace libtorrent {
         return false;
     }
 
+    // Streaming reads of piece data held in memory
+    std::string memory_disk_read(int storage_index, int piece,
+        int offset, int size) {
+        std::lock_guard<std::mutex> lock(g_memory_disk_io_mutex);
+        if (g_memory_disk_io) {
+            return g_memory_disk_io->read_piece_data(
+                storage_index_t(storage_index), piece, offset, size);
+        }
+        return {};
+    }
+
+    bool memory_disk_has_piece(int storage_index, int piece) {
+        std::lock_guard<std::mutex> lock(g_memory_disk_io_mutex);
+        if (g_memory_disk_io) {
+            return g_memory_disk_io->has_piece_data(
+                storage_index_t(storage_index), piece);
+        }
+        return false;
+    }
+
     void memory_disk_get_lookbehind_stats(int storage_index,
         int& available, int& protected_count, std::int64_t& memory) {
         std::lock_guard<std::mutex> lock(g_memory_disk_io_mutex);
@@ -84,6 +122,324 @@ namespace libtorrent {
 }
 %}
 
+// ============================================================================
+// Cache accounting
+// ============================================================================
+
+namespace libtorrent {
+    struct memory_storage_cache_stats {
+        std::int64_t read_hits;
+        std::int64_t read_misses;
+        std::int64_t read_misses_evicted;
+        std::int64_t bytes_served;
+        std::int64_t evictions_lru;
+        std::int64_t evictions_capacity;
+        std::int64_t evictions_clear_piece;
+    };
+}
+
+%inline %{
+namespace libtorrent {
+    memory_storage_cache_stats memory_disk_get_cache_stats(int storage_index) {
+        std::lock_guard<std::mutex> lock(g_memory_disk_io_mutex);
+        if (g_memory_disk_io) {
+            return g_memory_disk_io->get_cache_stats(storage_index_t(storage_index));
+        }
+        return {};
+    }
+}
+%}
+
+// ============================================================================
+// Runtime memory budget
+// ============================================================================
+
+namespace libtorrent {
+    struct memory_storage_limits {
+        int storage_index;
+        int piece_length;
+        int buffer_limit;
+        int buffer_used;
+    };
+}
+
+%template(StdVectorMemoryStorageLimits) std::vector<libtorrent::memory_storage_limits>;
+
+%inline %{
+namespace libtorrent {
+    // Resize the memory budget of all live storages.
+    // Returns the recomputed limits; empty if memory disk I/O is not active.
+    std::vector<memory_storage_limits> memory_disk_set_memory_size(std::int64_t memory_size) {
+        std::lock_guard<std::mutex> lock(g_memory_disk_io_mutex);
+        // Also the budget of the next session's memory_disk_io
+        memory_disk_memory_size.store(memory_size);
+        if (g_memory_disk_io) {
+            return g_memory_disk_io->set_memory_size(memory_size);
+        }
+        return {};
+    }
+
+    // Buffer limits of all live storages; empty if memory disk I/O is not
+    // active
+    std::vector<memory_storage_limits> memory_disk_get_storage_limits() {
+        std::lock_guard<std::mutex> lock(g_memory_disk_io_mutex);
+        if (g_memory_disk_io) {
+            return g_memory_disk_io->get_storage_limits();
+        }
+        return {};
+    }
+}
+%}
+
+// ============================================================================
+// Read path benchmark
+// ============================================================================
+//
+// Compares the zero-copy async_read path against the previous behaviour of
+// copying every block into a freshly allocated buffer. Runs on a standalone
+// memory_disk_io so it needs no session or peers. Both paths post their
+// handler to the same io_context with a disk_buffer_holder, so dispatch
+// costs the same on both sides; the allocation counts are the buffers each
+// path allocates per block: one new[] per block when copying, one pin table
+// entry per piece for zero-copy (memory_disk_io::get_read_allocations).
+
+// The copy path's allocator stays out of the Go API
+%{
+#include <chrono>
+#include <cstring>
+#include <libtorrent/file_storage.hpp>
+
+namespace libtorrent {
+    // Frees the blocks the copy path hands out, as the old async_read did
+    struct copy_buffer_allocator : buffer_allocator_interface
+    {
+        void free_disk_buffer(char* buf) override { delete[] buf; }
+    };
+}
+%}
+
+%inline %{
+namespace libtorrent {
+    struct memory_disk_read_benchmark {
+        std::int64_t bytes_read;
+        std::int64_t copy_ns;
+        std::int64_t copy_allocations;
+        std::int64_t zero_copy_ns;
+        std::int64_t zero_copy_allocations;
+    };
+
+    memory_disk_read_benchmark memory_disk_benchmark_read(
+        int piece_length, int num_pieces, int rounds)
+    {
+        memory_disk_read_benchmark result{};
+        int const block_size = 0x4000;
+
+        file_storage fs;
+        fs.set_piece_length(piece_length);
+        fs.add_file("bench/video.mkv",
+            static_cast<std::int64_t>(piece_length) * num_pieces);
+        fs.set_num_pieces(num_pieces);
+
+        aux::vector<download_priority_t, file_index_t> prio;
+        storage_params params(fs, nullptr, "", storage_mode_sparse, prio,
+            sha1_hash());
+
+        io_context ioc;
+        settings_pack settings;
+        memory_disk_io dio(ioc, settings, 0);
+        storage_holder holder = dio.new_torrent(params, nullptr);
+        storage_index_t const idx = holder.index();
+
+        // Fill every piece
+        std::vector<char> block(block_size, 'x');
+        for (int p = 0; p < num_pieces; ++p)
+        {
+            for (int off = 0; off < piece_length; off += block_size)
+            {
+                peer_request r;
+                r.piece = piece_index_t(p);
+                r.start = off;
+                r.length = block_size;
+                dio.async_write(idx, r, block.data(), nullptr,
+                    [](storage_error const&) {}, {});
+            }
+        }
+        ioc.run();
+        ioc.restart();
+
+        // Both paths count the bytes handed to the same handler
+        std::int64_t bytes = 0;
+        std::function<void(disk_buffer_holder, storage_error const&)> const
+            handler = [&bytes](disk_buffer_holder h, storage_error const&)
+            { bytes += h.size(); };
+
+        // Zero-copy: real async_read path, buffers released by the holder
+        std::int64_t const pins = dio.get_read_allocations();
+        auto start = std::chrono::steady_clock::now();
+        {
+            for (int round = 0; round < rounds; ++round)
+            {
+                for (int p = 0; p < num_pieces; ++p)
+                {
+                    for (int off = 0; off < piece_length; off += block_size)
+                    {
+                        peer_request r;
+                        r.piece = piece_index_t(p);
+                        r.start = off;
+                        r.length = block_size;
+                        dio.async_read(idx, r, handler, {});
+                    }
+                }
+                ioc.run();
+                ioc.restart();
+            }
+        }
+        result.zero_copy_allocations = dio.get_read_allocations() - pins;
+        result.zero_copy_ns = std::chrono::duration_cast<std::chrono::nanoseconds>(
+            std::chrono::steady_clock::now() - start).count();
+        result.bytes_read = bytes;
+        bytes = 0;
+
+        // Copy: look the block up under the storage mutex, copy it into a
+        // new buffer and post the handler, as async_read used to
+        memory_storage storage(params, 0);
+        for (int p = 0; p < num_pieces; ++p)
+            storage.writev(block, piece_index_t(p), 0);
+        std::mutex storage_mutex;
+        copy_buffer_allocator allocator;
+
+        std::int64_t copies = 0;
+        start = std::chrono::steady_clock::now();
+        {
+            for (int round = 0; round < rounds; ++round)
+            {
+                for (int p = 0; p < num_pieces; ++p)
+                {
+                    for (int off = 0; off < piece_length; off += block_size)
+                    {
+                        peer_request r;
+                        r.piece = piece_index_t(p);
+                        r.start = off;
+                        r.length = block_size;
+
+                        storage_error error;
+                        char* buf = nullptr;
+                        int buf_size = 0;
+                        {
+                            std::lock_guard<std::mutex> lock(storage_mutex);
+                            span<char const> data = storage.readv(r, error);
+                            buf_size = static_cast<int>(data.size());
+                            buf = new char[buf_size];
+                            ++copies;
+                            std::memcpy(buf, data.data(), data.size());
+                        }
+                        post(ioc, [handler, error, buf, buf_size, &allocator]
+                        {
+                            handler(disk_buffer_holder(allocator, buf, buf_size), error);
+                        });
+                    }
+                }
+                ioc.run();
+                ioc.restart();
+            }
+        }
+        result.copy_allocations = copies;
+        result.copy_ns = std::chrono::duration_cast<std::chrono::nanoseconds>(
+            std::chrono::steady_clock::now() - start).count();
+
+        // Both paths must have served the same data for the times to compare
+        if (bytes != result.bytes_read) result.bytes_read = -1;
+        return result;
+    }
+}
+%}
+
+// ============================================================================
+// Eviction benchmarks
+// ============================================================================
+//
+// Exercise memory_storage directly on large torrents: the write path once
+// the buffer is full (every new piece evicts one), and the lookbehind
+// available counter. Both return elapsed nanoseconds.
+
+%inline %{
+namespace libtorrent {
+    struct memory_storage_bench_files {
+        file_storage fs;
+        aux::vector<download_priority_t, file_index_t> prio;
+
+        memory_storage_bench_files(int piece_length, int num_pieces) {
+            fs.set_piece_length(piece_length);
+            fs.add_file("bench/video.mkv",
+                static_cast<std::int64_t>(piece_length) * num_pieces);
+            fs.set_num_pieces(num_pieces);
+        }
+
+        storage_params params() {
+            return storage_params(fs, nullptr, "", storage_mode_sparse, prio,
+                sha1_hash());
+        }
+    };
+
+    // Write every piece once with a buffer of buffer_pieces and a lookbehind
+    // window of lookbehind_pieces trailing the write position
+    std::int64_t memory_storage_benchmark_writes(int num_pieces,
+        int piece_length, int buffer_pieces, int lookbehind_pieces)
+    {
+        memory_storage_bench_files files(piece_length, num_pieces);
+        memory_storage storage(files.params(),
+            static_cast<std::int64_t>(buffer_pieces - 2) * piece_length);
+
+        std::vector<char> block(piece_length, 'x');
+        std::vector<int> window;
+
+        auto const start = std::chrono::steady_clock::now();
+        for (int p = 0; p < num_pieces; ++p)
+        {
+            if (p % 64 == 0)
+            {
+                window.clear();
+                for (int w = std::max(0, p - lookbehind_pieces); w < p; ++w)
+                    window.push_back(w);
+                storage.set_lookbehind_pieces(window);
+            }
+            storage.writev(block, piece_index_t(p), 0);
+        }
+        return std::chrono::duration_cast<std::chrono::nanoseconds>(
+            std::chrono::steady_clock::now() - start).count();
+    }
+
+    // Query the lookbehind available count calls times
+    std::int64_t memory_storage_benchmark_lookbehind_count(int num_pieces,
+        int piece_length, int lookbehind_pieces, int calls)
+    {
+        memory_storage_bench_files files(piece_length, num_pieces);
+        memory_storage storage(files.params(), 0);
+
+        // Only the start of the torrent is in memory; the cost of counting
+        // must not depend on num_pieces
+        std::vector<char> block(piece_length, 'x');
+        std::vector<int> window;
+        for (int p = 0; p < std::min(num_pieces, 2 * lookbehind_pieces); ++p)
+        {
+            storage.writev(block, piece_index_t(p), 0);
+            if (p < lookbehind_pieces) window.push_back(p);
+        }
+        storage.set_lookbehind_pieces(window);
+
+        int total = 0;
+        auto const start = std::chrono::steady_clock::now();
+        for (int i = 0; i < calls; ++i)
+            total += storage.get_lookbehind_available_count();
+        auto const elapsed = std::chrono::steady_clock::now() - start;
+
+        if (total != lookbehind_pieces * calls) return -1;
+        return std::chrono::duration_cast<std::chrono::nanoseconds>(
+            elapsed).count();
+    }
+}
+%}
+
 // ============================================================================
 // Storage Index Tracking
 // ============================================================================
@@ -111,6 +467,21 @@ namespace libtorrent {
         return static_cast<int>(idx);
     }
 
+    // Storage index of a torrent by v1 info hash, or -1 if it has no
+    // storage yet
+    int memory_disk_find_storage(std::string const& info_hash_v1) {
+        sha1_hash info_hash;
+        if (info_hash_v1.size() != 2 * sha1_hash::size()
+            || !aux::from_hex(info_hash_v1, info_hash.data())) {
+            return -1;
+        }
+        std::lock_guard<std::mutex> lock(g_memory_disk_io_mutex);
+        if (g_memory_disk_io) {
+            return g_memory_disk_io->find_storage(info_hash);
+        }
+        return -1;
+    }
+
     // Get next storage index (returns current count before add)
     // Use this before add_torrent to predict the storage_index
     int get_next_storage_index() {
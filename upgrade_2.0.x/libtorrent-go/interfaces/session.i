@@ -77,6 +77,12 @@
         return alerts;
     }
 
+    // Block until an alert is pending or timeout_ms passes. Returns
+    // whether alerts are waiting to be popped.
+    bool wait_for_alerts(int timeout_ms) {
+        return self->wait_for_alert(libtorrent::milliseconds(timeout_ms)) != nullptr;
+    }
+
     // Copy alert message for safe storage (alert pointers become invalid after next session operation)
     std::string get_alert_message(libtorrent::alert* a) const {
         return a->message();
@@ -115,6 +121,18 @@
         return self->find_torrent(ih);
     }
 
+    // Apply settings to the running session. The disk backend sees them
+    // through disk_interface::settings_updated.
+    void apply_settings_pack(libtorrent::settings_pack const& settings) {
+        self->apply_settings(settings);
+    }
+
+    // Copy of the session's current settings, including defaults.
+    // The caller owns the returned pack.
+    libtorrent::settings_pack current_settings() const {
+        return self->get_settings();
+    }
+
     // Get all torrent handles
     std::vector<libtorrent::torrent_handle> get_all_torrents() const {
         return self->get_torrents();
@@ -131,7 +149,8 @@
             libtorrent::settings_interface const& si, libtorrent::counters& cnt)
         {
             // Use shared_ptr for proper lifetime management
-            auto dio = std::make_shared<libtorrent::memory_disk_io>(ioc);
+            auto dio = std::make_shared<libtorrent::memory_disk_io>(ioc, si,
+                libtorrent::memory_disk_memory_size.load());
             // Store shared_ptr globally to prevent dangling pointer
             libtorrent::set_global_memory_disk_io(dio);
             return dio;
@@ -149,6 +168,38 @@
     }
 }
 
+// Settings enumeration for snapshots and diffs
+%inline %{
+namespace libtorrent {
+    // Names of every setting known to settings_pack, all types
+    std::vector<std::string> all_setting_names() {
+        std::vector<std::string> names;
+        int const bases[] = {
+            settings_pack::string_type_base,
+            settings_pack::int_type_base,
+            settings_pack::bool_type_base,
+        };
+        int const counts[] = {
+            settings_pack::num_string_settings,
+            settings_pack::num_int_settings,
+            settings_pack::num_bool_settings,
+        };
+        for (int t = 0; t < 3; ++t) {
+            for (int i = 0; i < counts[t]; ++i) {
+                char const* name = name_for_setting(bases[t] + i);
+                if (name != nullptr && name[0] != '\0') names.emplace_back(name);
+            }
+        }
+        return names;
+    }
+
+    // libtorrent's stock settings
+    settings_pack default_settings_pack() {
+        return default_settings();
+    }
+}
+%}
+
 // Session state save/load functions (2.0.x way)
 namespace libtorrent {
     session_params read_session_params(span<char const> buf,
@@ -208,4 +259,17 @@ namespace libtorrent {
     bool has_setting(std::string const& name) const {
         return libtorrent::setting_by_name(name) >= 0;
     }
+
+    // Value type of a setting: 0 bool, 1 int, 2 string, -1 unknown.
+    // Matches the Go SettingType constants.
+    int setting_type(std::string const& name) const {
+        int setting = libtorrent::setting_by_name(name);
+        if (setting < 0) return -1;
+        switch (setting & libtorrent::settings_pack::type_mask) {
+            case libtorrent::settings_pack::bool_type_base: return 0;
+            case libtorrent::settings_pack::int_type_base: return 1;
+            case libtorrent::settings_pack::string_type_base: return 2;
+        }
+        return -1;
+    }
 }
@@ -0,0 +1,140 @@
+/*
+ * test_harness.i - Test-only memory_disk_io harness
+ *
+ * A standalone memory_disk_io with its own io_context running on a
+ * background thread. Lets Go tests drive reads, writes and lookbehind calls
+ * from many goroutines at once, under -race and the C++ thread sanitizer
+ * (make test-race / make test-tsan), without a session or peers.
+ *
+ * Only wrapped when SWIG runs with -DLIBTORRENT_GO_TESTING; the Go tests
+ * using it build with the libtorrent_testing tag.
+ */
+
+%inline %{
+#include <future>
+#include <thread>
+#include <libtorrent/hex.hpp>
+
+namespace libtorrent {
+    class memory_disk_test_harness {
+    public:
+        // Each harness owns its memory budget and starts unlimited
+        memory_disk_test_harness()
+            : m_work(boost::asio::make_work_guard(m_ioc))
+            , m_dio(m_ioc, m_settings, 0)
+            , m_thread([this] { m_ioc.run(); })
+        {}
+
+        ~memory_disk_test_harness() {
+            {
+                std::lock_guard<std::mutex> lock(m_mutex);
+                m_holders.clear();
+            }
+            m_work.reset();
+            m_thread.join();
+        }
+
+        // Create a storage; returns its storage index
+        int add_storage(int piece_length, int num_pieces) {
+            std::lock_guard<std::mutex> lock(m_mutex);
+            auto fs = std::make_unique<file_storage>();
+            fs->set_piece_length(piece_length);
+            fs->add_file("harness/video.mkv",
+                static_cast<std::int64_t>(piece_length) * num_pieces);
+            fs->set_num_pieces(num_pieces);
+
+            aux::vector<download_priority_t, file_index_t> prio;
+            storage_params params(*fs, nullptr, "", storage_mode_sparse,
+                prio, sha1_hash());
+            m_holders.push_back(m_dio.new_torrent(params, nullptr));
+            m_files.push_back(std::move(fs));
+            return static_cast<int>(m_holders.back().index());
+        }
+
+        // Write a block and wait for its completion handler
+        bool write_block(int storage, int piece, int offset, std::string const& data) {
+            peer_request r;
+            r.piece = piece_index_t(piece);
+            r.start = offset;
+            r.length = static_cast<int>(data.size());
+
+            std::promise<bool> done;
+            m_dio.async_write(storage_index_t(storage), r, data.data(), nullptr,
+                [&done](storage_error const& ec) { done.set_value(!ec.ec); }, {});
+            return done.get_future().get();
+        }
+
+        // Read a block and wait for its completion handler.
+        // Returns an empty string if the piece is not in memory.
+        std::string read_block(int storage, int piece, int offset, int length) {
+            peer_request r;
+            r.piece = piece_index_t(piece);
+            r.start = offset;
+            r.length = length;
+
+            std::promise<std::string> done;
+            m_dio.async_read(storage_index_t(storage), r,
+                [&done](disk_buffer_holder h, storage_error const& ec) {
+                    if (ec.ec || !h) {
+                        done.set_value("");
+                        return;
+                    }
+                    done.set_value(std::string(h.data(), h.size()));
+                }, {});
+            return done.get_future().get();
+        }
+
+        // Hash a piece on the hashing pool and wait for the result.
+        // Returns the SHA-1 as hex, or an empty string on error.
+        std::string hash_piece(int storage, int piece) {
+            std::promise<std::string> done;
+            m_dio.async_hash(storage_index_t(storage), piece_index_t(piece),
+                span<sha256_hash>(), {},
+                [&done](piece_index_t, sha1_hash const& h, storage_error const& ec) {
+                    done.set_value(ec.ec ? "" : aux::to_hex(h));
+                });
+            return done.get_future().get();
+        }
+
+        void set_lookbehind(int storage, std::vector<int> const& pieces) {
+            m_dio.set_lookbehind_pieces(storage_index_t(storage), pieces);
+        }
+
+        void set_reader_range(int storage, int reader, int first, int last) {
+            m_dio.set_reader_range(storage_index_t(storage), reader, first, last);
+        }
+
+        void set_memory_size(std::int64_t memory_size) {
+            m_dio.set_memory_size(memory_size);
+        }
+
+        // Memory budget of this harness' storages
+        std::int64_t memory_size() const {
+            return m_dio.memory_size();
+        }
+
+        memory_storage_cache_stats cache_stats(int storage) {
+            return m_dio.get_cache_stats(storage_index_t(storage));
+        }
+
+        int lookbehind_available(int storage) {
+            int available = 0;
+            int protected_count = 0;
+            std::int64_t memory = 0;
+            m_dio.get_lookbehind_stats(storage_index_t(storage),
+                available, protected_count, memory);
+            return available;
+        }
+
+    private:
+        io_context m_ioc;
+        boost::asio::executor_work_guard<io_context::executor_type> m_work;
+        settings_pack m_settings;
+        memory_disk_io m_dio;
+        std::mutex m_mutex;
+        std::vector<std::unique_ptr<file_storage>> m_files;
+        std::vector<storage_holder> m_holders;
+        std::thread m_thread;
+    };
+}
+%}
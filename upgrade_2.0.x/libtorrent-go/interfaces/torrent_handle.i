@@ -71,12 +71,41 @@ namespace libtorrent {
 
 %array_class(libtorrent::block_info, block_info_list);
 
+%newobject libtorrent::torrent_handle::torrent_file_copy;
+
 %extend libtorrent::torrent_handle {
     const libtorrent::torrent_info* torrent_file() {
         auto ti = self->torrent_file();
         return ti.get();
     }
 
+    // Copy of the torrent_info that outlives the torrent, or nullptr
+    // before metadata is received or once the torrent was removed. Free
+    // with delete_torrent_info.
+    libtorrent::torrent_info* torrent_file_copy() const {
+        try {
+            auto ti = self->torrent_file();
+            if (!ti) return nullptr;
+            return new libtorrent::torrent_info(*ti);
+        } catch (libtorrent::system_error const&) {
+            return nullptr;
+        }
+    }
+
+    bool metadata_received() const {
+        return self->status(libtorrent::status_flags_t{}).has_metadata;
+    }
+
+    // Start downloading a torrent added with set_metadata_only
+    void clear_upload_mode() {
+        self->unset_flags(libtorrent::torrent_flags::upload_mode);
+    }
+
+    // Move the torrent's files, replacing any existing files at save_path
+    void move_storage_to(std::string const& save_path) {
+        self->move_storage(save_path);
+    }
+
     // Note: get_storage_impl() is REMOVED in 2.0.x
     // Storage is now session-level via disk_interface
     // Use storage_index_t from add_torrent to access storage
@@ -112,6 +141,10 @@ namespace libtorrent {
                             static_cast<libtorrent::download_priority_t>(priority));
     }
 
+    bool have_piece_int(int piece) const {
+        return self->have_piece(libtorrent::piece_index_t(piece));
+    }
+
     // File operations with int wrapper
     int file_priority_int(int file) {
         return static_cast<int>(self->file_priority(libtorrent::file_index_t(file)));
@@ -122,6 +155,31 @@ namespace libtorrent {
                            static_cast<libtorrent::download_priority_t>(priority));
     }
 
+    // All file priorities in file order
+    std::vector<int> file_priorities_int() const {
+        std::vector<int> out;
+        for (auto p : self->get_file_priorities())
+            out.push_back(static_cast<int>(static_cast<std::uint8_t>(p)));
+        return out;
+    }
+
+    // Set all file priorities at once. libtorrent resets files past the
+    // end of the vector to the default priority.
+    void prioritize_files_int(std::vector<int> const& priorities) {
+        std::vector<libtorrent::download_priority_t> prios;
+        prios.reserve(priorities.size());
+        for (int p : priorities)
+            prios.push_back(static_cast<libtorrent::download_priority_t>(p));
+        self->prioritize_files(prios);
+    }
+
+    // Downloaded bytes per file, counting whole pieces only (cheap)
+    std::vector<long long> file_progress_bytes() const {
+        std::vector<std::int64_t> progress;
+        self->file_progress(progress, libtorrent::torrent_handle::piece_granularity);
+        return std::vector<long long>(progress.begin(), progress.end());
+    }
+
     // Piece deadline with int wrapper
     void set_piece_deadline_int(int piece, int deadline) {
         self->set_piece_deadline(libtorrent::piece_index_t(piece), deadline);
@@ -183,6 +241,34 @@ namespace libtorrent {
         return std::chrono::duration_cast<std::chrono::seconds>(
             self->seeding_duration).count();
     }
+
+    // Seconds since payload was last uploaded, -1 if never
+    std::int64_t get_seconds_since_upload() const {
+        if (self->last_upload == libtorrent::time_point()) return -1;
+        return std::chrono::duration_cast<std::chrono::seconds>(
+            libtorrent::clock_type::now() - self->last_upload).count();
+    }
+
+    // Transfer totals for ratio calculation
+    std::int64_t get_all_time_upload_bytes() const {
+        return self->all_time_upload;
+    }
+
+    std::int64_t get_all_time_download_bytes() const {
+        return self->all_time_download;
+    }
+
+    std::int64_t get_total_wanted_bytes() const {
+        return self->total_wanted;
+    }
+
+    bool get_is_seeding() const {
+        return self->is_seeding;
+    }
+
+    std::string get_save_path() const {
+        return self->save_path;
+    }
 }
 
 // Deprecated in 2.0.x
@@ -254,6 +340,19 @@ namespace libtorrent {
     std::int64_t file_offset_at(int index) const {
         return self->files().file_offset(libtorrent::file_index_t(index));
     }
+
+    // Whether a file is padding aligning the next file to a piece
+    // boundary (v2 and hybrid torrents). Pad files are never written.
+    bool file_is_pad_at(int index) const {
+        return bool(self->files().file_flags(libtorrent::file_index_t(index))
+            & libtorrent::file_storage::flag_pad_file);
+    }
+
+    // Raw bencoded info dictionary, as hashed into the info hashes
+    std::vector<char> info_section_buf() const {
+        auto section = self->info_section();
+        return std::vector<char>(section.begin(), section.end());
+    }
 }
 
 // Announce entry extensions for hybrid torrent support
@@ -57,11 +57,26 @@
 #include "memory_disk_io.hpp"
 %}
 
-// Vector templates
+// Vector templates. %extend comes first so the instantiation picks it up.
+%extend std::vector<char> {
+    // The whole vector in one call, converted with []byte(vec.Bytes())
+    std::string bytes() const {
+        return std::string(self->data(), self->size());
+    }
+}
 %template(StdVectorChar) std::vector<char>;
 %template(StdVectorInt) std::vector<int>;
+%template(StdVectorInt64) std::vector<long long>;
 %template(StdVectorString) std::vector<std::string>;
 
+// Bulk copies between Go byte slices and std::vector<char>, one call
+// instead of one Add or Get per byte. Go passes []byte as string(buf).
+%inline %{
+std::vector<char> new_std_vector_char_from_bytes(std::string const& data) {
+    return std::vector<char>(data.begin(), data.end());
+}
+%}
+
 // Type mappings for Go compatibility
 %typemap(gotype) std::int64_t "int64"
 %typemap(gotype) std::uint64_t "uint64"
@@ -88,6 +103,10 @@
 // 2. Disk I/O (before session)
 %include "interfaces/disk_interface.i"
 
+#ifdef LIBTORRENT_GO_TESTING
+%include "interfaces/test_harness.i"
+#endif
+
 // 3. Session parameters (before session)
 %include "interfaces/session_params.i"
 
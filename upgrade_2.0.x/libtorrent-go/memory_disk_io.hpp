@@ -22,6 +22,10 @@
 #include <vector>
 #include <string>
 #include <map>
+#include <list>
+#include <deque>
+#include <thread>
+#include <condition_variable>
 #include <functional>
 
 #include <boost/dynamic_bitset.hpp>
@@ -41,12 +45,14 @@
 #include <libtorrent/units.hpp>
 #include <libtorrent/span.hpp>
 #include <libtorrent/peer_request.hpp>
+#include <libtorrent/performance_counters.hpp>
 
 typedef boost::dynamic_bitset<> Bitset;
 
 namespace libtorrent {
 
-// Global memory configuration
+// Memory budget for the next memory_disk_io a session creates. A running
+// memory_disk_io keeps its own copy, see memory_disk_io::set_memory_size.
 // Using atomic for thread-safe access from multiple threads
 std::atomic<std::int64_t> memory_disk_memory_size{0};
 
@@ -55,43 +61,162 @@ inline std::chrono::steady_clock::time_point now() {
     return std::chrono::steady_clock::now();
 }
 
+// Block size used for disk counters
+constexpr int memory_disk_block_size = 0x4000;
+
+// Number of blocks covering size bytes
+inline int blocks_for(int size) {
+    return (size + memory_disk_block_size - 1) / memory_disk_block_size;
+}
+
+// Microseconds elapsed since start
+inline std::int64_t elapsed_us(std::chrono::steady_clock::time_point start) {
+    return std::chrono::duration_cast<std::chrono::microseconds>(
+        now() - start).count();
+}
+
+// Piece data is reference counted so read buffers handed to libtorrent can
+// point directly into it. An evicted piece stays alive until the last reader
+// releases its buffer.
+using piece_buffer = std::shared_ptr<std::vector<char>>;
+
+// SHA-1 of a whole piece, plus SHA-256 block hashes for v2 if requested.
+// Works on a piece buffer without any storage lock held.
+inline sha1_hash hash_piece_data(std::vector<char> const& data,
+                                 int const piece_size2,
+                                 span<sha256_hash> const block_hashes)
+{
+    hasher h;
+    h.update(data);
+
+    // Compute block hashes for v2 if requested
+    if (!block_hashes.empty())
+    {
+        int const blocks_in_piece = (piece_size2 + 0x3fff) / 0x4000;
+        char const* buf = data.data();
+        std::int64_t offset = 0;
+        for (int k = 0; k < blocks_in_piece; ++k)
+        {
+            hasher256 h2;
+            std::ptrdiff_t const len = std::min(0x4000,
+                static_cast<int>(data.size() - offset));
+            h2.update({buf, len});
+            buf += len;
+            offset += len;
+            block_hashes[k] = h2.final();
+        }
+    }
+
+    return h.final();
+}
+
+// SHA-256 of one block (v2 torrents)
+inline sha256_hash hash_block_data(std::vector<char> const& data,
+                                   int const offset)
+{
+    hasher256 h;
+    std::ptrdiff_t const len = std::min(0x4000,
+        static_cast<int>(data.size()) - offset);
+    h.update({data.data() + offset, len});
+    return h.final();
+}
+
+// Why a piece left memory
+enum class eviction_reason
+{
+    lru,          // Buffer full, oldest piece dropped to make room
+    capacity,     // Memory budget shrank at runtime
+    clear_piece   // libtorrent cleared the piece (e.g. hash failure)
+};
+
+// Per-storage cache accounting
+struct memory_storage_cache_stats
+{
+    std::int64_t read_hits = 0;           // async_read served from memory
+    std::int64_t read_misses = 0;         // async_read hit EOF
+    std::int64_t read_misses_evicted = 0; // misses on previously evicted pieces
+    std::int64_t bytes_served = 0;
+    std::int64_t evictions_lru = 0;
+    std::int64_t evictions_capacity = 0;
+    std::int64_t evictions_clear_piece = 0;
+};
+
 // ============================================================================
 // memory_storage - Data holder for one torrent's memory buffers
 // ============================================================================
 
 struct memory_storage
 {
+    // Guards all members below. Each storage has its own lock so streams of
+    // different torrents never serialize against each other.
+    mutable std::mutex m_mutex;
+
     // Piece data storage
-    std::map<piece_index_t, std::vector<char>> m_file_data;
+    std::map<piece_index_t, piece_buffer> m_file_data;
 
     // Torrent metadata
     file_storage const& m_files;
     int m_piece_length;
     int m_num_pieces;
+    sha1_hash const m_info_hash;
 
     // Buffer management
-    Bitset reader_pieces;
+    Bitset reader_pieces;       // Union of all active reader ranges
     Bitset reserved_pieces;
     Bitset lookbehind_pieces;
     std::int64_t capacity;
     int buffer_limit;
     int buffer_used;
 
-    // Timing for LRU
-    std::map<piece_index_t, std::chrono::steady_clock::time_point> m_access_times;
+    // Eviction index: unprotected pieces in memory, least recently written
+    // first. Protected (reserved or lookbehind) pieces are kept out of the
+    // list, so eviction takes the front in O(1) instead of scanning.
+    std::list<piece_index_t> m_lru;
+    std::vector<std::list<piece_index_t>::iterator> m_lru_pos;
+    Bitset m_in_lru;
+
+    // Number of lookbehind pieces currently in memory, kept up to date on
+    // every insert, removal and lookbehind change
+    int m_lookbehind_available = 0;
+
+    // Read-ahead range [first, last] of each active reader, by reader id
+    std::map<int, std::pair<int, int>> m_readers;
+
+    // Cache accounting, and pieces evicted since they were last written
+    memory_storage_cache_stats m_stats;
+    Bitset m_evicted;
 
     // Logging
     bool is_logging = false;
 
-    explicit memory_storage(storage_params const& p)
+    // memory_size is the budget in bytes, 0 for unlimited
+    memory_storage(storage_params const& p, std::int64_t const memory_size)
         : m_files(p.files)
         , m_piece_length(p.files.piece_length())
         , m_num_pieces(p.files.num_pieces())
-        , capacity(memory_disk_memory_size.load())
+        , m_info_hash(p.info_hash)
+        , capacity(memory_size)
         , buffer_limit(0)
         , buffer_used(0)
     {
-        // Calculate buffer limit based on capacity
+        update_buffer_limit();
+
+        // Initialize bitsets
+        reader_pieces.resize(m_num_pieces + 10);
+        reserved_pieces.resize(m_num_pieces + 10);
+        lookbehind_pieces.resize(m_num_pieces + 10);
+        m_in_lru.resize(m_num_pieces + 10);
+        m_lru_pos.resize(m_num_pieces + 10);
+        m_evicted.resize(m_num_pieces + 10);
+
+        std::cerr << "INFO memory_storage: pieces=" << m_num_pieces
+                  << ", piece_length=" << m_piece_length
+                  << ", buffer_limit=" << buffer_limit << std::endl;
+    }
+
+    // Calculate buffer limit based on capacity
+    void update_buffer_limit()
+    {
         if (capacity > 0) {
             buffer_limit = static_cast<int>(std::ceil(
                 static_cast<double>(capacity) / m_piece_length) + 2);
@@ -101,19 +226,37 @@ struct memory_storage
         } else {
             buffer_limit = m_num_pieces;
         }
+    }
 
-        // Initialize bitsets
-        reader_pieces.resize(m_num_pieces + 10);
-        reserved_pieces.resize(m_num_pieces + 10);
-        lookbehind_pieces.resize(m_num_pieces + 10);
+    // Change memory budget at runtime, evicting pieces if it shrank
+    void set_capacity(std::int64_t const new_capacity)
+    {
+        capacity = new_capacity;
+        update_buffer_limit();
+        update_reader_pieces();
 
-        std::cerr << "INFO memory_storage: pieces=" << m_num_pieces
-                  << ", piece_length=" << m_piece_length
-                  << ", buffer_limit=" << buffer_limit << std::endl;
+        if (capacity > 0 && buffer_used > buffer_limit)
+        {
+            evict_until(buffer_limit, piece_index_t(-1),
+                eviction_reason::capacity);
+        }
+
+        std::cerr << "INFO memory_storage: capacity=" << capacity
+                  << ", buffer_limit=" << buffer_limit
+                  << ", buffer_used=" << buffer_used << std::endl;
     }
 
     // Read piece data
     span<char const> readv(peer_request const& r, storage_error& ec) const
+    {
+        piece_buffer holder;
+        return read_shared(r, ec, holder);
+    }
+
+    // Read piece data and hand out a reference to the piece buffer.
+    // The returned span stays valid for as long as holder is kept.
+    span<char const> read_shared(peer_request const& r, storage_error& ec,
+                                 piece_buffer& holder) const
     {
         auto const i = m_file_data.find(r.piece);
         if (i == m_file_data.end())
@@ -123,46 +266,69 @@ struct memory_storage
             return {};
         }
 
-        if (static_cast<int>(i->second.size()) <= r.start)
+        std::vector<char> const& data = *i->second;
+        if (static_cast<int>(data.size()) <= r.start)
         {
             ec.operation = operation_t::file_read;
             ec.ec = boost::asio::error::eof;
             return {};
         }
 
+        holder = i->second;
         int const size = std::min(r.length,
-            static_cast<int>(i->second.size()) - r.start);
-        return {i->second.data() + r.start, size};
+            static_cast<int>(data.size()) - r.start);
+        return {data.data() + r.start, size};
     }
 
     // Write piece data
     void writev(span<char const> b, piece_index_t const piece, int const offset)
     {
         auto& data = m_file_data[piece];
-        if (data.empty())
+        if (!data)
         {
             // New piece - check buffer limit
             if (capacity > 0 && buffer_used >= buffer_limit)
             {
                 trim(piece);
             }
-            data.resize(m_files.piece_size(piece));
+            // Allocate the full piece up front so pinned read buffers are
+            // never invalidated by a later resize
+            data = std::make_shared<std::vector<char>>(m_files.piece_size(piece));
             buffer_used++;
+
+            int const idx = static_cast<int>(piece);
+            if (idx < m_num_pieces && lookbehind_pieces.test(idx))
+                m_lookbehind_available++;
         }
 
         // Ensure vector is large enough
         std::size_t const required_size = offset + b.size();
-        if (data.size() < required_size)
-            data.resize(required_size);
+        if (data->size() < required_size)
+        {
+            // Readers still point into the old buffer - copy on write
+            if (data.use_count() > 1)
+                data = std::make_shared<std::vector<char>>(*data);
+            data->resize(required_size);
+        }
 
-        std::memcpy(data.data() + offset, b.data(), b.size());
-        m_access_times[piece] = now();
+        std::memcpy(data->data() + offset, b.data(), b.size());
+        touch(piece);
+        if (static_cast<int>(piece) < m_num_pieces)
+            m_evicted.reset(static_cast<int>(piece));
     }
 
     // Compute SHA1 hash for a piece
     sha1_hash hash(piece_index_t const piece,
                    span<sha256_hash> const block_hashes,
                    storage_error& ec) const
+    {
+        piece_buffer const data = get_piece(piece, ec);
+        if (!data) return {};
+        return hash_piece_data(*data, m_files.piece_size2(piece), block_hashes);
+    }
+
+    // Reference to a piece's buffer, for hashing outside the storage lock
+    piece_buffer get_piece(piece_index_t const piece, storage_error& ec) const
     {
         auto const i = m_file_data.find(piece);
         if (i == m_file_data.end())
@@ -171,65 +337,126 @@ struct memory_storage
             ec.ec = boost::asio::error::eof;
             return {};
         }
+        return i->second;
+    }
+
+    // Compute SHA256 hash for a block (v2 torrents)
+    sha256_hash hash2(piece_index_t const piece, int const offset,
+                      storage_error& ec)
+    {
+        piece_buffer const data = get_piece(piece, ec);
+        if (!data) return {};
+        return hash_block_data(*data, offset);
+    }
+
+    // Check if piece has data
+    bool has_piece(piece_index_t piece) const
+    {
+        return m_file_data.find(piece) != m_file_data.end();
+    }
+
+    // ========================================================================
+    // Eviction index
+    // ========================================================================
 
-        hasher h;
-        h.update(i->second);
+    bool is_protected(piece_index_t piece) const
+    {
+        int const idx = static_cast<int>(piece);
+        if (idx < 0 || idx >= m_num_pieces) return false;
+        return reserved_pieces.test(idx) || lookbehind_pieces.test(idx)
+            || reader_pieces.test(idx);
+    }
+
+    // Mark a piece as most recently used. Protected pieces stay out of the
+    // eviction index.
+    void touch(piece_index_t piece)
+    {
+        int const idx = static_cast<int>(piece);
+        if (idx < 0 || idx >= m_num_pieces) return;
 
-        // Compute block hashes for v2 if requested
-        if (!block_hashes.empty())
+        if (m_in_lru.test(idx))
         {
-            int const piece_size2 = m_files.piece_size2(piece);
-            int const blocks_in_piece = (piece_size2 + 0x3fff) / 0x4000;
-            char const* buf = i->second.data();
-            std::int64_t offset = 0;
-            for (int k = 0; k < blocks_in_piece; ++k)
-            {
-                hasher256 h2;
-                std::ptrdiff_t const len = std::min(0x4000,
-                    static_cast<int>(i->second.size() - offset));
-                h2.update({buf, len});
-                buf += len;
-                offset += len;
-                block_hashes[k] = h2.final();
-            }
+            m_lru.splice(m_lru.end(), m_lru, m_lru_pos[idx]);
         }
+        else if (!is_protected(piece))
+        {
+            m_lru_pos[idx] = m_lru.insert(m_lru.end(), piece);
+            m_in_lru.set(idx);
+        }
+    }
 
-        return h.final();
+    // Make an in-memory piece evictable again. It goes to the front because
+    // a piece leaving the protected window is older than anything written
+    // since.
+    void lru_insert_oldest(piece_index_t piece)
+    {
+        int const idx = static_cast<int>(piece);
+        if (m_in_lru.test(idx)) return;
+        m_lru_pos[idx] = m_lru.insert(m_lru.begin(), piece);
+        m_in_lru.set(idx);
     }
 
-    // Compute SHA256 hash for a block (v2 torrents)
-    sha256_hash hash2(piece_index_t const piece, int const offset,
-                      storage_error& ec)
+    void lru_erase(piece_index_t piece)
     {
-        auto const i = m_file_data.find(piece);
-        if (i == m_file_data.end())
+        int const idx = static_cast<int>(piece);
+        if (idx < 0 || idx >= m_num_pieces || !m_in_lru.test(idx)) return;
+        m_lru.erase(m_lru_pos[idx]);
+        m_in_lru.reset(idx);
+    }
+
+    // ========================================================================
+    // Cache accounting
+    // ========================================================================
+
+    void count_eviction(piece_index_t piece, eviction_reason const reason)
+    {
+        switch (reason)
         {
-            ec.operation = operation_t::file_read;
-            ec.ec = boost::asio::error::eof;
-            return {};
+            case eviction_reason::lru: m_stats.evictions_lru++; break;
+            case eviction_reason::capacity: m_stats.evictions_capacity++; break;
+            case eviction_reason::clear_piece: m_stats.evictions_clear_piece++; break;
+        }
+        int const idx = static_cast<int>(piece);
+        if (idx >= 0 && idx < m_num_pieces) m_evicted.set(idx);
+    }
+
+    void count_read(piece_index_t piece, storage_error const& ec, int bytes)
+    {
+        if (!ec.ec)
+        {
+            m_stats.read_hits++;
+            m_stats.bytes_served += bytes;
+            return;
         }
 
-        hasher256 h;
-        std::ptrdiff_t const len = std::min(0x4000,
-            static_cast<int>(i->second.size()) - offset);
-        h.update({i->second.data() + offset, len});
-        return h.final();
+        m_stats.read_misses++;
+        int const idx = static_cast<int>(piece);
+        if (idx >= 0 && idx < m_num_pieces && m_evicted.test(idx))
+            m_stats.read_misses_evicted++;
     }
 
-    // Check if piece has data
-    bool has_piece(piece_index_t piece) const
+    // Drop all piece data, e.g. when files are released or deleted
+    void clear()
     {
-        return m_file_data.find(piece) != m_file_data.end();
+        m_file_data.clear();
+        m_lru.clear();
+        m_in_lru.reset();
+        m_lookbehind_available = 0;
+        buffer_used = 0;
     }
 
     // Remove a piece to free space
-    void remove_piece(piece_index_t piece)
+    void remove_piece(piece_index_t piece, eviction_reason const reason)
     {
         auto it = m_file_data.find(piece);
         if (it != m_file_data.end())
         {
             m_file_data.erase(it);
-            m_access_times.erase(piece);
+            count_eviction(piece, reason);
+            lru_erase(piece);
+            int const idx = static_cast<int>(piece);
+            if (idx >= 0 && idx < m_num_pieces && lookbehind_pieces.test(idx))
+                m_lookbehind_available--;
             buffer_used--;
 
             if (is_logging)
@@ -240,43 +467,30 @@ struct memory_storage
         }
     }
 
-    // Trim buffers using LRU eviction
+    // Trim buffers using LRU eviction, making room for one new piece
     void trim(piece_index_t const current_piece)
     {
-        while (buffer_used >= buffer_limit)
-        {
-            // Find oldest piece that's not protected
-            piece_index_t oldest_piece(-1);
-            auto oldest_time = now();
-
-            for (auto const& kv : m_access_times)
-            {
-                int const idx = static_cast<int>(kv.first);
-
-                // Skip current piece
-                if (kv.first == current_piece) continue;
-
-                // Skip protected pieces
-                if (idx < m_num_pieces)
-                {
-                    if (reserved_pieces.test(idx)) continue;
-                    if (lookbehind_pieces.test(idx)) continue;
-                }
+        evict_until(buffer_limit - 1, current_piece, eviction_reason::lru);
+    }
 
-                if (kv.second < oldest_time)
-                {
-                    oldest_time = kv.second;
-                    oldest_piece = kv.first;
-                }
-            }
+    // Evict least recently used pieces until at most max_used remain
+    void evict_until(int const max_used, piece_index_t const current_piece,
+                     eviction_reason const reason)
+    {
+        while (buffer_used > max_used)
+        {
+            // Oldest unprotected piece; protected pieces are never in the
+            // list, so at most the current piece has to be skipped
+            auto it = m_lru.begin();
+            if (it != m_lru.end() && *it == current_piece) ++it;
 
-            if (static_cast<int>(oldest_piece) == -1)
+            if (it == m_lru.end())
             {
                 // No piece found to evict
                 break;
             }
 
-            remove_piece(oldest_piece);
+            remove_piece(*it, reason);
         }
     }
 
@@ -286,22 +500,129 @@ struct memory_storage
 
     void set_lookbehind_pieces(std::vector<int> const& pieces)
     {
-        // Clear previous
-        lookbehind_pieces.reset();
-
-        // Set new
+        Bitset next(lookbehind_pieces.size());
         for (int piece : pieces)
         {
             if (piece >= 0 && piece < m_num_pieces)
             {
-                lookbehind_pieces.set(piece);
+                next.set(piece);
             }
         }
+        apply_lookbehind_and_readers(next);
     }
 
     void clear_lookbehind()
     {
-        lookbehind_pieces.reset();
+        apply_lookbehind_and_readers(Bitset(lookbehind_pieces.size()));
+    }
+
+    // Apply a lookbehind set and hand the pieces it freed or took back to
+    // the reader ranges
+    void apply_lookbehind_and_readers(Bitset const& next)
+    {
+        apply_lookbehind(next);
+        if (!m_readers.empty()) update_reader_pieces();
+    }
+
+    // Switch to a new lookbehind set, visiting only the pieces that changed
+    // to keep the eviction index and available counter in step
+    void apply_lookbehind(Bitset const& next)
+    {
+        Bitset const changed = lookbehind_pieces ^ next;
+        lookbehind_pieces = next;
+
+        for (auto i = changed.find_first(); i != Bitset::npos;
+             i = changed.find_next(i))
+        {
+            piece_index_t const piece(static_cast<int>(i));
+            if (!has_piece(piece)) continue;
+
+            if (next.test(i))
+            {
+                // Newly protected
+                lru_erase(piece);
+                m_lookbehind_available++;
+            }
+            else
+            {
+                // No longer protected
+                m_lookbehind_available--;
+                if (!is_protected(piece)) lru_insert_oldest(piece);
+            }
+        }
+    }
+
+    // ========================================================================
+    // Reader read-ahead protection
+    // ========================================================================
+
+    // Declare the pieces a reader is about to consume. Pieces in any
+    // reader's range are never evicted, up to what the buffer can hold
+    // next to the reserved and lookbehind pieces (see update_reader_pieces).
+    void set_reader_range(int reader, int first, int last)
+    {
+        first = std::max(first, 0);
+        last = std::min(last, m_num_pieces - 1);
+        if (first > last)
+        {
+            remove_reader(reader);
+            return;
+        }
+        m_readers[reader] = {first, last};
+        update_reader_pieces();
+    }
+
+    void remove_reader(int reader)
+    {
+        if (m_readers.erase(reader) > 0) update_reader_pieces();
+    }
+
+    // Rebuild reader_pieces from all reader ranges and move pieces whose
+    // protection changed in or out of the eviction index. With a memory
+    // limit, at most buffer_limit - 1 pieces are protected in total, so a
+    // new piece can always be written: reserved and lookbehind pieces come
+    // first, and each reader range is cut off once the rest is used up.
+    void update_reader_pieces()
+    {
+        Bitset next(reader_pieces.size());
+        int budget = m_num_pieces;
+        if (capacity > 0)
+        {
+            budget = buffer_limit - 1
+                - static_cast<int>((reserved_pieces | lookbehind_pieces).count());
+        }
+        for (auto const& kv : m_readers)
+        {
+            for (int p = kv.second.first; p <= kv.second.second; ++p)
+            {
+                if (next.test(p) || reserved_pieces.test(p)
+                    || lookbehind_pieces.test(p))
+                {
+                    continue;
+                }
+                if (budget <= 0) break;
+                next.set(p);
+                budget--;
+            }
+        }
+
+        Bitset const changed = reader_pieces ^ next;
+        reader_pieces = next;
+
+        for (auto i = changed.find_first(); i != Bitset::npos;
+             i = changed.find_next(i))
+        {
+            piece_index_t const piece(static_cast<int>(i));
+            if (!has_piece(piece)) continue;
+
+            if (is_protected(piece)) lru_erase(piece);
+            else lru_insert_oldest(piece);
+        }
+    }
+
+    int get_reader_count() const
+    {
+        return static_cast<int>(m_readers.size());
     }
 
     bool is_lookbehind_available(int piece) const
@@ -313,15 +634,7 @@ struct memory_storage
 
     int get_lookbehind_available_count() const
     {
-        int count = 0;
-        for (int i = 0; i < m_num_pieces; i++)
-        {
-            if (lookbehind_pieces.test(i) && has_piece(piece_index_t(i)))
-            {
-                count++;
-            }
-        }
-        return count;
+        return m_lookbehind_available;
     }
 
     int get_lookbehind_protected_count() const
@@ -336,6 +649,161 @@ struct memory_storage
     }
 };
 
+// ============================================================================
+// memory_hash_pool - Worker threads for piece hashing
+// ============================================================================
+
+// Runs hash jobs off the network thread. Sized from hashing_threads and
+// resized when settings change. Queued jobs always run, so every handler is
+// called exactly once, also across shutdown.
+class memory_hash_pool
+{
+public:
+    memory_hash_pool() = default;
+    memory_hash_pool(memory_hash_pool const&) = delete;
+    memory_hash_pool& operator=(memory_hash_pool const&) = delete;
+
+    ~memory_hash_pool()
+    {
+        shutdown();
+        join();
+    }
+
+    // Start or resize the pool without waiting for queued jobs. Surplus
+    // workers retire once they are idle and are joined on a later resize
+    // or at shutdown.
+    void set_num_threads(int const num_threads)
+    {
+        std::vector<std::thread> retired;
+        {
+            std::lock_guard<std::mutex> lock(m_mutex);
+            reap_locked(retired);
+
+            m_stopping = false;
+            m_target = num_threads;
+            while (m_live < m_target)
+            {
+                m_threads.emplace_back([this] { run(); });
+                ++m_live;
+            }
+        }
+        // Wake idle workers so the surplus can retire
+        m_cond.notify_all();
+
+        // These have left their loop already; joining does not wait on jobs
+        for (auto& t : retired) t.join();
+    }
+
+    int num_threads()
+    {
+        std::lock_guard<std::mutex> lock(m_mutex);
+        return m_target;
+    }
+
+    // Queue a job. Returns false if the pool is stopped.
+    bool submit(std::function<void()> job)
+    {
+        {
+            std::lock_guard<std::mutex> lock(m_mutex);
+            if (m_stopping || m_target == 0) return false;
+            m_jobs.push_back(std::move(job));
+        }
+        m_cond.notify_one();
+        return true;
+    }
+
+    // Stop accepting jobs. Workers finish the queue, then exit.
+    void shutdown()
+    {
+        {
+            std::lock_guard<std::mutex> lock(m_mutex);
+            m_stopping = true;
+            m_target = 0;
+        }
+        m_cond.notify_all();
+    }
+
+    // Wait for workers to exit; call after shutdown()
+    void join()
+    {
+        std::vector<std::thread> threads;
+        {
+            std::lock_guard<std::mutex> lock(m_mutex);
+            threads.swap(m_threads);
+            m_retired.clear();
+        }
+        for (auto& t : threads)
+        {
+            if (t.joinable()) t.join();
+        }
+    }
+
+private:
+    // A worker may retire when the pool shrank, as long as someone is left
+    // to run the queue
+    bool surplus_locked() const
+    {
+        return !m_stopping && m_live > m_target
+            && (m_target > 0 || m_jobs.empty());
+    }
+
+    // Move the threads of retired workers out for joining
+    void reap_locked(std::vector<std::thread>& out)
+    {
+        for (auto const id : m_retired)
+        {
+            auto const i = std::find_if(m_threads.begin(), m_threads.end(),
+                [id](std::thread const& t) { return t.get_id() == id; });
+            if (i == m_threads.end()) continue;
+            out.push_back(std::move(*i));
+            m_threads.erase(i);
+        }
+        m_retired.clear();
+    }
+
+    void run()
+    {
+        for (;;)
+        {
+            std::function<void()> job;
+            {
+                std::unique_lock<std::mutex> lock(m_mutex);
+                m_cond.wait(lock, [this] {
+                    return m_stopping || !m_jobs.empty() || surplus_locked();
+                });
+                if (surplus_locked() || m_jobs.empty())
+                {
+                    // Retired, or stopping and drained
+                    --m_live;
+                    if (!m_stopping) m_retired.push_back(std::this_thread::get_id());
+                    return;
+                }
+                job = std::move(m_jobs.front());
+                m_jobs.pop_front();
+            }
+            job();
+        }
+    }
+
+    std::mutex m_mutex;
+    std::condition_variable m_cond;
+    std::deque<std::function<void()>> m_jobs;
+    std::vector<std::thread> m_threads;
+    std::vector<std::thread::id> m_retired; // exited, not yet joined
+    int m_target = 0; // configured number of workers
+    int m_live = 0;   // workers still in their loop
+    bool m_stopping = false;
+};
+
+// Buffer limits of one storage, reported after a memory budget change
+struct memory_storage_limits
+{
+    int storage_index;
+    int piece_length;
+    int buffer_limit;
+    int buffer_used;
+};
+
 // ============================================================================
 // memory_disk_io - Session-level disk I/O handler
 // ============================================================================
@@ -346,17 +814,105 @@ struct memory_disk_io final
 {
 private:
     io_context& m_ioc;
-    aux::vector<std::unique_ptr<memory_storage>, storage_index_t> m_torrents;
+    settings_interface const& m_settings;
+
+    // Disk counters reported through update_stats_counters. Cumulative
+    // values, so they map directly onto libtorrent's stats counters.
+    std::atomic<std::int64_t> m_blocks_read{0};
+    std::atomic<std::int64_t> m_blocks_written{0};
+    std::atomic<std::int64_t> m_blocks_hashed{0};
+    std::atomic<std::int64_t> m_read_ops{0};
+    std::atomic<std::int64_t> m_write_ops{0};
+    std::atomic<std::int64_t> m_read_time_us{0};
+    std::atomic<std::int64_t> m_write_time_us{0};
+    std::atomic<std::int64_t> m_hash_time_us{0};
+
+    // Settings re-read in settings_updated
+    std::atomic<int> m_hashing_threads{1};
+
+    // Memory budget of each storage, 0 for unlimited
+    std::atomic<std::int64_t> m_memory_size;
+
+    // Hashing runs here, off the network thread
+    memory_hash_pool m_hash_pool;
+    // Slot table. m_torrents_mutex only guards the table itself; a storage's
+    // data is guarded by its own memory_storage::m_mutex. Slots hold
+    // shared_ptr so an operation in flight keeps its storage alive even if
+    // remove_torrent runs concurrently.
+    aux::vector<std::shared_ptr<memory_storage>, storage_index_t> m_torrents;
     std::vector<storage_index_t> m_free_slots;
-    mutable std::mutex m_mutex;
+    mutable std::mutex m_torrents_mutex;
     // Using atomic for thread-safe access to abort flag
     std::atomic<bool> m_abort{false};
 
+    // Piece buffers pinned by outstanding read buffers, keyed by the start
+    // address of the piece data. A read buffer points somewhere inside one
+    // of these, so free_disk_buffer finds its owner with upper_bound.
+    struct pinned_piece
+    {
+        piece_buffer buffer;
+        int refs = 0;
+    };
+    std::mutex m_pinned_mutex;
+    std::map<char const*, pinned_piece> m_pinned;
+    // Number of pin table insertions, the only allocation on the read path
+    std::atomic<std::int64_t> m_pin_allocations{0};
+
+    // Look up a storage by index; returns nullptr for unknown or removed slots
+    std::shared_ptr<memory_storage> get_storage(storage_index_t storage) const
+    {
+        std::lock_guard<std::mutex> lock(m_torrents_mutex);
+        if (storage < m_torrents.end_index()) return m_torrents[storage];
+        return nullptr;
+    }
+
+    // Snapshot of all live storages
+    std::vector<std::pair<storage_index_t, std::shared_ptr<memory_storage>>>
+    get_storages() const
+    {
+        std::lock_guard<std::mutex> lock(m_torrents_mutex);
+        std::vector<std::pair<storage_index_t, std::shared_ptr<memory_storage>>> result;
+        for (storage_index_t i(0); i < m_torrents.end_index(); ++i)
+        {
+            if (m_torrents[i]) result.emplace_back(i, m_torrents[i]);
+        }
+        return result;
+    }
+
+    void pin_buffer(piece_buffer const& buffer)
+    {
+        std::lock_guard<std::mutex> lock(m_pinned_mutex);
+        auto& p = m_pinned[buffer->data()];
+        if (p.refs == 0)
+        {
+            p.buffer = buffer;
+            m_pin_allocations++;
+        }
+        p.refs++;
+    }
+
+    void unpin_buffer(char const* buf)
+    {
+        std::lock_guard<std::mutex> lock(m_pinned_mutex);
+        auto it = m_pinned.upper_bound(buf);
+        if (it == m_pinned.begin()) return;
+        --it;
+        if (--it->second.refs == 0)
+        {
+            // Last reader gone - an evicted piece is freed here
+            m_pinned.erase(it);
+        }
+    }
+
 public:
-    explicit memory_disk_io(io_context& ioc)
+    memory_disk_io(io_context& ioc, settings_interface const& sett,
+        std::int64_t const memory_size)
         : m_ioc(ioc)
+        , m_settings(sett)
+        , m_memory_size(memory_size)
     {
         std::cerr << "INFO memory_disk_io created" << std::endl;
+        settings_updated();
     }
 
     // ========================================================================
@@ -366,19 +922,20 @@ public:
     storage_holder new_torrent(storage_params const& p,
                                std::shared_ptr<void> const&) override
     {
-        std::lock_guard<std::mutex> lock(m_mutex);
+        auto storage = std::make_shared<memory_storage>(p, m_memory_size.load());
+        std::lock_guard<std::mutex> lock(m_torrents_mutex);
 
         storage_index_t idx;
         if (m_free_slots.empty())
         {
             idx = storage_index_t(static_cast<int>(m_torrents.size()));
-            m_torrents.emplace_back(std::make_unique<memory_storage>(p));
+            m_torrents.emplace_back(std::move(storage));
         }
         else
         {
             idx = m_free_slots.back();
             m_free_slots.pop_back();
-            m_torrents[idx] = std::make_unique<memory_storage>(p);
+            m_torrents[idx] = std::move(storage);
         }
 
         std::cerr << "INFO new_torrent idx=" << static_cast<int>(idx)
@@ -388,7 +945,7 @@ public:
 
     void remove_torrent(storage_index_t idx) override
     {
-        std::lock_guard<std::mutex> lock(m_mutex);
+        std::lock_guard<std::mutex> lock(m_torrents_mutex);
 
         std::cerr << "INFO remove_torrent idx=" << static_cast<int>(idx)
                   << std::endl;
@@ -407,18 +964,22 @@ public:
         storage_error error;
         char* buf = nullptr;
         int buf_size = 0;
+        piece_buffer holder;
+        auto const start = now();
 
         {
-            std::lock_guard<std::mutex> lock(m_mutex);
-            if (storage < m_torrents.end_index() && m_torrents[storage])
+            auto const st = get_storage(storage);
+            if (st)
             {
-                span<char const> data = m_torrents[storage]->readv(r, error);
+                std::lock_guard<std::mutex> lock(st->m_mutex);
+                span<char const> data = st->read_shared(r, error, holder);
+                st->count_read(r.piece, error, static_cast<int>(data.size()));
                 if (!error.ec && data.size() > 0)
                 {
-                    // Allocate owned buffer and copy data
+                    // Point directly into the piece; the pin keeps it alive
+                    // until libtorrent calls free_disk_buffer
                     buf_size = static_cast<int>(data.size());
-                    buf = new char[buf_size];
-                    std::memcpy(buf, data.data(), buf_size);
+                    buf = const_cast<char*>(data.data());
                 }
             }
             else
@@ -427,6 +988,12 @@ public:
             }
         }
 
+        if (buf != nullptr) pin_buffer(holder);
+
+        m_read_ops++;
+        if (!error.ec) m_blocks_read += blocks_for(buf_size);
+        m_read_time_us += elapsed_us(start);
+
         post(m_ioc, [handler, error, buf, buf_size, this]
         {
             handler(disk_buffer_holder(*this, buf, buf_size), error);
@@ -439,12 +1006,14 @@ public:
         disk_job_flags_t) override
     {
         storage_error error;
+        auto const start = now();
 
         {
-            std::lock_guard<std::mutex> lock(m_mutex);
-            if (storage < m_torrents.end_index() && m_torrents[storage])
+            auto const st = get_storage(storage);
+            if (st)
             {
-                m_torrents[storage]->writev({buf, r.length}, r.piece, r.start);
+                std::lock_guard<std::mutex> lock(st->m_mutex);
+                st->writev({buf, r.length}, r.piece, r.start);
             }
             else
             {
@@ -452,6 +1021,10 @@ public:
             }
         }
 
+        m_write_ops++;
+        if (!error.ec) m_blocks_written += blocks_for(r.length);
+        m_write_time_us += elapsed_us(start);
+
         post(m_ioc, [handler, error] { handler(error); });
         return false; // false = not write-blocked
     }
@@ -461,13 +1034,16 @@ public:
         std::function<void(piece_index_t, sha1_hash const&, storage_error const&)> handler) override
     {
         storage_error error;
-        sha1_hash h;
+        piece_buffer data;
+        int piece_size2 = 0;
 
         {
-            std::lock_guard<std::mutex> lock(m_mutex);
-            if (storage < m_torrents.end_index() && m_torrents[storage])
+            auto const st = get_storage(storage);
+            if (st)
             {
-                h = m_torrents[storage]->hash(piece, block_hashes, error);
+                std::lock_guard<std::mutex> lock(st->m_mutex);
+                data = st->get_piece(piece, error);
+                piece_size2 = st->m_files.piece_size2(piece);
             }
             else
             {
@@ -475,7 +1051,24 @@ public:
             }
         }
 
-        post(m_ioc, [handler, piece, h, error] { handler(piece, h, error); });
+        if (error.ec)
+        {
+            post(m_ioc, [handler, piece, error] { handler(piece, sha1_hash(), error); });
+            return;
+        }
+
+        // The piece buffer reference keeps the data alive even if the piece
+        // is evicted while the job is queued
+        auto job = [this, handler, piece, data, piece_size2, block_hashes]
+        {
+            auto const start = now();
+            sha1_hash const h = hash_piece_data(*data, piece_size2, block_hashes);
+            m_blocks_hashed += blocks_for(static_cast<int>(data->size()));
+            m_hash_time_us += elapsed_us(start);
+            post(m_ioc, [handler, piece, h] { handler(piece, h, storage_error()); });
+        };
+
+        if (!m_hash_pool.submit(job)) post_aborted(handler, piece);
     }
 
     void async_hash2(storage_index_t storage, piece_index_t piece, int offset,
@@ -483,13 +1076,14 @@ public:
         std::function<void(piece_index_t, sha256_hash const&, storage_error const&)> handler) override
     {
         storage_error error;
-        sha256_hash h;
+        piece_buffer data;
 
         {
-            std::lock_guard<std::mutex> lock(m_mutex);
-            if (storage < m_torrents.end_index() && m_torrents[storage])
+            auto const st = get_storage(storage);
+            if (st)
             {
-                h = m_torrents[storage]->hash2(piece, offset, error);
+                std::lock_guard<std::mutex> lock(st->m_mutex);
+                data = st->get_piece(piece, error);
             }
             else
             {
@@ -497,7 +1091,32 @@ public:
             }
         }
 
-        post(m_ioc, [handler, piece, h, error] { handler(piece, h, error); });
+        if (error.ec)
+        {
+            post(m_ioc, [handler, piece, error] { handler(piece, sha256_hash(), error); });
+            return;
+        }
+
+        auto job = [this, handler, piece, data, offset]
+        {
+            auto const start = now();
+            sha256_hash const h = hash_block_data(*data, offset);
+            m_blocks_hashed++;
+            m_hash_time_us += elapsed_us(start);
+            post(m_ioc, [handler, piece, h] { handler(piece, h, storage_error()); });
+        };
+
+        if (!m_hash_pool.submit(job)) post_aborted(handler, piece);
+    }
+
+    // Fail a hash job that arrived after abort
+    template <typename Hash>
+    void post_aborted(std::function<void(piece_index_t, Hash const&, storage_error const&)> const& handler,
+                      piece_index_t const piece)
+    {
+        storage_error error;
+        error.ec = boost::asio::error::operation_aborted;
+        post(m_ioc, [handler, piece, error] { handler(piece, Hash(), error); });
     }
 
     void async_move_storage(storage_index_t, std::string,
@@ -517,10 +1136,11 @@ public:
         std::function<void()> handler) override
     {
         {
-            std::lock_guard<std::mutex> lock(m_mutex);
-            if (storage < m_torrents.end_index() && m_torrents[storage])
+            auto const st = get_storage(storage);
+            if (st)
             {
-                m_torrents[storage]->m_file_data.clear();
+                std::lock_guard<std::mutex> lock(st->m_mutex);
+                st->clear();
             }
         }
 
@@ -558,10 +1178,11 @@ public:
         std::function<void(storage_error const&)> handler) override
     {
         {
-            std::lock_guard<std::mutex> lock(m_mutex);
-            if (storage < m_torrents.end_index() && m_torrents[storage])
+            auto const st = get_storage(storage);
+            if (st)
             {
-                m_torrents[storage]->m_file_data.clear();
+                std::lock_guard<std::mutex> lock(st->m_mutex);
+                st->clear();
             }
         }
 
@@ -583,10 +1204,11 @@ public:
         std::function<void(piece_index_t)> handler) override
     {
         {
-            std::lock_guard<std::mutex> lock(m_mutex);
-            if (storage < m_torrents.end_index() && m_torrents[storage])
+            auto const st = get_storage(storage);
+            if (st)
             {
-                m_torrents[storage]->remove_piece(index);
+                std::lock_guard<std::mutex> lock(st->m_mutex);
+                st->remove_piece(index, eviction_reason::clear_piece);
             }
         }
 
@@ -597,7 +1219,32 @@ public:
     // Status and control
     // ========================================================================
 
-    void update_stats_counters(counters&) const override {}
+    void update_stats_counters(counters& c) const override
+    {
+        std::int64_t const read_time = m_read_time_us.load();
+        std::int64_t const write_time = m_write_time_us.load();
+        std::int64_t const hash_time = m_hash_time_us.load();
+
+        c.set_value(counters::num_blocks_read, m_blocks_read.load());
+        c.set_value(counters::num_blocks_written, m_blocks_written.load());
+        c.set_value(counters::num_blocks_hashed, m_blocks_hashed.load());
+        c.set_value(counters::num_read_ops, m_read_ops.load());
+        c.set_value(counters::num_write_ops, m_write_ops.load());
+        c.set_value(counters::disk_read_time, read_time);
+        c.set_value(counters::disk_write_time, write_time);
+        c.set_value(counters::disk_hash_time, hash_time);
+        c.set_value(counters::disk_job_time, read_time + write_time + hash_time);
+
+        // Gauge: blocks held in memory across all storages
+        std::int64_t blocks = 0;
+        for (auto const& entry : get_storages())
+        {
+            std::lock_guard<std::mutex> lock(entry.second->m_mutex);
+            blocks += static_cast<std::int64_t>(entry.second->buffer_used)
+                * blocks_for(entry.second->m_piece_length);
+        }
+        c.set_value(counters::disk_blocks_in_use, blocks);
+    }
 
     std::vector<open_file_state> get_status(storage_index_t) const override
     {
@@ -607,19 +1254,41 @@ public:
     void abort(bool wait) override
     {
         m_abort = true;
-        // If wait is true, we should wait for pending operations
-        // For memory storage, operations are synchronous, so nothing to wait for
+        // Reads and writes are synchronous; only hash jobs can be pending.
+        // They always finish and post their results; with wait, block until
+        // they have. Otherwise the workers are joined on destruction.
+        m_hash_pool.shutdown();
+        if (wait) m_hash_pool.join();
     }
 
     void submit_jobs() override {}
 
-    void settings_updated() override {}
+    // Called by the session after apply_settings
+    void settings_updated() override
+    {
+        m_hashing_threads = std::max(1,
+            m_settings.get_int(settings_pack::hashing_threads));
+        if (!m_abort) m_hash_pool.set_num_threads(m_hashing_threads);
+    }
 
     // buffer_allocator_interface
     void free_disk_buffer(char* buf) override
     {
-        // Free buffers allocated in async_read
-        delete[] buf;
+        // Release the piece pinned in async_read
+        unpin_buffer(buf);
+    }
+
+    // Number of pieces currently pinned by outstanding read buffers
+    int get_pinned_piece_count()
+    {
+        std::lock_guard<std::mutex> lock(m_pinned_mutex);
+        return static_cast<int>(m_pinned.size());
+    }
+
+    // Number of allocations made by the read path since creation
+    std::int64_t get_read_allocations() const
+    {
+        return m_pin_allocations.load();
     }
 
     // ========================================================================
@@ -629,28 +1298,102 @@ public:
     void set_lookbehind_pieces(storage_index_t storage,
                                std::vector<int> const& pieces)
     {
-        std::lock_guard<std::mutex> lock(m_mutex);
-        if (storage < m_torrents.end_index() && m_torrents[storage])
+        auto const st = get_storage(storage);
+        if (st)
         {
-            m_torrents[storage]->set_lookbehind_pieces(pieces);
+            std::lock_guard<std::mutex> lock(st->m_mutex);
+            st->set_lookbehind_pieces(pieces);
         }
     }
 
     void clear_lookbehind(storage_index_t storage)
     {
-        std::lock_guard<std::mutex> lock(m_mutex);
-        if (storage < m_torrents.end_index() && m_torrents[storage])
+        auto const st = get_storage(storage);
+        if (st)
+        {
+            std::lock_guard<std::mutex> lock(st->m_mutex);
+            st->clear_lookbehind();
+        }
+    }
+
+    memory_storage_cache_stats get_cache_stats(storage_index_t storage) const
+    {
+        auto const st = get_storage(storage);
+        if (st)
+        {
+            std::lock_guard<std::mutex> lock(st->m_mutex);
+            return st->m_stats;
+        }
+        return {};
+    }
+
+    void set_reader_range(storage_index_t storage, int reader,
+                          int first, int last)
+    {
+        auto const st = get_storage(storage);
+        if (st)
+        {
+            std::lock_guard<std::mutex> lock(st->m_mutex);
+            st->set_reader_range(reader, first, last);
+        }
+    }
+
+    void remove_reader(storage_index_t storage, int reader)
+    {
+        auto const st = get_storage(storage);
+        if (st)
         {
-            m_torrents[storage]->clear_lookbehind();
+            std::lock_guard<std::mutex> lock(st->m_mutex);
+            st->remove_reader(reader);
         }
     }
 
     bool is_lookbehind_available(storage_index_t storage, int piece) const
     {
-        std::lock_guard<std::mutex> lock(m_mutex);
-        if (storage < m_torrents.end_index() && m_torrents[storage])
+        auto const st = get_storage(storage);
+        if (st)
+        {
+            std::lock_guard<std::mutex> lock(st->m_mutex);
+            return st->is_lookbehind_available(piece);
+        }
+        return false;
+    }
+
+    // ========================================================================
+    // Streaming reads
+    // ========================================================================
+
+    // Copy up to size bytes of a piece for a stream reader. Returns an empty
+    // string if the piece is not in memory.
+    std::string read_piece_data(storage_index_t storage, int piece,
+                                int offset, int size)
+    {
+        auto const st = get_storage(storage);
+        if (!st || size <= 0) return {};
+
+        peer_request r;
+        r.piece = piece_index_t(piece);
+        r.start = offset;
+        r.length = size;
+
+        std::lock_guard<std::mutex> lock(st->m_mutex);
+        storage_error error;
+        piece_buffer holder;
+        span<char const> data = st->read_shared(r, error, holder);
+        st->count_read(r.piece, error, static_cast<int>(data.size()));
+        if (error.ec) return {};
+        return std::string(data.data(), static_cast<std::size_t>(data.size()));
+    }
+
+    // Whether a piece's data is held in memory. The piece may still be
+    // incomplete or unverified.
+    bool has_piece_data(storage_index_t storage, int piece) const
+    {
+        auto const st = get_storage(storage);
+        if (st)
         {
-            return m_torrents[storage]->is_lookbehind_available(piece);
+            std::lock_guard<std::mutex> lock(st->m_mutex);
+            return st->has_piece(piece_index_t(piece));
         }
         return false;
     }
@@ -659,12 +1402,13 @@ public:
                               int& available, int& protected_count,
                               std::int64_t& memory) const
     {
-        std::lock_guard<std::mutex> lock(m_mutex);
-        if (storage < m_torrents.end_index() && m_torrents[storage])
+        auto const st = get_storage(storage);
+        if (st)
         {
-            available = m_torrents[storage]->get_lookbehind_available_count();
-            protected_count = m_torrents[storage]->get_lookbehind_protected_count();
-            memory = m_torrents[storage]->get_lookbehind_memory_used();
+            std::lock_guard<std::mutex> lock(st->m_mutex);
+            available = st->get_lookbehind_available_count();
+            protected_count = st->get_lookbehind_protected_count();
+            memory = st->get_lookbehind_memory_used();
         }
         else
         {
@@ -674,19 +1418,74 @@ public:
         }
     }
 
+    // ========================================================================
+    // Memory budget
+    // ========================================================================
+
+    // Resize the memory budget of every live storage without a new session.
+    // Storages created later start with the new size too.
+    std::vector<memory_storage_limits> set_memory_size(std::int64_t memory_size)
+    {
+        std::cerr << "INFO memory_disk_io: memory size changed to "
+                  << memory_size << std::endl;
+        m_memory_size.store(memory_size);
+
+        for (auto const& entry : get_storages())
+        {
+            std::lock_guard<std::mutex> lock(entry.second->m_mutex);
+            entry.second->set_capacity(memory_size);
+        }
+        return get_storage_limits();
+    }
+
+    std::int64_t memory_size() const
+    {
+        return m_memory_size.load();
+    }
+
+    // Current buffer limits of every live storage
+    std::vector<memory_storage_limits> get_storage_limits() const
+    {
+        std::vector<memory_storage_limits> limits;
+        for (auto const& entry : get_storages())
+        {
+            auto const& st = entry.second;
+            std::lock_guard<std::mutex> lock(st->m_mutex);
+
+            limits.push_back({
+                static_cast<int>(entry.first),
+                st->m_piece_length,
+                st->buffer_limit,
+                st->buffer_used});
+        }
+        return limits;
+    }
+
     // ========================================================================
     // Storage index tracking
     // ========================================================================
 
+    // Storage of the torrent with this v1 info hash, or -1 if it has none.
+    // A magnet gets its storage once the metadata arrived.
+    int find_storage(sha1_hash const& info_hash) const {
+        std::lock_guard<std::mutex> lock(m_torrents_mutex);
+        for (storage_index_t i(0); i < m_torrents.end_index(); ++i)
+        {
+            if (m_torrents[i] && m_torrents[i]->m_info_hash == info_hash)
+                return static_cast<int>(i);
+        }
+        return -1;
+    }
+
     // Get current number of torrents (for storage index prediction)
     int get_torrent_count() const {
-        std::lock_guard<std::mutex> lock(m_mutex);
+        std::lock_guard<std::mutex> lock(m_torrents_mutex);
         return static_cast<int>(m_torrents.size()) - static_cast<int>(m_free_slots.size());
     }
 
     // Get next storage index that will be assigned
     int get_next_storage_index() const {
-        std::lock_guard<std::mutex> lock(m_mutex);
+        std::lock_guard<std::mutex> lock(m_torrents_mutex);
         if (!m_free_slots.empty()) {
             return static_cast<int>(m_free_slots.back());
         }
@@ -699,9 +1498,10 @@ public:
 // ============================================================================
 
 std::unique_ptr<disk_interface> memory_disk_constructor(
-    io_context& ioc, settings_interface const&, counters&)
+    io_context& ioc, settings_interface const& sett, counters&)
 {
-    return std::make_unique<memory_disk_io>(ioc);
+    return std::make_unique<memory_disk_io>(ioc, sett,
+        memory_disk_memory_size.load());
 }
 
 } // namespace libtorrent
@@ -0,0 +1,318 @@
+//go:build libtorrent_testing
+
+// memory_disk_harness_test.go - memory_disk_io tests on the test harness
+//
+// The harness is only wrapped in test builds of the library; run these with
+// make test-race or make test-tsan.
+
+package upgrade_test
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	lt "github.com/ElementumOrg/libtorrent-go"
+)
+
+// TestMemoryDiskConcurrentStress runs parallel reads, writes and lookbehind
+// updates across several storages. Run with make test-race and
+// make test-tsan to catch data races on both sides of the binding.
+func TestMemoryDiskConcurrentStress(t *testing.T) {
+	const (
+		numStorages = 4
+		numPieces   = 32
+		pieceLength = 64 * 1024
+		blockSize   = 16 * 1024
+		workers     = 4 // per storage
+		iterations  = 200
+	)
+
+	harness := lt.NewMemoryDiskTestHarness()
+	defer lt.DeleteMemoryDiskTestHarness(harness)
+
+	storages := make([]int, numStorages)
+	for i := range storages {
+		storages[i] = harness.Add_storage(pieceLength, numPieces)
+	}
+
+	// Each block carries a pattern derived from its position so any read
+	// that returns data can be checked against what was written
+	blockData := func(storage, piece, offset int) string {
+		b := make([]byte, blockSize)
+		for i := range b {
+			b[i] = byte(storage*31 + piece*7 + offset/blockSize + i)
+		}
+		return string(b)
+	}
+
+	var wg sync.WaitGroup
+	errors := make(chan error, numStorages*workers*3)
+
+	for _, storage := range storages {
+		for w := 0; w < workers; w++ {
+			// Writer
+			wg.Add(1)
+			go func(storage, w int) {
+				defer wg.Done()
+				for i := 0; i < iterations; i++ {
+					piece := (i + w) % numPieces
+					offset := ((i / numPieces) % (pieceLength / blockSize)) * blockSize
+					if !harness.Write_block(storage, piece, offset, blockData(storage, piece, offset)) {
+						errors <- fmt.Errorf("write failed: storage=%d piece=%d offset=%d", storage, piece, offset)
+						return
+					}
+				}
+			}(storage, w)
+
+			// Reader
+			wg.Add(1)
+			go func(storage, w int) {
+				defer wg.Done()
+				for i := 0; i < iterations; i++ {
+					piece := (i * (w + 1)) % numPieces
+					offset := (i % (pieceLength / blockSize)) * blockSize
+					data := harness.Read_block(storage, piece, offset, blockSize)
+					if data == "" {
+						continue // not written yet
+					}
+					if len(data) != blockSize {
+						errors <- fmt.Errorf("short read: storage=%d piece=%d got %d bytes", storage, piece, len(data))
+						return
+					}
+				}
+			}(storage, w)
+
+			// Lookbehind updates
+			wg.Add(1)
+			go func(storage, w int) {
+				defer wg.Done()
+				vec := lt.NewStdVectorInt()
+				defer lt.DeleteStdVectorInt(vec)
+				for i := 0; i < iterations; i++ {
+					vec.Clear()
+					for p := 0; p < 4; p++ {
+						vec.Add((i + p + w) % numPieces)
+					}
+					harness.Set_lookbehind(storage, vec)
+					_ = harness.Lookbehind_available(storage)
+				}
+			}(storage, w)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(60 * time.Second):
+		t.Fatal("Stress test did not finish - possible deadlock")
+	}
+	close(errors)
+
+	for err := range errors {
+		t.Error(err)
+	}
+
+	// Every written block must read back intact once writers are done
+	for _, storage := range storages {
+		for piece := 0; piece < numPieces; piece++ {
+			data := harness.Read_block(storage, piece, 0, blockSize)
+			if data != "" && data != blockData(storage, piece, 0) {
+				t.Errorf("Data mismatch: storage=%d piece=%d", storage, piece)
+			}
+		}
+	}
+}
+
+// TestReaderPiecesSurviveTrim verifies that pieces inside an active
+// reader's range are not evicted when later downloads fill the buffer
+func TestReaderPiecesSurviveTrim(t *testing.T) {
+	const (
+		pieceLength = 16 * 1024
+		numPieces   = 64
+	)
+
+	harness := lt.NewMemoryDiskTestHarness()
+	defer lt.DeleteMemoryDiskTestHarness(harness)
+
+	storage := harness.Add_storage(pieceLength, numPieces)
+	harness.Set_memory_size(8 * pieceLength) // buffer_limit = 10 pieces
+
+	block := string(make([]byte, pieceLength))
+
+	// Two readers buffering ahead at different positions
+	harness.Set_reader_range(storage, 1, 0, 3)
+	harness.Set_reader_range(storage, 2, 20, 22)
+
+	for piece := 0; piece < numPieces; piece++ {
+		if !harness.Write_block(storage, piece, 0, block) {
+			t.Fatalf("Write failed for piece %d", piece)
+		}
+	}
+
+	for _, piece := range []int{0, 1, 2, 3, 20, 21, 22} {
+		if harness.Read_block(storage, piece, 0, pieceLength) == "" {
+			t.Errorf("Piece %d in an active reader range was evicted", piece)
+		}
+	}
+
+	// Pieces outside reader ranges are still evicted
+	if harness.Read_block(storage, 10, 0, pieceLength) != "" {
+		t.Error("Piece 10 outside reader ranges should have been evicted")
+	}
+}
+
+// TestReaderRangeClampedToBuffer verifies that a reader range larger than
+// the memory budget protects only what fits next to the lookbehind pieces,
+// so the buffer keeps to its limit and new pieces still get room
+func TestReaderRangeClampedToBuffer(t *testing.T) {
+	const (
+		pieceLength = 16 * 1024
+		numPieces   = 64
+		bufferLimit = 10
+	)
+
+	harness := lt.NewMemoryDiskTestHarness()
+	defer lt.DeleteMemoryDiskTestHarness(harness)
+
+	storage := harness.Add_storage(pieceLength, numPieces)
+	harness.Set_memory_size(8 * pieceLength) // buffer_limit = 10 pieces
+
+	lookbehind := lt.NewStdVectorInt()
+	defer lt.DeleteStdVectorInt(lookbehind)
+	lookbehind.Add(50)
+	lookbehind.Add(51)
+	harness.Set_lookbehind(storage, lookbehind)
+
+	// Far more pieces than the buffer can hold
+	harness.Set_reader_range(storage, 1, 0, 39)
+
+	block := string(make([]byte, pieceLength))
+	for piece := 0; piece < numPieces; piece++ {
+		if !harness.Write_block(storage, piece, 0, block) {
+			t.Fatalf("Write failed for piece %d", piece)
+		}
+	}
+
+	resident := 0
+	for piece := 0; piece < numPieces; piece++ {
+		if harness.Read_block(storage, piece, 0, pieceLength) != "" {
+			resident++
+		}
+	}
+	if resident > bufferLimit {
+		t.Errorf("Buffer holds %d pieces, limit is %d", resident, bufferLimit)
+	}
+
+	// The start of the range fills what the lookbehind pieces leave free,
+	// minus one slot kept for new pieces
+	for piece := 0; piece < bufferLimit-1-2; piece++ {
+		if harness.Read_block(storage, piece, 0, pieceLength) == "" {
+			t.Errorf("Piece %d at the start of the reader range was evicted", piece)
+		}
+	}
+	for _, piece := range []int{50, 51, numPieces - 1} {
+		if harness.Read_block(storage, piece, 0, pieceLength) == "" {
+			t.Errorf("Piece %d was evicted", piece)
+		}
+	}
+}
+
+// TestCacheStats verifies hit/miss and eviction accounting per storage
+func TestCacheStats(t *testing.T) {
+	const (
+		pieceLength = 16 * 1024
+		numPieces   = 32
+	)
+
+	harness := lt.NewMemoryDiskTestHarness()
+	defer lt.DeleteMemoryDiskTestHarness(harness)
+
+	storage := harness.Add_storage(pieceLength, numPieces)
+	harness.Set_memory_size(int64(numPieces) * pieceLength)
+
+	block := string(make([]byte, pieceLength))
+	for piece := 0; piece < 8; piece++ {
+		harness.Write_block(storage, piece, 0, block)
+	}
+
+	harness.Read_block(storage, 0, 0, pieceLength)  // hit
+	harness.Read_block(storage, 20, 0, pieceLength) // miss, never written
+
+	// Shrink to 3 pieces - evicts by capacity
+	harness.Set_memory_size(pieceLength)
+	harness.Read_block(storage, 0, 0, pieceLength) // miss, evicted
+
+	stats := harness.Cache_stats(storage)
+	defer lt.DeleteMemoryStorageCacheStats(stats)
+
+	if stats.GetRead_hits() != 1 {
+		t.Errorf("Expected 1 hit, got %d", stats.GetRead_hits())
+	}
+	if stats.GetRead_misses() != 2 {
+		t.Errorf("Expected 2 misses, got %d", stats.GetRead_misses())
+	}
+	if stats.GetRead_misses_evicted() != 1 {
+		t.Errorf("Expected 1 miss on an evicted piece, got %d", stats.GetRead_misses_evicted())
+	}
+	if stats.GetBytes_served() != pieceLength {
+		t.Errorf("Expected %d bytes served, got %d", pieceLength, stats.GetBytes_served())
+	}
+	if stats.GetEvictions_capacity() != 5 {
+		t.Errorf("Expected 5 capacity evictions, got %d", stats.GetEvictions_capacity())
+	}
+	if stats.GetEvictions_lru() != 0 {
+		t.Errorf("Expected no LRU evictions, got %d", stats.GetEvictions_lru())
+	}
+}
+
+// TestThreadedHashing verifies pieces hashed on the hashing pool match a
+// Go-side SHA-1, with many hash jobs in flight at once
+func TestThreadedHashing(t *testing.T) {
+	const (
+		pieceLength = 64 * 1024
+		numPieces   = 16
+	)
+
+	harness := lt.NewMemoryDiskTestHarness()
+	defer lt.DeleteMemoryDiskTestHarness(harness)
+
+	// A new harness has no memory limit, so every piece stays in memory
+	storage := harness.Add_storage(pieceLength, numPieces)
+
+	pieces := make([][]byte, numPieces)
+	for piece := range pieces {
+		pieces[piece] = make([]byte, pieceLength)
+		for i := range pieces[piece] {
+			pieces[piece][i] = byte(piece + i)
+		}
+		if !harness.Write_block(storage, piece, 0, string(pieces[piece])) {
+			t.Fatalf("Write failed for piece %d", piece)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for piece := range pieces {
+		wg.Add(1)
+		go func(piece int) {
+			defer wg.Done()
+			want := fmt.Sprintf("%x", sha1.Sum(pieces[piece]))
+			if got := harness.Hash_piece(storage, piece); got != want {
+				t.Errorf("Piece %d hash mismatch: expected %s, got %s", piece, want, got)
+			}
+		}(piece)
+	}
+	wg.Wait()
+
+	// Missing pieces report an error instead of blocking
+	if got := harness.Hash_piece(storage, numPieces+5); got != "" {
+		t.Errorf("Expected error for missing piece, got hash %s", got)
+	}
+}
@@ -6,6 +6,8 @@
 package upgrade_test
 
 import (
+	"errors"
+	"fmt"
 	"sync"
 	"testing"
 	"time"
@@ -69,6 +71,310 @@ func TestRemovedSettings(t *testing.T) {
 	t.Log("Removed settings handled correctly")
 }
 
+// TestSettingsCompatibility verifies the compatibility table drops removed
+// settings, translates renamed ones, warns once and validates whole configs
+func TestSettingsCompatibility(t *testing.T) {
+	var warnings []string
+	prev := lt.SettingsWarningf
+	lt.SettingsWarningf = func(format string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+	defer func() { lt.SettingsWarningf = prev }()
+
+	settings := lt.NewSettingsPack()
+	defer settings.Delete()
+
+	// Removed settings are ignored, with one warning per setting
+	settings.SetInt("cache_expiry", 60)
+	settings.SetInt("cache_expiry", 120)
+	if n := len(warnings); n != 1 {
+		t.Errorf("Expected 1 warning for repeated removed setting, got %d: %v", n, warnings)
+	}
+
+	// Renamed settings reach their new name
+	settings.SetInt("peer_tos", 0x20)
+	if got := settings.GetInt("peer_dscp"); got != 0x20 {
+		t.Errorf("Expected peer_tos to set peer_dscp=32, got %d", got)
+	}
+
+	c, ok := lt.LookupSettingCompat("use_read_cache")
+	if !ok || !c.IsRemoved() || c.Type != lt.SettingTypeBool {
+		t.Errorf("Unexpected compat entry for use_read_cache: %+v", c)
+	}
+
+	report := lt.ValidateSettings(map[string]interface{}{
+		"connections_limit": 200,
+		"cache_size":        1024,
+		"peer_tos":          32,
+		"use_write_cache":   "yes",
+		"no_such_setting":   true,
+		"user_agent":        5,
+		"enable_dht":        "true",
+	})
+	issues := make(map[string]string)
+	for _, issue := range report.Issues {
+		issues[issue.Name] = issue.Level
+	}
+	expected := map[string]string{
+		"cache_size":      "warning",
+		"peer_tos":        "warning",
+		"use_write_cache": "warning",
+		"no_such_setting": "error",
+		"user_agent":      "error", // wrong types outside the compat table
+		"enable_dht":      "error",
+	}
+	for name, level := range expected {
+		if issues[name] != level {
+			t.Errorf("Expected %s issue for %s, got %q", level, name, issues[name])
+		}
+	}
+	if _, ok := issues["connections_limit"]; ok {
+		t.Error("Unexpected issue for connections_limit")
+	}
+	if report.OK() {
+		t.Error("Expected report with errors to not be OK")
+	}
+}
+
+// TestTypedSettings verifies typed accessors report unknown, removed and
+// mistyped settings, and ApplyMap reports every failure
+func TestTypedSettings(t *testing.T) {
+	settings := lt.NewSettingsPack()
+	defer settings.Delete()
+
+	if err := settings.SetIntValue("connections_limit", 123); err != nil {
+		t.Fatalf("SetIntValue failed: %v", err)
+	}
+	if v, err := settings.IntValue("connections_limit"); err != nil || v != 123 {
+		t.Errorf("IntValue: expected 123, got %d (%v)", v, err)
+	}
+
+	if _, err := settings.BoolValue("no_such_setting"); !errors.Is(err, lt.ErrUnknownSetting) {
+		t.Errorf("Expected ErrUnknownSetting, got %v", err)
+	}
+	if err := settings.SetBoolValue("connections_limit", true); !errors.Is(err, lt.ErrSettingType) {
+		t.Errorf("Expected ErrSettingType, got %v", err)
+	}
+	if err := settings.SetIntValue("cache_size", 1024); !errors.Is(err, lt.ErrSettingRemoved) {
+		t.Errorf("Expected ErrSettingRemoved, got %v", err)
+	}
+
+	var nilPack *lt.SettingsPack
+	if _, err := nilPack.IntValue("connections_limit"); !errors.Is(err, lt.ErrInvalidSettingsPack) {
+		t.Errorf("Expected ErrInvalidSettingsPack, got %v", err)
+	}
+
+	err := settings.ApplyMap(map[string]any{
+		"enable_dht":               "false",
+		"user_agent":               "Test/1.0",
+		"active_downloads":         float64(7),
+		"peer_timeout":             "45",
+		"use_read_cache":           true,
+		"request_timeout":          "soon",
+		"no_such_setting":          1,
+		"announce_to_all_trackers": 1,
+	})
+	var errs lt.SettingErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Expected SettingErrors, got %v", err)
+	}
+	if len(errs) != 4 {
+		t.Errorf("Expected 4 failures, got %d: %v", len(errs), errs)
+	}
+
+	if settings.GetBool("enable_dht") || settings.GetStr("user_agent") != "Test/1.0" ||
+		settings.GetInt("active_downloads") != 7 || settings.GetInt("peer_timeout") != 45 {
+		t.Error("Valid ApplyMap entries were not applied")
+	}
+}
+
+// addTestStorage adds a torrent with metadata and zeroed piece hashes and
+// waits until the session created its memory storage
+func addTestStorage(t *testing.T, session *lt.Session, diskIO *lt.MemoryDiskIO, pieceLength, numPieces int) {
+	t.Helper()
+
+	torrentFile := fmt.Sprintf("d4:infod6:lengthi%de4:name8:test.bin12:piece lengthi%de6:pieces%d:%see",
+		pieceLength*numPieces, pieceLength, 20*numPieces, make([]byte, 20*numPieces))
+	ti, err := lt.NewTorrentInfoFromBuffer([]byte(torrentFile))
+	if err != nil {
+		t.Fatalf("Failed to load torrent: %v", err)
+	}
+	addParams := lt.NewAddTorrentParams()
+	addParams.SavePath = t.TempDir()
+	addParams.SetTorrentInfo(ti)
+	lt.DeleteTorrentInfo(ti)
+	if _, err := session.AddTorrent(addParams); err != nil {
+		t.Fatalf("Failed to add torrent: %v", err)
+	}
+
+	// The storage is created on the session thread
+	deadline := time.Now().Add(5 * time.Second)
+	for len(diskIO.StorageLimits()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Storage was not created")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestSettingsSnapshotDiff verifies the live session's settings can be
+// snapshotted and diffed against defaults and earlier snapshots
+func TestSettingsSnapshotDiff(t *testing.T) {
+	settings := lt.NewSettingsPack()
+	settings.SetInt("connections_limit", 123)
+	params := lt.NewSessionParams()
+	params.SetSettings(settings)
+
+	if got := params.GetSettings().GetInt("connections_limit"); got != 123 {
+		t.Errorf("SessionParams.GetSettings: expected connections_limit 123, got %d", got)
+	}
+
+	session, err := lt.CreateSessionWithParams(params)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer lt.DeleteSession(session)
+
+	before, err := session.SnapshotSettings()
+	if err != nil {
+		t.Fatalf("SnapshotSettings failed: %v", err)
+	}
+	if len(before) < 100 {
+		t.Errorf("Expected a full settings snapshot, got %d entries", len(before))
+	}
+
+	changed, err := before.DiffFromDefaults()
+	if err != nil {
+		t.Fatalf("DiffFromDefaults failed: %v", err)
+	}
+	found := false
+	for _, c := range changed {
+		if c.Name == "connections_limit" {
+			found = c.New == 123
+		}
+	}
+	if !found {
+		t.Errorf("Expected connections_limit=123 in diff from defaults: %v", changed)
+	}
+
+	update := lt.NewSettingsPack()
+	defer update.Delete()
+	update.SetInt("peer_timeout", 77)
+	if err := session.ApplySettings(update); err != nil {
+		t.Fatalf("ApplySettings failed: %v", err)
+	}
+
+	after, err := session.SnapshotSettings()
+	if err != nil {
+		t.Fatalf("SnapshotSettings failed: %v", err)
+	}
+	diff := after.Diff(before)
+	if len(diff) != 1 || diff[0].Name != "peer_timeout" || diff[0].New != 77 {
+		t.Errorf("Expected only peer_timeout to change, got %v", diff)
+	}
+}
+
+// TestSettingsProfiles verifies built-in profiles apply through SettingsPack
+// and custom profiles survive a JSON export/import round trip
+func TestSettingsProfiles(t *testing.T) {
+	for _, name := range []string{lt.ProfileStreaming, lt.ProfileSeeding, lt.ProfileLowMemory, lt.ProfileMetered} {
+		profile, err := lt.GetProfile(name)
+		if err != nil {
+			t.Fatalf("Built-in profile %s missing: %v", name, err)
+		}
+		if err := profile.Validate(); err != nil {
+			t.Errorf("Built-in profile %s invalid: %v", name, err)
+		}
+	}
+
+	settings := lt.NewSettingsPack()
+	defer settings.Delete()
+	settings.ConfigureForStreaming()
+	if got := settings.GetInt("connections_limit"); got != 200 {
+		t.Errorf("Expected streaming connections_limit 200, got %d", got)
+	}
+	if err := settings.ApplyProfile(lt.ProfileLowMemory); err != nil {
+		t.Fatalf("ApplyProfile failed: %v", err)
+	}
+	if got := settings.GetInt("connections_limit"); got != 50 {
+		t.Errorf("Expected low-memory connections_limit 50, got %d", got)
+	}
+
+	custom := []byte(`{"name": "test-custom", "version": 3, "ints": {"connections_limit": 77}, "bools": {"enable_dht": false}}`)
+	if _, err := lt.ImportProfile(custom); err != nil {
+		t.Fatalf("ImportProfile failed: %v", err)
+	}
+	defer lt.UnregisterProfile("test-custom")
+
+	exported, err := lt.ExportProfile("test-custom")
+	if err != nil {
+		t.Fatalf("ExportProfile failed: %v", err)
+	}
+	lt.UnregisterProfile("test-custom")
+	imported, err := lt.ImportProfile(exported)
+	if err != nil {
+		t.Fatalf("Re-import failed: %v", err)
+	}
+	if imported.Version != 3 || imported.Ints["connections_limit"] != 77 || imported.Bools["enable_dht"] {
+		t.Errorf("Round trip changed profile: %+v", imported)
+	}
+
+	// Built-ins cannot be replaced and unknown settings are rejected
+	if _, err := lt.ImportProfile([]byte(`{"name": "streaming", "version": 9}`)); err == nil {
+		t.Error("Expected error replacing built-in profile")
+	}
+	if _, err := lt.ImportProfile([]byte(`{"name": "bad", "version": 1, "ints": {"no_such_setting": 1}}`)); err == nil {
+		t.Error("Expected error for unknown setting")
+	}
+}
+
+// TestSwitchProfileRestoresDefaults verifies settings only the previous
+// profile set go back to libtorrent's defaults, not to zero
+func TestSwitchProfileRestoresDefaults(t *testing.T) {
+	defaults := lt.DefaultSettingsPack()
+	defer defaults.Delete()
+	defaultTimeout := defaults.GetInt("request_timeout")
+	if defaultTimeout == 0 || defaultTimeout == 10 {
+		t.Fatalf("Unexpected default request_timeout %d", defaultTimeout)
+	}
+
+	streaming, _ := lt.GetProfile(lt.ProfileStreaming)
+	seeding, _ := lt.GetProfile(lt.ProfileSeeding)
+	if _, ok := seeding.Ints["request_timeout"]; ok {
+		t.Fatal("Seeding profile sets request_timeout")
+	}
+
+	settings := streaming.SwitchTo(seeding, nil)
+	defer settings.Delete()
+	if got := settings.GetInt("request_timeout"); got != defaultTimeout {
+		t.Errorf("SwitchTo: expected request_timeout %d, got %d", defaultTimeout, got)
+	}
+	if got := settings.GetInt("connections_limit"); got != 400 {
+		t.Errorf("SwitchTo: expected seeding connections_limit 400, got %d", got)
+	}
+
+	// The same through a running session
+	params := lt.NewSessionParams()
+	session, err := lt.CreateSessionWithParams(params)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer lt.DeleteSession(session)
+
+	for _, name := range []string{lt.ProfileStreaming, lt.ProfileSeeding} {
+		if err := session.ApplyProfile(name); err != nil {
+			t.Fatalf("ApplyProfile %s failed: %v", name, err)
+		}
+	}
+	snapshot, err := session.SnapshotSettings()
+	if err != nil {
+		t.Fatalf("SnapshotSettings failed: %v", err)
+	}
+	if got := snapshot["request_timeout"]; got != defaultTimeout {
+		t.Errorf("Session: expected request_timeout %d, got %v", defaultTimeout, got)
+	}
+}
+
 // TestInfoHashT tests the new info_hash_t type with v1/v2 support
 func TestInfoHashT(t *testing.T) {
 	// Create add_torrent_params
@@ -159,6 +465,66 @@ func TestDiskInterfaceArchitecture(t *testing.T) {
 	t.Log("Disk interface architecture verified")
 }
 
+// TestSetMemorySize tests resizing the memory budget of a live session
+func TestSetMemorySize(t *testing.T) {
+	settings := lt.NewSettingsPack()
+	params := lt.NewSessionParams()
+	params.SetSettings(settings)
+	params.SetMemoryDiskIO(100 * 1024 * 1024)
+
+	session, err := lt.CreateSessionWithParams(params)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer lt.DeleteSession(session)
+
+	diskIO := lt.NewMemoryDiskIO(session)
+
+	// No torrents yet - nothing to resize, but the call must not fail
+	limits, err := diskIO.SetMemorySize(20 * 1024 * 1024)
+	if err != nil {
+		t.Fatalf("SetMemorySize failed: %v", err)
+	}
+	if len(limits) != 0 {
+		t.Errorf("Expected no storage limits without torrents, got %d", len(limits))
+	}
+
+	// Growing back must also work without a new session
+	if _, err := diskIO.SetMemorySize(200 * 1024 * 1024); err != nil {
+		t.Fatalf("SetMemorySize failed: %v", err)
+	}
+
+	// An unlimited or negative budget cannot be set on a live session
+	for _, size := range []int64{0, -1} {
+		if _, err := diskIO.SetMemorySize(size); err == nil {
+			t.Errorf("SetMemorySize(%d) succeeded", size)
+		}
+	}
+
+	// With a torrent, its live storage follows the budget both ways
+	const (
+		pieceLength = 16 * 1024
+		numPieces   = 256
+	)
+	addTestStorage(t, session, diskIO, pieceLength, numPieces)
+
+	// 1 MiB of 16 KiB pieces plus the two spare pieces
+	want := 1024*1024/pieceLength + 2
+	limits, _ = diskIO.SetMemorySize(1024 * 1024)
+	if len(limits) != 1 || limits[0].BufferLimit != want {
+		t.Fatalf("Expected the storage shrunk to %d pieces, got %+v", want, limits)
+	}
+	if got := diskIO.StorageLimits(); got[0].BufferLimit != want {
+		t.Errorf("Storage reports %d pieces after the shrink, want %d", got[0].BufferLimit, want)
+	}
+
+	// Growing past the torrent's size makes room for all of it again
+	limits, _ = diskIO.SetMemorySize(200 * 1024 * 1024)
+	if len(limits) != 1 || limits[0].BufferLimit != numPieces {
+		t.Errorf("Expected the storage grown to %d pieces, got %+v", numPieces, limits)
+	}
+}
+
 // TestSessionStateSaveLoad tests new session state API
 func TestSessionStateSaveLoad(t *testing.T) {
 	// Create session
@@ -317,6 +683,78 @@ func BenchmarkAsyncOperations(b *testing.B) {
 	}
 }
 
+// BenchmarkZeroCopyRead compares the zero-copy read path against copying
+// every block, using piece sizes typical for 4K remuxes
+func BenchmarkZeroCopyRead(b *testing.B) {
+	const (
+		pieceLength = 16 * 1024 * 1024
+		numPieces   = 8
+	)
+
+	b.ReportAllocs()
+	var bytesRead, copyNs, copyAllocs, zeroCopyNs, zeroCopyAllocs int64
+	for i := 0; i < b.N; i++ {
+		result := lt.MemoryDiskBenchmarkRead(pieceLength, numPieces, 1)
+		if result.GetBytes_read() < 0 {
+			b.Fatal("Copy and zero-copy paths read different amounts of data")
+		}
+		bytesRead += result.GetBytes_read()
+		copyNs += result.GetCopy_ns()
+		copyAllocs += result.GetCopy_allocations()
+		zeroCopyNs += result.GetZero_copy_ns()
+		zeroCopyAllocs += result.GetZero_copy_allocations()
+		lt.DeleteMemoryDiskReadBenchmark(result)
+	}
+
+	if zeroCopyAllocs >= copyAllocs {
+		b.Errorf("Zero-copy path allocated %d buffers, copy path %d", zeroCopyAllocs, copyAllocs)
+	}
+
+	seconds := func(ns int64) float64 { return float64(ns) / float64(time.Second) }
+	b.ReportMetric(float64(bytesRead)/1024/1024/seconds(zeroCopyNs), "zerocopy-MB/s")
+	b.ReportMetric(float64(bytesRead)/1024/1024/seconds(copyNs), "copy-MB/s")
+	b.ReportMetric(float64(zeroCopyAllocs)/float64(b.N), "zerocopy-allocs/op")
+	b.ReportMetric(float64(copyAllocs)/float64(b.N), "copy-allocs/op")
+}
+
+// BenchmarkEvictionWritePath measures the write path of a 20k-piece torrent
+// once the memory buffer is full and every new piece evicts an old one
+func BenchmarkEvictionWritePath(b *testing.B) {
+	const (
+		numPieces       = 20000
+		pieceLength     = 16 * 1024
+		bufferPieces    = 2000
+		lookbehindCount = 500
+	)
+
+	var totalNs int64
+	for i := 0; i < b.N; i++ {
+		totalNs += lt.MemoryStorageBenchmarkWrites(numPieces, pieceLength, bufferPieces, lookbehindCount)
+	}
+	b.ReportMetric(float64(totalNs)/float64(b.N*numPieces), "ns/write")
+}
+
+// BenchmarkLookbehindAvailableCount measures the lookbehind available
+// counter on a 20k-piece torrent
+func BenchmarkLookbehindAvailableCount(b *testing.B) {
+	const (
+		numPieces       = 20000
+		pieceLength     = 16 * 1024
+		lookbehindCount = 500
+		calls           = 10000
+	)
+
+	var totalNs int64
+	for i := 0; i < b.N; i++ {
+		ns := lt.MemoryStorageBenchmarkLookbehindCount(numPieces, pieceLength, lookbehindCount, calls)
+		if ns < 0 {
+			b.Fatal("Lookbehind available count does not match protected pieces in memory")
+		}
+		totalNs += ns
+	}
+	b.ReportMetric(float64(totalNs)/float64(b.N*calls), "ns/call")
+}
+
 // TestConcurrentSessionCreation tests thread safety of session creation
 func TestConcurrentSessionCreation(t *testing.T) {
 	var wg sync.WaitGroup